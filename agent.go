@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// kaj agent keeps the keys of encrypted databases in memory and hands
+// them to kaj processes of the same user over a Unix socket, so the
+// passphrase is not asked for by every command. Each connection sends
+// one JSON request line and reads one JSON response line:
+//
+//	{"op": "get", "salt": "..."}               -> {"key": "..."} or {}
+//	{"op": "put", "salt": "...", "key": "..."} -> {}
+//	{"op": "stop"}                             -> {}
+//
+// Keys are found by the salt of their database and forgotten after the
+// agent's timeout.
+
+type agentRequest struct {
+	Op   string `json:"op"`
+	Salt string `json:"salt,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+type agentResponse struct {
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func getAgentSocketPath() (string, error) {
+	if path := os.Getenv("KAJ_AGENT_SOCK"); path != "" {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, ".todos", "agent.sock"), nil
+}
+
+// listenPrivate listens on a Unix socket at path that only the user can
+// connect to. The socket is created and made private in a directory of
+// its own, which MkdirTemp creates with mode 0700, and then moved to
+// path, so no other user can connect between its creation and chmod.
+func listenPrivate(path string) (*net.UnixListener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".agent-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "agent.sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// agentKey is a key held by the agent.
+type agentKey struct {
+	key     string
+	expires time.Time
+}
+
+// runAgent serves keys on the socket until ctx is done or a stop request
+// comes. A timeout of 0 keeps keys until the agent stops.
+func runAgent(ctx context.Context, path string, timeout time.Duration) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("an agent is already running on %s", path)
+	}
+	os.Remove(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var mu sync.Mutex
+	keys := map[string]agentKey{}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			var request agentRequest
+			var response agentResponse
+			line, err := bufio.NewReader(conn).ReadBytes('\n')
+			if err == nil {
+				err = json.Unmarshal(line, &request)
+			}
+			if err != nil {
+				response.Error = "invalid request"
+				json.NewEncoder(conn).Encode(response)
+				return
+			}
+
+			mu.Lock()
+			switch request.Op {
+			case "get":
+				if k, ok := keys[request.Salt]; ok && (k.expires.IsZero() || time.Now().Before(k.expires)) {
+					response.Key = k.key
+				} else {
+					delete(keys, request.Salt)
+				}
+			case "put":
+				k := agentKey{key: request.Key}
+				if timeout > 0 {
+					k.expires = time.Now().Add(timeout)
+				}
+				keys[request.Salt] = k
+			case "stop":
+			default:
+				response.Error = fmt.Sprintf("unknown op %q", request.Op)
+			}
+			mu.Unlock()
+
+			json.NewEncoder(conn).Encode(response)
+			if request.Op == "stop" {
+				stop()
+			}
+		}()
+	}
+}
+
+// callAgent sends one request to the agent.
+func callAgent(request agentRequest) (agentResponse, error) {
+	var response agentResponse
+
+	path, err := getAgentSocketPath()
+	if err != nil {
+		return response, err
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return response, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return response, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return response, err
+	}
+	if err := json.Unmarshal(line, &response); err != nil {
+		return response, err
+	}
+	if response.Error != "" {
+		return response, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+// agentGetKey returns the key the agent holds for salt, or nil.
+func agentGetKey(salt []byte) ([]byte, error) {
+	response, err := callAgent(agentRequest{Op: "get", Salt: saltID(salt)})
+	if err != nil || response.Key == "" {
+		return nil, err
+	}
+	return hex.DecodeString(response.Key)
+}
+
+func agentPutKey(salt, key []byte) error {
+	_, err := callAgent(agentRequest{Op: "put", Salt: saltID(salt), Key: hex.EncodeToString(key)})
+	return err
+}
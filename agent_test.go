@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestAgent runs an agent, and expects its socket to be private and to
+// hand out the keys put in it.
+func TestAgent(t *testing.T) {
+	// Not t.TempDir, whose long paths can exceed the limit on socket
+	// paths.
+	dir, err := os.MkdirTemp("", "kaj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agent.sock")
+	t.Setenv("KAJ_AGENT_SOCK", path)
+
+	done := make(chan error, 1)
+	go func() { done <- runAgent(t.Context(), path, time.Minute) }()
+	for i := 0; ; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("no socket")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("socket mode %o", mode)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("files next to the socket: %v", entries)
+	}
+
+	salt, key := []byte("salt"), []byte("key")
+	if err := agentPutKey(salt, key); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := agentGetKey(salt); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("key = %q, %v", got, err)
+	}
+
+	if _, err := callAgent(agentRequest{Op: "stop"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket after stop: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// backupSchemaVersion is bumped whenever the JSON backup layout changes
+// in a way older versions of kaj could not read.
+const backupSchemaVersion = 1
+
+// Backup is the JSON document written by kaj export --format json. It
+// holds the todos and the trash only: the archive, the log, linked
+// commits and external IDs stay in the database, which kaj db backup
+// copies whole.
+type Backup struct {
+	SchemaVersion int           `json:"schema_version"`
+	KajVersion    string        `json:"kaj_version"`
+	ExportedAt    time.Time     `json:"exported_at"`
+	Todos         []Todo        `json:"todos"`
+	Trash         []DeletedTodo `json:"trash"`
+}
+
+func writeBackup(ctx context.Context, w io.Writer, db *Database) error {
+	todos, err := db.GetTodos(ctx)
+	if err != nil {
+		return err
+	}
+
+	trash, err := db.GetDeletedTodos(ctx)
+	if err != nil {
+		return err
+	}
+
+	backup := Backup{
+		SchemaVersion: backupSchemaVersion,
+		KajVersion:    Version,
+		ExportedAt:    time.Now().UTC(),
+		Todos:         todos,
+		Trash:         trash,
+	}
+	if backup.Todos == nil {
+		backup.Todos = []Todo{}
+	}
+	if backup.Trash == nil {
+		backup.Trash = []DeletedTodo{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(backup)
+}
+
+func readBackup(r io.Reader) (*Backup, error) {
+	var backup Backup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return nil, err
+	}
+
+	if backup.SchemaVersion == 0 {
+		return nil, fmt.Errorf("not a kaj backup: missing schema_version")
+	}
+	if backup.SchemaVersion > backupSchemaVersion {
+		return nil, fmt.Errorf("backup schema version %d is newer than supported version %d", backup.SchemaVersion, backupSchemaVersion)
+	}
+
+	for _, todo := range backup.Todos {
+		if todo.Status != "" && !kaj.ValidStatus(todo.Status) {
+			return nil, fmt.Errorf("todo %d has invalid status %q", todo.ID, todo.Status)
+		}
+	}
+
+	return &backup, nil
+}
+
+// RestoreBackup loads a backup in one transaction, see
+// kaj.SQLiteStore.RestoreTodos for the modes.
+func (d *Database) RestoreBackup(ctx context.Context, backup *Backup, mode string, dryRun bool) (*kaj.RestoreResult, error) {
+	return d.RestoreTodos(ctx, backup.Todos, backup.Trash, mode, dryRun)
+}
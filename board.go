@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var statusTitles = map[string]string{
+	StatusTodo:  "Todo",
+	StatusDoing: "Doing",
+	StatusDone:  "Done",
+}
+
+// boardColumn returns the indexes into m.todos of the todos in a column,
+// in position order.
+func (m model) boardColumn(col int) []int {
+	var indexes []int
+	for i, todo := range m.todos {
+		if todo.Status == Statuses[col] {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// boardRow returns the row of the cursor within the focused column, or
+// -1 when the cursor is on a todo in another column.
+func (m model) boardRow() int {
+	for row, i := range m.boardColumn(m.boardCol) {
+		if i == m.cursor {
+			return row
+		}
+	}
+	return -1
+}
+
+// followCursor focuses the board column of the todo under the cursor, so
+// the selection follows a todo after it is toggled, added or restored.
+func (m *model) followCursor() {
+	if m.view != "board" || len(m.todos) == 0 {
+		return
+	}
+	for col, status := range Statuses {
+		if m.todos[m.cursor].Status == status {
+			m.boardCol = col
+			return
+		}
+	}
+}
+
+// focusColumn moves the focus to another column, keeping the row when
+// the new column is long enough.
+func (m *model) focusColumn(col int) {
+	row := m.boardRow()
+	m.boardCol = col
+
+	column := m.boardColumn(col)
+	if len(column) == 0 {
+		return
+	}
+	if row < 0 {
+		row = 0
+	}
+	if row >= len(column) {
+		row = len(column) - 1
+	}
+	m.cursor = column[row]
+}
+
+func (m model) updateBoard(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	row := m.boardRow()
+	column := m.boardColumn(m.boardCol)
+
+	switch {
+	case m.keys.Board.matches(msg):
+		m.view = "list"
+		m.mode = "list"
+
+	case m.keys.Up.matches(msg):
+		if row > 0 {
+			m.cursor = column[row-1]
+		}
+
+	case m.keys.Down.matches(msg):
+		if row >= 0 && row < len(column)-1 {
+			m.cursor = column[row+1]
+		}
+
+	case m.keys.FocusLeft.matches(msg):
+		if m.boardCol > 0 {
+			m.focusColumn(m.boardCol - 1)
+		}
+
+	case m.keys.FocusRight.matches(msg):
+		if m.boardCol < len(Statuses)-1 {
+			m.focusColumn(m.boardCol + 1)
+		}
+
+	case m.keys.MoveLeft.matches(msg):
+		if row >= 0 && m.boardCol > 0 {
+			return m.moveToColumn(m.boardCol - 1)
+		}
+
+	case m.keys.MoveRight.matches(msg):
+		if row >= 0 && m.boardCol < len(Statuses)-1 {
+			return m.moveToColumn(m.boardCol + 1)
+		}
+
+	case m.keys.MoveUp.matches(msg):
+		if row > 0 {
+			return m.reorderInColumn(-1)
+		}
+
+	case m.keys.MoveDown.matches(msg):
+		if row >= 0 && row < len(column)-1 {
+			return m.reorderInColumn(1)
+		}
+
+	case m.keys.MoveTop.matches(msg):
+		if row > 0 {
+			return m.moveSelectedTo(column[0])
+		}
+
+	case m.keys.MoveBottom.matches(msg):
+		if row >= 0 && row < len(column)-1 {
+			return m.moveSelectedTo(column[len(column)-1])
+		}
+
+	case m.keys.Add.matches(msg), m.keys.Refresh.matches(msg), m.keys.Undo.matches(msg),
+		m.keys.Help.matches(msg), m.keys.Quit.matches(msg), msg.String() == "ctrl+c":
+		return m.delegateToList(msg)
+
+	case m.keys.Toggle.matches(msg), m.keys.Edit.matches(msg), m.keys.Delete.matches(msg):
+		// These act on m.cursor, which only points into the focused
+		// column when that column is not empty.
+		if row >= 0 {
+			return m.delegateToList(msg)
+		}
+	}
+
+	return m, nil
+}
+
+// delegateToList runs the list handler for actions that behave the same
+// in both views, then refocuses the column of the affected todo.
+func (m model) delegateToList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	next, cmd := m.updateList(msg)
+	if updated, ok := next.(model); ok {
+		updated.followCursor()
+		return updated, cmd
+	}
+	return next, cmd
+}
+
+func (m model) moveToColumn(col int) (tea.Model, tea.Cmd) {
+	todo := m.todos[m.cursor]
+	if err := m.db.SetStatus(context.Background(), todo.ID, Statuses[col]); err != nil {
+		return m, m.setError(err)
+	}
+
+	if err := m.reload(); err != nil {
+		return m, m.setError(err)
+	}
+	return m, nil
+}
+
+func (m model) reorderInColumn(direction int) (tea.Model, tea.Cmd) {
+	id := m.todos[m.cursor].ID
+	if err := m.db.MoveTodo(context.Background(), id, direction, true); err != nil {
+		return m, m.setError(err)
+	}
+
+	todos, err := m.db.GetTodos(context.Background())
+	if err != nil {
+		return m, m.setError(err)
+	}
+	m.todos = todos
+	for i, todo := range m.todos {
+		if todo.ID == id {
+			m.cursor = i
+		}
+	}
+	return m, nil
+}
+
+func (m model) boardView() string {
+	width := m.width
+	if width == 0 {
+		width = 80
+	}
+
+	const gap = 2
+	colWidth := (width - gap*(len(Statuses)-1)) / len(Statuses)
+	if colWidth < 10 {
+		colWidth = 10
+	}
+
+	columns := make([]string, 0, len(Statuses)*2-1)
+	for col, status := range Statuses {
+		indexes := m.boardColumn(col)
+
+		var s strings.Builder
+		header := fmt.Sprintf("%s (%d)", statusTitles[status], len(indexes))
+		if col == m.boardCol {
+			header = selectedStyle.Render(header)
+		} else {
+			header = helpStyle.Render(header)
+		}
+		s.WriteString(header)
+		s.WriteString("\n")
+		s.WriteString(helpStyle.Render(strings.Repeat("─", colWidth)))
+		s.WriteString("\n")
+
+		for _, i := range indexes {
+			todo := m.todos[i]
+			selected := col == m.boardCol && i == m.cursor
+
+			cursor := "  "
+			if selected {
+				cursor = "> "
+			}
+
+			text := todo.Text
+			if todo.Branch != "" {
+				text += " " + branchBadge + todo.Branch
+			}
+			text = lipgloss.NewStyle().Width(colWidth - 2).Render(text)
+			text = strings.ReplaceAll(text, "\n", "\n  ")
+			switch {
+			case selected:
+				text = selectedStyle.Render(text)
+			case todo.Done:
+				text = doneStyle.Render(text)
+			}
+
+			s.WriteString(cursor + text)
+			s.WriteString("\n")
+		}
+
+		if col > 0 {
+			columns = append(columns, strings.Repeat(" ", gap))
+		}
+		columns = append(columns, lipgloss.NewStyle().Width(colWidth).Render(s.String()))
+	}
+
+	return lipgloss.JoinHorizontal(lipgloss.Top, columns...)
+}
+
+func (m model) boardFooterHelp() string {
+	bindings := []keyBinding{m.keys.MoveLeft, m.keys.MoveRight, m.keys.FocusRight, m.keys.Add, m.keys.Board, m.keys.Help, m.keys.Quit}
+
+	parts := make([]string, len(bindings))
+	for i, b := range bindings {
+		parts[i] = fmt.Sprintf("%s: %s", b.help(), strings.TrimPrefix(b.desc, "board: "))
+	}
+	return strings.Join(parts, " • ")
+}
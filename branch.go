@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Branch-scoped todos record the git branch they were added on, so that
+// kaj list --branch can show only the work of the checked out branch and
+// kaj branch-clean can archive the todos of branches that were deleted.
+
+// branchBadge marks the branch of a todo in listings.
+const branchBadge = "⎇ "
+
+// currentBranch returns the checked out branch of the git repository in
+// the current directory. It also works on a branch without commits yet.
+func currentBranch() (string, error) {
+	branch, err := git("symbolic-ref", "--short", "-q", "HEAD")
+	if err != nil || branch == "" {
+		return "", fmt.Errorf("not on a git branch")
+	}
+	return branch, nil
+}
+
+func localBranches() (map[string]bool, error) {
+	out, err := git("for-each-ref", "--format=%(refname:short)", "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+
+	// A new branch without commits has no ref yet but still exists.
+	branches := map[string]bool{}
+	if current, err := currentBranch(); err == nil {
+		branches[current] = true
+	}
+	for _, branch := range strings.Split(out, "\n") {
+		if branch != "" {
+			branches[branch] = true
+		}
+	}
+	return branches, nil
+}
+
+// newTodoBranch returns the branch to record on a new todo: the current
+// branch when requested, or when branch_todos is set in the config and
+// the local database lives in a git repository. Only an explicit request
+// fails outside a repository.
+func newTodoBranch(requested bool, config *Config) (string, error) {
+	if !requested && !config.BranchTodos {
+		return "", nil
+	}
+
+	dbPath, err := getDatabasePath()
+	if err != nil {
+		return "", err
+	}
+	if !isLocalDatabasePath(dbPath) {
+		if requested {
+			return "", fmt.Errorf("branch todos need a local todo database, run 'kaj init' first")
+		}
+		return "", nil
+	}
+
+	branch, err := currentBranch()
+	if err != nil && !requested {
+		return "", nil
+	}
+	return branch, err
+}
+
+// staleBranchTodos returns the todos whose branch no longer exists.
+func staleBranchTodos(todos []Todo) ([]Todo, error) {
+	branches, err := localBranches()
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []Todo
+	for _, todo := range todos {
+		if todo.Branch != "" && !branches[todo.Branch] {
+			stale = append(stale, todo)
+		}
+	}
+	return stale, nil
+}
+
+// archiveStaleBranchTodos archives the todos whose branch no longer
+// exists, and returns them.
+func archiveStaleBranchTodos(ctx context.Context, db *Database) ([]Todo, error) {
+	todos, err := db.GetTodos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stale, err := staleBranchTodos(todos)
+	if err != nil || len(stale) == 0 {
+		return nil, err
+	}
+
+	var ids []int
+	for _, todo := range stale {
+		ids = append(ids, todo.ID)
+	}
+	if err := db.ArchiveTodos(ctx, ids); err != nil {
+		return nil, err
+	}
+	return stale, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+func TestArchiveStaleBranchTodos(t *testing.T) {
+	ctx := t.Context()
+	db := testDatabase(t)
+	run := gitRepo(t)
+	run("branch", "feature")
+	run("branch", "merged")
+	run("branch", "-d", "merged")
+	// A branch without commits still counts.
+	run("checkout", "-q", "--orphan", "fresh")
+
+	for _, add := range []struct{ text, branch string }{
+		{"anywhere", ""},
+		{"on main", "main"},
+		{"on feature", "feature"},
+		{"on merged", "merged"},
+		{"on fresh", "fresh"},
+		{"on a typo", "featrue"},
+	} {
+		todo := kaj.NewTodo(add.text)
+		todo.Branch = add.branch
+		if _, err := db.AddTodo(ctx, todo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stale, err := archiveStaleBranchTodos(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archived []string
+	for _, todo := range stale {
+		archived = append(archived, todo.Text)
+	}
+	if want := []string{"on merged", "on a typo"}; !slices.Equal(archived, want) {
+		t.Errorf("archived %v, want %v", archived, want)
+	}
+	if got := dbTexts(t, db); !slices.Equal(got, []string{"anywhere", "on main", "on feature", "on fresh"}) {
+		t.Errorf("todos left: %v", got)
+	}
+
+	if stale, err := archiveStaleBranchTodos(ctx, db); err != nil || len(stale) != 0 {
+		t.Errorf("second run: %v, %v", stale, err)
+	}
+}
+
+func TestNewTodoBranch(t *testing.T) {
+	run := gitRepo(t)
+	run("checkout", "-q", "-b", "feature")
+
+	// Without a local database, only a request for the branch fails.
+	if branch, err := newTodoBranch(false, &Config{BranchTodos: true}); branch != "" || err != nil {
+		t.Errorf("branch_todos without a local database: %q, %v", branch, err)
+	}
+	if _, err := newTodoBranch(true, &Config{}); err == nil {
+		t.Error("--branch without a local database: no error")
+	}
+
+	if err := os.Mkdir(".todos", 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		requested, config bool
+		want              string
+	}{
+		{false, false, ""},
+		{true, false, "feature"},
+		{false, true, "feature"},
+	}
+	for _, test := range tests {
+		branch, err := newTodoBranch(test.requested, &Config{BranchTodos: test.config})
+		if err != nil || branch != test.want {
+			t.Errorf("requested %v, branch_todos %v: %q, %v, want %q", test.requested, test.config, branch, err, test.want)
+		}
+	}
+}
@@ -1,19 +1,40 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/mattn/go-isatty"
+	"github.com/mdmmn378/kaj/pkg/kaj"
 	"github.com/spf13/cobra"
 )
 
+var confirmDelete bool
+
+var (
+	addBranch  bool
+	listBranch bool
+)
+
 var rootCmd = &cobra.Command{
 	Use:     "kaj",
 	Short:   "A simple todo list manager",
 	Long:    "A simple CLI todo list manager with TUI interface built with Bubble Tea",
 	Version: Version,
+	// Errors from cobra are bad arguments or flags; main reports them
+	// through fatal.
+	SilenceErrors: true,
+	SilenceUsage:  true,
 	Run: func(cmd *cobra.Command, args []string) {
 		runTUI()
 	},
@@ -26,8 +47,7 @@ var addCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		db, err := NewDatabase()
 		if err != nil {
-			fmt.Printf("Error opening database: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error opening database")
 		}
 		defer db.Close()
 
@@ -39,31 +59,56 @@ var addCmd = &cobra.Command{
 			todoText += arg
 		}
 
-		err = db.AddTodo(todoText)
+		config, err := LoadConfig()
 		if err != nil {
-			fmt.Printf("Error adding todo: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error loading config")
+		}
+
+		branch, err := newTodoBranch(addBranch, config)
+		if err != nil {
+			fatal(err, "Error getting branch")
 		}
 
-		fmt.Printf("Added: %s\n", todoText)
+		todo := kaj.NewTodo(todoText)
+		todo.Branch = branch
+		_, err = db.AddTodo(cmd.Context(), todo)
+		if err != nil {
+			fatal(err, "Error adding todo")
+		}
+
+		if branch != "" {
+			fmt.Printf("Added to %s: %s\n", branch, todoText)
+		} else {
+			fmt.Printf("Added: %s\n", todoText)
+		}
 	},
 }
 
+var listAt string
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all todo items",
+	Long:  "Lists the todos with the indexes the other commands take.\n--at shows the list as it was at a time instead: a date like 2026-10-01 for the end of that day, \"2026-10-01 15:04\", yesterday or a time ago like 3d.",
 	Run: func(cmd *cobra.Command, args []string) {
 		db, err := NewDatabase()
 		if err != nil {
-			fmt.Printf("Error opening database: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error opening database")
 		}
 		defer db.Close()
 
-		todos, err := db.GetTodos()
+		var todos []Todo
+		if listAt != "" {
+			at, err := parseAt(listAt, time.Now())
+			if err != nil {
+				fatal(err, "Invalid --at")
+			}
+			todos, err = db.TodosAt(cmd.Context(), at)
+		} else {
+			todos, err = db.GetTodos(cmd.Context())
+		}
 		if err != nil {
-			fmt.Printf("Error getting todos: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error getting todos")
 		}
 
 		if len(todos) == 0 {
@@ -71,12 +116,202 @@ var listCmd = &cobra.Command{
 			return
 		}
 
+		branch := ""
+		if listBranch {
+			branch, err = currentBranch()
+			if err != nil {
+				fatal(err, "Error getting branch")
+			}
+		}
+
+		color := useColor()
+		if color {
+			config, err := LoadConfig()
+			if err == nil {
+				err = loadTheme(config)
+			}
+			if err != nil {
+				fatal(err, "Error loading theme")
+			}
+		}
+
+		// Indexes stay those of the full list, so they can be passed
+		// to the other commands.
+		found := false
 		for i, todo := range todos {
-			status := " "
-			if todo.Done {
-				status = "x"
+			if listBranch && todo.Branch != branch {
+				continue
+			}
+			found = true
+			fmt.Println(formatTodoLine(i+1, todo, color))
+		}
+		if !found {
+			fmt.Printf("No todos found for branch %s\n", branch)
+		}
+	},
+}
+
+// useColor reports whether CLI output should be colored: only when
+// stdout is a terminal and NO_COLOR is unset.
+func useColor() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+}
+
+func formatTodoLine(index int, todo Todo, color bool) string {
+	status := " "
+	if todo.Done {
+		status = "x"
+	} else if todo.Status == StatusDoing {
+		status = "~"
+	}
+
+	ref := ""
+	if todo.Branch != "" {
+		ref += " " + branchBadge + todo.Branch
+	}
+	if todo.SourceRef != "" {
+		ref += " (" + todo.SourceRef + ")"
+	}
+
+	if !color {
+		return fmt.Sprintf("%d. [%s] %s%s", index, status, todo.Text, ref)
+	}
+
+	prefix := helpStyle.Render(fmt.Sprintf("%d.", index))
+	if ref != "" {
+		ref = helpStyle.Render(ref)
+	}
+	if todo.Done {
+		return fmt.Sprintf("%s %s %s%s", prefix, statusStyle.Render("[x]"), doneStyle.Render(todo.Text), ref)
+	}
+	if todo.Status == StatusDoing {
+		return fmt.Sprintf("%s %s %s%s", prefix, selectedStyle.Render("[~]"), todo.Text, ref)
+	}
+	return fmt.Sprintf("%s [ ] %s%s", prefix, todo.Text, ref)
+}
+
+var showCmd = &cobra.Command{
+	Use:   "show [index]",
+	Short: "Show the details of a todo item",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		index, err := strconv.Atoi(args[0])
+		if err != nil {
+			fatal(usageError(args[0]), "Invalid index")
+		}
+
+		todos, err := db.GetTodos(cmd.Context())
+		if err != nil {
+			fatal(err, "Error getting todos")
+		}
+
+		todo, err := kaj.TodoAt(todos, index)
+		if err != nil {
+			fatal(err, "Invalid index")
+		}
+		commits, err := db.GetCommits(cmd.Context(), todo.ID)
+		if err != nil {
+			fatal(err, "Error getting commits")
+		}
+
+		fmt.Println(formatTodoLine(index, todo, useColor()))
+		fmt.Printf("  Reference: kaj#%d\n", todo.ID)
+		fmt.Printf("  Status:    %s\n", todo.Status)
+		if todo.Priority != "" {
+			fmt.Printf("  Priority:  %s\n", todo.Priority)
+		}
+		if todo.CreatedAt != nil {
+			fmt.Printf("  Created:   %s\n", todo.CreatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		if todo.CompletedAt != nil && todo.Done {
+			fmt.Printf("  Completed: %s\n", todo.CompletedAt.Local().Format("2006-01-02 15:04"))
+		}
+		if todo.Due != nil {
+			fmt.Printf("  Due:       %s\n", todo.Due.Local().Format("2006-01-02"))
+		}
+		if todo.Notes != "" {
+			fmt.Println("  Notes:")
+			for _, line := range strings.Split(todo.Notes, "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+		if len(commits) > 0 {
+			fmt.Println("  Commits:")
+			for _, commit := range commits {
+				fmt.Printf("    %.7s %s\n", commit.Hash, commit.Subject)
 			}
-			fmt.Printf("%d. [%s] %s\n", i+1, status, todo.Text)
+		}
+	},
+}
+
+var logSince string
+
+var logCmd = &cobra.Command{
+	Use:   "log [index]",
+	Short: "Show the history of a todo, or of the whole list",
+	Long:  "Shows when todos were created, edited, marked done, moved, deleted and restored, with the values before and after each change.\n--since takes today, yesterday, a date like 2026-10-01 or a time ago like 3d, 2w or 12h.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		since, err := parseSince(logSince, time.Now())
+		if err != nil {
+			fatal(err, "Invalid --since")
+		}
+
+		todoID := 0
+		if len(args) > 0 {
+			index, err := strconv.Atoi(args[0])
+			if err != nil {
+				fatal(usageError(args[0]), "Invalid index")
+			}
+
+			todos, err := db.GetTodos(cmd.Context())
+			if err != nil {
+				fatal(err, "Error getting todos")
+			}
+
+			todo, err := kaj.TodoAt(todos, index)
+			if err != nil {
+				fatal(err, "Invalid index")
+			}
+			todoID = todo.ID
+			fmt.Println(formatTodoLine(index, todo, useColor()))
+		}
+
+		entries, err := db.GetHistory(cmd.Context(), todoID, since)
+		if err != nil {
+			fatal(err, "Error getting history")
+		}
+		if len(entries) == 0 {
+			fmt.Println("No changes recorded")
+			return
+		}
+
+		for _, entry := range entries {
+			at := entry.At.Local().Format("2006-01-02 15:04")
+			if todoID != 0 {
+				fmt.Printf("  %s  %s\n", at, entry.Description)
+				continue
+			}
+			line := fmt.Sprintf("%s  kaj#%-4d %s", at, entry.TodoID, entry.Description)
+			if entry.Kind == changeStatus || entry.Kind == changeMove {
+				line += fmt.Sprintf("  %q", entry.Text)
+			}
+			fmt.Println(line)
 		}
 	},
 }
@@ -88,30 +323,25 @@ var editCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		db, err := NewDatabase()
 		if err != nil {
-			fmt.Printf("Error opening database: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error opening database")
 		}
 		defer db.Close()
 
 		index, err := strconv.Atoi(args[0])
 		if err != nil {
-			fmt.Printf("Invalid index: %s\n", args[0])
-			os.Exit(1)
+			fatal(usageError(args[0]), "Invalid index")
 		}
 
-		todos, err := db.GetTodos()
+		todos, err := db.GetTodos(cmd.Context())
 		if err != nil {
-			fmt.Printf("Error getting todos: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error getting todos")
 		}
 
-		if index < 1 || index > len(todos) {
-			fmt.Printf("Index out of range: %d\n", index)
-			os.Exit(1)
+		todo, err := kaj.TodoAt(todos, index)
+		if err != nil {
+			fatal(err, "Invalid index")
 		}
 
-		todo := todos[index-1]
-
 		newText := ""
 		for i, arg := range args[1:] {
 			if i > 0 {
@@ -120,10 +350,9 @@ var editCmd = &cobra.Command{
 			newText += arg
 		}
 
-		err = db.UpdateTodo(todo.ID, newText)
+		err = db.UpdateTodo(cmd.Context(), todo.ID, newText)
 		if err != nil {
-			fmt.Printf("Error updating todo: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error updating todo")
 		}
 
 		fmt.Printf("Updated: %s\n", newText)
@@ -137,33 +366,27 @@ var toggleCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		db, err := NewDatabase()
 		if err != nil {
-			fmt.Printf("Error opening database: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error opening database")
 		}
 		defer db.Close()
 
 		index, err := strconv.Atoi(args[0])
 		if err != nil {
-			fmt.Printf("Invalid index: %s\n", args[0])
-			os.Exit(1)
+			fatal(usageError(args[0]), "Invalid index")
 		}
 
-		todos, err := db.GetTodos()
+		todos, err := db.GetTodos(cmd.Context())
 		if err != nil {
-			fmt.Printf("Error getting todos: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error getting todos")
 		}
 
-		if index < 1 || index > len(todos) {
-			fmt.Printf("Index out of range: %d\n", index)
-			os.Exit(1)
+		todo, err := kaj.TodoAt(todos, index)
+		if err != nil {
+			fatal(err, "Invalid index")
 		}
-
-		todo := todos[index-1]
-		err = db.ToggleTodo(todo.ID)
+		err = db.ToggleTodo(cmd.Context(), todo.ID)
 		if err != nil {
-			fmt.Printf("Error toggling todo: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error toggling todo")
 		}
 
 		status := "done"
@@ -174,6 +397,40 @@ var toggleCmd = &cobra.Command{
 	},
 }
 
+var startCmd = &cobra.Command{
+	Use:   "start [index]",
+	Short: "Move a todo item to Doing",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		index, err := strconv.Atoi(args[0])
+		if err != nil {
+			fatal(usageError(args[0]), "Invalid index")
+		}
+
+		todos, err := db.GetTodos(cmd.Context())
+		if err != nil {
+			fatal(err, "Error getting todos")
+		}
+
+		todo, err := kaj.TodoAt(todos, index)
+		if err != nil {
+			fatal(err, "Invalid index")
+		}
+		err = db.SetStatus(cmd.Context(), todo.ID, StatusDoing)
+		if err != nil {
+			fatal(err, "Error starting todo")
+		}
+
+		fmt.Printf("Started: %s\n", todo.Text)
+	},
+}
+
 var deleteCmd = &cobra.Command{
 	Use:   "delete [index]",
 	Short: "Delete a todo item",
@@ -181,53 +438,127 @@ var deleteCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		db, err := NewDatabase()
 		if err != nil {
-			fmt.Printf("Error opening database: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error opening database")
 		}
 		defer db.Close()
 
 		index, err := strconv.Atoi(args[0])
 		if err != nil {
-			fmt.Printf("Invalid index: %s\n", args[0])
-			os.Exit(1)
+			fatal(usageError(args[0]), "Invalid index")
 		}
 
-		todos, err := db.GetTodos()
+		todos, err := db.GetTodos(cmd.Context())
 		if err != nil {
-			fmt.Printf("Error getting todos: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error getting todos")
 		}
 
-		if index < 1 || index > len(todos) {
-			fmt.Printf("Index out of range: %d\n", index)
-			os.Exit(1)
+		todo, err := kaj.TodoAt(todos, index)
+		if err != nil {
+			fatal(err, "Invalid index")
 		}
-
-		todo := todos[index-1]
-		err = db.DeleteTodo(todo.ID)
+		err = db.DeleteTodo(cmd.Context(), todo.ID)
 		if err != nil {
-			fmt.Printf("Error deleting todo: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error deleting todo")
 		}
 
 		fmt.Printf("Deleted: %s\n", todo.Text)
 	},
 }
 
+var moveCmd = &cobra.Command{
+	Use:   "move [from] [to]",
+	Short: "Move a todo item to another position in the list",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		to, err := strconv.Atoi(args[1])
+		if err != nil {
+			fatal(usageError(args[1]), "Invalid index")
+		}
+		moveTodo(cmd, args[0], to)
+	},
+}
+
+var topCmd = &cobra.Command{
+	Use:   "top [index]",
+	Short: "Move a todo item to the top of the list",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		moveTodo(cmd, args[0], 1)
+	},
+}
+
+var bottomCmd = &cobra.Command{
+	Use:   "bottom [index]",
+	Short: "Move a todo item to the bottom of the list",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		moveTodo(cmd, args[0], 0)
+	},
+}
+
+// moveTodo moves the todo at the index given in fromArg to the index to,
+// or to the end of the list when to is 0.
+func moveTodo(cmd *cobra.Command, fromArg string, to int) {
+	db, err := NewDatabase()
+	if err != nil {
+		fatal(err, "Error opening database")
+	}
+	defer db.Close()
+
+	index, err := strconv.Atoi(fromArg)
+	if err != nil {
+		fatal(usageError(fromArg), "Invalid index")
+	}
+
+	todos, err := db.GetTodos(cmd.Context())
+	if err != nil {
+		fatal(err, "Error getting todos")
+	}
+
+	todo, err := kaj.TodoAt(todos, index)
+	if err != nil {
+		fatal(err, "Invalid index")
+	}
+	if to == 0 {
+		to = len(todos)
+	}
+	if _, err := kaj.TodoAt(todos, to); err != nil {
+		fatal(err, "Invalid index")
+	}
+
+	err = db.MoveTodoTo(cmd.Context(), todo.ID, to-1)
+	if err != nil {
+		fatal(err, "Error moving todo")
+	}
+
+	fmt.Printf("Moved '%s' to position %d\n", todo.Text, to)
+}
+
+var (
+	initFormat  string
+	initShared  bool
+	initEncrypt bool
+)
+
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize a local todo database in current directory",
-	Long:  "Creates a .todos directory in the current directory for project-specific todos",
+	Long:  "Creates a .todos directory in the current directory for project-specific todos.\nWith --format text the todos are kept in .todos/todos.txt, a plain text file to commit with the project.\nWith --shared they are kept in per-author operation logs in .todos/ops, which the team commits and merges; run kaj sync after pulling.\nWith --encrypt the text and notes of the todos are encrypted with a passphrase.",
 	Run: func(cmd *cobra.Command, args []string) {
-		err := InitLocalDatabase()
+		err := InitLocalDatabase(initFormat, initShared, initEncrypt)
 		if err != nil {
-			fmt.Printf("Error initializing local database: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error initializing local database")
 		}
 
 		cwd, _ := os.Getwd()
 		fmt.Printf("Initialized local todo database in %s/.todos\n", cwd)
 		fmt.Println("Local todos will now take precedence over global todos in this directory.")
+		switch {
+		case initShared:
+			fmt.Println("Commit .todos/ops and .todos/.gitattributes to share the list with the team.")
+		case initFormat == formatText:
+			fmt.Println("Commit .todos/todos.txt to share the list with the project.")
+		}
 	},
 }
 
@@ -237,18 +568,25 @@ var statusCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		dbPath, err := getDatabasePath()
 		if err != nil {
-			fmt.Printf("Error getting database path: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error getting database path")
 		}
 
-		cwd, _ := os.Getwd()
-		if filepath.Dir(dbPath) == filepath.Join(cwd, ".todos") {
+		if isLocalDatabasePath(dbPath) {
 			fmt.Printf("Using LOCAL todo database: %s\n", dbPath)
 		} else {
 			fmt.Printf("Using GLOBAL todo database: %s\n", dbPath)
 		}
+		fmt.Printf("Format: %s\n", databaseFormat(dbPath))
 
 		if _, err := os.Stat(dbPath); err == nil {
+			if databaseFormat(dbPath) == formatSQLite {
+				encryption := "off"
+				if databaseEncrypted(dbPath) {
+					encryption = "on"
+				}
+				fmt.Printf("Encryption: %s\n", encryption)
+			}
+
 			db, err := NewDatabase()
 			if err != nil {
 				fmt.Printf("Error opening database: %v\n", err)
@@ -256,7 +594,7 @@ var statusCmd = &cobra.Command{
 			}
 			defer db.Close()
 
-			todos, err := db.GetTodos()
+			todos, err := db.GetTodos(cmd.Context())
 			if err != nil {
 				fmt.Printf("Error getting todos: %v\n", err)
 				return
@@ -269,6 +607,527 @@ var statusCmd = &cobra.Command{
 	},
 }
 
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the todo database",
+}
+
+var dbEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt the todo database with a passphrase",
+	Long:  "Encrypts the text, notes and tags of the todos in the current database with a key derived from a passphrase.\nThe passphrase is read from the key file (~/.todos/key or $KAJ_KEY_FILE) when there is one, and asked for otherwise.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dbPath, err := getDatabasePath()
+		if err != nil {
+			fatal(err, "Error getting database path")
+		}
+
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		if err := db.Encrypt(cmd.Context()); err != nil {
+			fatal(err, "Error encrypting database")
+		}
+
+		fmt.Printf("Encrypted %s\n", dbPath)
+		if backups, _ := filepath.Glob(filepath.Join(getBackupDir(dbPath), "*.db")); len(backups) > 0 {
+			fmt.Printf("The %d backups in %s were taken before and are not encrypted, remove them unless you need them\n", len(backups), getBackupDir(dbPath))
+		}
+	},
+}
+
+// openSQLiteDatabase opens the current database for the kaj db commands
+// that only work on the sqlite format.
+func openSQLiteDatabase() (*Database, *kaj.SQLiteStore) {
+	db, err := NewDatabase()
+	if err != nil {
+		fatal(err, "Error opening database")
+	}
+	store, err := db.sqliteStore()
+	if err != nil {
+		db.Close()
+		fatal(err, "Error opening database")
+	}
+	return db, store
+}
+
+var dbBackupCmd = &cobra.Command{
+	Use:   "backup [file]",
+	Short: "Back up the todo database while it is in use",
+	Long:  "Writes a consistent copy of the current database to file, by default to backups/todos-<time>.db next to the database.\nThe copy of an encrypted database stays encrypted under the same passphrase.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db, store := openSQLiteDatabase()
+		defer db.Close()
+
+		path := backupPath(store.Path(), "todos-", time.Now())
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if err := store.Backup(cmd.Context(), path); err != nil {
+			fatal(err, "Error backing up database")
+		}
+
+		fmt.Printf("Backed up to %s\n", path)
+	},
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Replace the todo database with a backup",
+	Long:  "Replaces the todos of the current database with those of a backup, after checking the backup is intact.\nThe database is backed up to backups/before-restore-<time>.db first, so a restore can be undone by restoring that.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db, store := openSQLiteDatabase()
+		defer db.Close()
+
+		saved, err := restoreBackup(cmd.Context(), store, args[0], time.Now())
+		if err != nil {
+			fatal(err, "Error restoring database")
+		}
+
+		fmt.Printf("Restored %s (the previous database is in %s)\n", args[0], saved)
+	},
+}
+
+var dbVacuumCmd = &cobra.Command{
+	Use:   "vacuum",
+	Short: "Compact the todo database file",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, store := openSQLiteDatabase()
+		defer db.Close()
+
+		before, err := store.Stats(cmd.Context())
+		if err != nil {
+			fatal(err, "Error reading database")
+		}
+		if err := store.Vacuum(cmd.Context()); err != nil {
+			fatal(err, "Error vacuuming database")
+		}
+		after, err := store.Stats(cmd.Context())
+		if err != nil {
+			fatal(err, "Error reading database")
+		}
+
+		fmt.Printf("Vacuumed %s: %s -> %s\n", store.Path(), formatSize(before.Size), formatSize(after.Size))
+	},
+}
+
+var dbIntegrityCheckCmd = &cobra.Command{
+	Use:   "integrity-check",
+	Short: "Check the todo database file for damage",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, store := openSQLiteDatabase()
+		defer db.Close()
+
+		problems, err := store.IntegrityCheck(cmd.Context())
+		if err != nil {
+			fatal(err, "Error checking database")
+		}
+		if len(problems) > 0 {
+			for _, problem := range problems {
+				fmt.Println(problem)
+			}
+			fatal(fmt.Errorf("%s is damaged, restore a backup from %s with 'kaj db restore'", store.Path(), getBackupDir(store.Path())), "Error checking database")
+		}
+
+		fmt.Println("ok")
+	},
+}
+
+var dbRebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Regenerate the todos, trash and archive from the change log",
+	Long:  "Every change is kept in the todo_log table, from which the todos, trash and archive tables are derived. rebuild derives them again, undoing changes written to those tables by anything but kaj.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, store := openSQLiteDatabase()
+		defer db.Close()
+
+		report, err := store.Rebuild(cmd.Context())
+		if err != nil {
+			fatal(err, "Error rebuilding database")
+		}
+
+		fmt.Printf("Rebuilt from %d changes: %d todos, %d in the trash, %d archived\n", report.Events, report.Todos, report.Trash, report.Archived)
+	},
+}
+
+var dbStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the size and contents of the todo database",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, store := openSQLiteDatabase()
+		defer db.Close()
+
+		stats, err := store.Stats(cmd.Context())
+		if err != nil {
+			fatal(err, "Error reading database")
+		}
+
+		fmt.Printf("Path: %s\n", stats.Path)
+		fmt.Printf("Size: %s\n", formatSize(stats.Size))
+		encryption := "off"
+		if stats.Encrypted {
+			encryption = "on"
+		}
+		fmt.Printf("Encryption: %s\n", encryption)
+
+		trash := fmt.Sprintf("%d todos", stats.Trash)
+		if stats.OldestTrash != nil {
+			trash += fmt.Sprintf(", oldest deleted %s", stats.OldestTrash.Local().Format("2006-01-02"))
+		}
+		fmt.Printf("Trash: %s\n", trash)
+
+		backups, _ := filepath.Glob(filepath.Join(getBackupDir(stats.Path), "*.db"))
+		fmt.Printf("Backups: %d in %s\n", len(backups), getBackupDir(stats.Path))
+
+		fmt.Println("Rows:")
+		tables := make([]string, 0, len(stats.Rows))
+		for table := range stats.Rows {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			fmt.Printf("  %-20s %d\n", table, stats.Rows[table])
+		}
+	},
+}
+
+var agentTimeout time.Duration
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Keep the keys of encrypted databases for this session",
+	Long:  "Runs in the foreground and keeps the keys of encrypted databases unlocked by other kaj commands, so the passphrase is asked for once.\nIt listens on ~/.todos/agent.sock ($KAJ_AGENT_SOCK), readable only by you. Start it in the background with 'kaj agent &'.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := getAgentSocketPath()
+		if err != nil {
+			fatal(err, "Error getting agent socket path")
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := runAgent(ctx, path, agentTimeout); err != nil {
+			fatal(err, "Error running agent")
+		}
+	},
+}
+
+var agentStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the agent, forgetting its keys",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := callAgent(agentRequest{Op: "stop"}); err != nil {
+			fatal(err, "Error stopping agent")
+		}
+		fmt.Println("Stopped agent")
+	},
+}
+
+func importBackup(ctx context.Context, in io.Reader, name string) {
+	backup, err := readBackup(in)
+	if err != nil {
+		fatal(err, "Error reading %s", name)
+	}
+
+	db, err := NewDatabase()
+	if err != nil {
+		fatal(err, "Error opening database")
+	}
+	defer db.Close()
+
+	result, err := db.RestoreBackup(ctx, backup, importMode, importDryRun)
+	if err != nil {
+		fatal(err, "Error importing backup")
+	}
+
+	imported, replaced := "Imported", "Replaced"
+	if importDryRun {
+		imported, replaced = "Dry run, would import", "Dry run, would replace"
+	}
+	if importMode == kaj.RestoreReplace {
+		fmt.Printf("%s %d existing todos and %d trashed todos\n", replaced, result.Removed, result.TrashRemoved)
+	}
+	fmt.Printf("%s %d todos (%d duplicates skipped) and %d trashed todos (%d duplicates skipped)\n",
+		imported, result.Added, result.Duplicates, result.TrashAdded, result.TrashDuplicate)
+}
+
+// importExternal loads todos from another task app, updating the todos
+// created by earlier imports of the same items.
+func importExternal(ctx context.Context, source string, items []ExternalTodo) {
+	if importDryRun {
+		fmt.Printf("Would import %d todos\n", len(items))
+		return
+	}
+
+	db, err := NewDatabase()
+	if err != nil {
+		fatal(err, "Error opening database")
+	}
+	defer db.Close()
+
+	added, updated, err := db.ImportExternal(ctx, source, items)
+	if err != nil {
+		fatal(err, "Error importing todos")
+	}
+
+	fmt.Printf("Imported %d new todos, updated %d\n", added, updated)
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Replay the operation logs of a shared list",
+	Long:  "Records local changes in your operation log and rebuilds the list from the logs of all authors in .todos/ops.\nRun it after git pull; every clone with the same logs ends up with the same list.",
+	Run: func(cmd *cobra.Command, args []string) {
+		dbPath, err := getDatabasePath()
+		if err != nil {
+			fatal(err, "Error getting database path")
+		}
+		if databaseFormat(dbPath) != formatShared {
+			fatal(usageError("No shared todo list in this directory. Run 'kaj init --shared' first."), "")
+		}
+
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		shared := db.todoStore.(*kaj.SharedStore)
+		report, err := shared.Replay(cmd.Context())
+		if err != nil {
+			fatal(err, "Error syncing")
+		}
+
+		todos, err := db.GetTodos(cmd.Context())
+		if err != nil {
+			fatal(err, "Error getting todos")
+		}
+
+		if report.Recorded > 0 {
+			fmt.Printf("Recorded %d local changes as %s\n", report.Recorded, shared.Author())
+		}
+		fmt.Printf("Replayed %d operations from %d authors: %d todos\n", report.Operations, report.Authors, len(todos))
+	},
+}
+
+var syncMarkdownCmd = &cobra.Command{
+	Use:   "sync-md [file]",
+	Short: "Two-way sync a Markdown checklist with the local todos",
+	Long:  "Syncs the - [ ] / - [x] items of a Markdown file (TODO.md by default) with the local .todos database.\nChanges made on either side since the last sync are applied to the other side.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := "TODO.md"
+		if len(args) > 0 {
+			path = args[0]
+		}
+
+		dbPath, err := getDatabasePath()
+		if err != nil {
+			fatal(err, "Error getting database path")
+		}
+		if !isLocalDatabasePath(dbPath) {
+			fatal(usageError("No local todo database in this directory. Run 'kaj init' first."), "")
+		}
+
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		report, err := syncMarkdown(cmd.Context(), db, path)
+		if err != nil {
+			fatal(err, "Error syncing %s", path)
+		}
+
+		fmt.Printf("Synced %s\n", path)
+		fmt.Printf("  database: %d added, %d updated, %d deleted\n", report.AddedToDatabase, report.UpdatedDatabase, report.DeletedInDatabase)
+		fmt.Printf("  file:     %d added, %d updated, %d deleted\n", report.AddedToFile, report.UpdatedFile, report.DeletedInFile)
+		for _, text := range report.Conflicts {
+			fmt.Printf("Conflict: '%s' changed on both sides, kept the database version\n", text)
+		}
+	},
+}
+
+var scanCmd = &cobra.Command{
+	Use:   "scan [paths]",
+	Short: "Import TODO, FIXME and HACK comments from source code",
+	Long:  "Scans the given paths (the current directory by default) for TODO, FIXME and HACK comments, skipping files ignored by .gitignore.\nEach comment becomes a todo with a file:line reference. Rescanning updates moved comments and completes todos whose comment was removed.",
+	Run: func(cmd *cobra.Command, args []string) {
+		paths := args
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		report, err := scanTodos(cmd.Context(), db, paths)
+		if err != nil {
+			fatal(err, "Error scanning")
+		}
+
+		fmt.Printf("Scanned %d files, found %d comments: %d new, %d moved, %d resolved\n",
+			report.Files, report.Found, report.Added, report.Moved, report.Resolved)
+	},
+}
+
+var gitCmd = &cobra.Command{
+	Use:   "git",
+	Short: "Link todos to git commits",
+}
+
+var installHooksCmd = &cobra.Command{
+	Use:   "install-hooks",
+	Short: "Install a post-commit hook that completes todos referenced as kaj#ID",
+	Long:  "Installs a post-commit hook in the current git repository. Commits whose message mentions kaj#ID, e.g. \"closes kaj#42\", mark that todo done in the local .todos database and are listed by 'kaj show'.\nUse 'kaj show' to find the ID of a todo.",
+	Run: func(cmd *cobra.Command, args []string) {
+		hookPath, err := installHooks()
+		if err != nil {
+			fatal(err, "Error installing hooks")
+		}
+
+		fmt.Printf("Installed post-commit hook: %s\n", hookPath)
+	},
+}
+
+var postCommitCmd = &cobra.Command{
+	Use:    "post-commit",
+	Short:  "Record the last commit on the todos it references (run by the hook)",
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Hooks run at the top of the work tree. Without a local
+		// database there is nothing to link, and the global list must
+		// not be touched by commits.
+		dbPath, err := getDatabasePath()
+		if err != nil || !isLocalDatabasePath(dbPath) {
+			return
+		}
+
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "kaj: error opening database")
+		}
+		defer db.Close()
+
+		results, err := recordCommit(cmd.Context(), db)
+		if err != nil {
+			fatal(err, "kaj: error recording commit")
+		}
+
+		for _, result := range results {
+			if result.Missing {
+				fmt.Printf("kaj: no todo kaj#%d\n", result.ID)
+				continue
+			}
+			fmt.Printf("kaj: completed kaj#%d: %s\n", result.ID, result.Text)
+		}
+	},
+}
+
+var branchCleanCmd = &cobra.Command{
+	Use:   "branch-clean",
+	Short: "Archive todos of git branches that no longer exist",
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		stale, err := archiveStaleBranchTodos(cmd.Context(), db)
+		if err != nil {
+			fatal(err, "Error archiving todos of deleted branches")
+		}
+
+		if len(stale) == 0 {
+			fmt.Println("No todos of deleted branches")
+			return
+		}
+		for _, todo := range stale {
+			fmt.Printf("Archived (%s): %s\n", todo.Branch, todo.Text)
+		}
+	},
+}
+
+var (
+	serveAddr  string
+	serveToken string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the todos as a JSON REST API",
+	Long:  "Serves the todo database over HTTP as a JSON API for dashboards and editor plugins.\nSet --token or KAJ_TOKEN to require an \"Authorization: Bearer <token>\" header;\nwithout one, only requests to localhost, a loopback address or the --addr host are served.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		token := serveToken
+		if token == "" {
+			token = os.Getenv("KAJ_TOKEN")
+		}
+
+		fmt.Printf("Serving todos on http://%s\n", serveAddr)
+		server := &http.Server{
+			Addr:              serveAddr,
+			Handler:           newAPIHandler(db, serveAddr, token),
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+		}
+		if err := server.ListenAndServe(); err != nil {
+			fatal(err, "Error serving")
+		}
+	},
+}
+
+var rpcStdio bool
+
+var rpcCmd = &cobra.Command{
+	Use:   "rpc",
+	Short: "Serve JSON-RPC 2.0 (and MCP tools) for editors and agents",
+	Long:  "Speaks newline-delimited JSON-RPC 2.0 on stdin/stdout with the methods GetTodos, AddTodo, UpdateTodo, ToggleTodo, DeleteTodo, MoveTodoUp, MoveTodoDown, Undo and Redo.\nIt also works as a Model Context Protocol tool server, and sends a todos/changed notification whenever the database changes.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !rpcStdio {
+			fatal(usageError("Only --stdio is supported"), "")
+		}
+
+		db, err := NewDatabase()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		if err := serveRPC(cmd.Context(), db, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Show version information",
@@ -279,38 +1138,235 @@ var versionCmd = &cobra.Command{
 
 var undoCmd = &cobra.Command{
 	Use:   "undo",
-	Short: "Undo the last deleted todo",
+	Short: "Undo the last change",
+	Long:  "Undoes the last change to the todos that was not undone yet: an add, edit, status change, move, delete, restore, archive or import. Run it again to undo the change before.",
+	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		db, err := NewDatabase()
 		if err != nil {
-			fmt.Printf("Error opening database: %v\n", err)
-			os.Exit(1)
+			fatal(err, "Error opening database")
 		}
 		defer db.Close()
 
-		todo, err := db.UndoLastDelete()
+		events, err := db.Undo(cmd.Context())
 		if err != nil {
-			if err.Error() == "sql: no rows in result set" {
-				fmt.Println("No recently deleted todos to restore")
+			fatal(err, "Error undoing")
+		}
+
+		fmt.Printf("Undid %s\n", describeBatch(events))
+	},
+}
+
+var redoCmd = &cobra.Command{
+	Use:   "redo",
+	Short: "Redo the last undone change",
+	Long:  "Makes the last change undone with kaj undo again. Changing the todos after an undo drops the changes left to redo.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		events, err := db.Redo(cmd.Context())
+		if err != nil {
+			fatal(err, "Error redoing")
+		}
+
+		fmt.Printf("Redid %s\n", describeBatch(events))
+	},
+}
+
+var exportFormat string
+var exportOutput string
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export todos to another format",
+	Long:  "Writes all todos to stdout, or to the file given with --output.\nSupported formats: todotxt, markdown, json (full backup including trash), ics, taskwarrior",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		todos, err := db.GetTodos(cmd.Context())
+		if err != nil {
+			fatal(err, "Error getting todos")
+		}
+
+		out := os.Stdout
+		if exportOutput != "" && exportOutput != "-" {
+			out, err = os.Create(exportOutput)
+			if err != nil {
+				fatal(err, "Error creating %s", exportOutput)
+			}
+			defer out.Close()
+		}
+
+		switch exportFormat {
+		case "todotxt":
+			err = writeTodoTxt(out, todos)
+		case "markdown":
+			err = writeMarkdown(out, todos)
+		case "json":
+			err = writeBackup(cmd.Context(), out, db)
+		case "ics":
+			err = writeICS(cmd.Context(), out, db)
+		case "taskwarrior":
+			err = writeTaskwarrior(cmd.Context(), out, db)
+		default:
+			fatal(usageError(exportFormat), "Unsupported export format")
+		}
+		if err != nil {
+			fatal(err, "Error exporting todos")
+		}
+	},
+}
+
+var importFormat string
+var importMode string
+var importDryRun bool
+
+var importCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Import todos from another format",
+	Long: "Appends the todos read from file (or stdin for \"-\") to the list.\nSupported formats: todotxt, markdown, json, ics, taskwarrior\n\n" +
+		"A json backup can be merged into the list (--mode merge, skipping duplicates)\nor replace it entirely, trash included (--mode replace).",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		in := os.Stdin
+		if args[0] != "-" {
+			file, err := os.Open(args[0])
+			if err != nil {
+				fatal(err, "Error opening %s", args[0])
+			}
+			defer file.Close()
+			in = file
+		}
+
+		if importFormat == "json" {
+			importBackup(cmd.Context(), in, args[0])
+			return
+		}
+		if importFormat == "ics" || importFormat == "taskwarrior" {
+			var items []ExternalTodo
+			var err error
+			source := icsSource
+			if importFormat == "ics" {
+				items, err = readICS(in)
 			} else {
-				fmt.Printf("Error undoing delete: %v\n", err)
+				items, err = readTaskwarrior(in)
+				source = taskwarriorSource
 			}
-			os.Exit(1)
+			if err != nil {
+				fatal(err, "Error reading %s", args[0])
+			}
+			importExternal(cmd.Context(), source, items)
+			return
+		}
+
+		var todos []Todo
+		var err error
+		switch importFormat {
+		case "todotxt":
+			todos, err = readTodoTxt(in)
+		case "markdown":
+			todos, err = readMarkdown(in)
+		default:
+			fatal(usageError(importFormat), "Unsupported import format")
+		}
+		if err != nil {
+			fatal(err, "Error reading %s", args[0])
+		}
+
+		if importDryRun {
+			fmt.Printf("Would import %d todos\n", len(todos))
+			return
+		}
+
+		db, err := NewDatabase()
+		if err != nil {
+			fatal(err, "Error opening database")
+		}
+		defer db.Close()
+
+		_, err = db.ImportTodos(cmd.Context(), todos)
+		if err != nil {
+			fatal(err, "Error importing todos")
 		}
 
-		fmt.Printf("Restored: %s\n", todo.Text)
+		fmt.Printf("Imported %d todos\n", len(todos))
 	},
 }
 
 func init() {
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "todotxt", "Export format")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Write to file instead of stdout")
+	importCmd.Flags().StringVarP(&importFormat, "format", "f", "todotxt", "Import format")
+	importCmd.Flags().StringVar(&importMode, "mode", "merge", "How to load a json backup: merge or replace")
+	importCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "Show what would be imported without changing anything")
+
+	addCmd.Flags().BoolVarP(&addBranch, "branch", "b", false, "Tie the todo to the current git branch")
+	listCmd.Flags().BoolVarP(&listBranch, "branch", "b", false, "Only show todos of the current git branch")
+	listCmd.Flags().StringVar(&listAt, "at", "", "Show the list as it was at a date, a time or a time ago (3d)")
+	logCmd.Flags().StringVar(&logSince, "since", "", "Only show changes since today, yesterday, a date or a time ago (3d)")
+
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:7070", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveToken, "token", "", "Require this bearer token (default $KAJ_TOKEN)")
+
+	initCmd.Flags().StringVar(&initFormat, "format", formatSQLite, "Storage format: sqlite or text")
+	initCmd.Flags().BoolVar(&initShared, "shared", false, "Share the list with everyone on the repository through mergeable operation logs")
+	initCmd.Flags().BoolVar(&initEncrypt, "encrypt", false, "Encrypt the todos with a passphrase")
+
+	agentCmd.Flags().DurationVar(&agentTimeout, "timeout", 8*time.Hour, "Forget keys after this long, 0 to keep them until the agent stops")
+	agentCmd.AddCommand(agentStopCmd)
+
+	dbCmd.AddCommand(dbEncryptCmd)
+	dbCmd.AddCommand(dbBackupCmd)
+	dbCmd.AddCommand(dbRestoreCmd)
+	dbCmd.AddCommand(dbVacuumCmd)
+	dbCmd.AddCommand(dbIntegrityCheckCmd)
+	dbCmd.AddCommand(dbStatsCmd)
+	dbCmd.AddCommand(dbRebuildCmd)
+
+	rpcCmd.Flags().BoolVar(&rpcStdio, "stdio", false, "Use stdin and stdout as the transport")
+
+	gitCmd.AddCommand(installHooksCmd)
+	gitCmd.AddCommand(postCommitCmd)
+
+	rootCmd.PersistentFlags().BoolVar(&jsonErrors, "json-errors", false, "Print errors as JSON on stderr")
+	rootCmd.Flags().BoolVar(&confirmDelete, "confirm-delete", false, "Ask for confirmation before deleting a todo in the TUI")
+
 	rootCmd.AddCommand(addCmd)
 	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(showCmd)
+	rootCmd.AddCommand(logCmd)
 	rootCmd.AddCommand(editCmd)
 	rootCmd.AddCommand(toggleCmd)
+	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(deleteCmd)
+	rootCmd.AddCommand(moveCmd)
+	rootCmd.AddCommand(topCmd)
+	rootCmd.AddCommand(bottomCmd)
 	rootCmd.AddCommand(undoCmd)
+	rootCmd.AddCommand(redoCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(syncMarkdownCmd)
+	rootCmd.AddCommand(scanCmd)
+	rootCmd.AddCommand(gitCmd)
+	rootCmd.AddCommand(branchCleanCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(rpcCmd)
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(dbCmd)
+	rootCmd.AddCommand(agentCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
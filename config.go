@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config holds user preferences loaded from ~/.todos/config.json. Every
+// field is optional; a missing file yields the zero Config.
+type Config struct {
+	ConfirmDelete bool                `json:"confirm_delete"`
+	BranchTodos   bool                `json:"branch_todos"`
+	Keys          map[string][]string `json:"keys"`
+	Theme         string              `json:"theme"`
+	Themes        map[string]Theme    `json:"themes"`
+	Backups       BackupConfig        `json:"backups"`
+}
+
+// BackupConfig controls the daily backup of SQLite databases.
+type BackupConfig struct {
+	Disabled bool `json:"disabled"`
+
+	// Keep is the number of daily backups kept, 7 when unset.
+	Keep int `json:"keep"`
+}
+
+func getConfigPath() (string, error) {
+	if path := os.Getenv("KAJ_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, ".todos", "config.json"), nil
+}
+
+func LoadConfig() (*Config, error) {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", configPath, err)
+	}
+
+	return &config, nil
+}
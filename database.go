@@ -2,23 +2,67 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mdmmn378/kaj/pkg/kaj"
 )
 
-type Todo struct {
-	ID       int    `json:"id"`
-	Text     string `json:"text"`
-	Done     bool   `json:"done"`
-	Position int    `json:"position"`
+// The todo model and its storage live in package kaj; these names keep
+// the rest of the command short.
+type (
+	Todo         = kaj.Todo
+	DeletedTodo  = kaj.DeletedTodo
+	ExternalTodo = kaj.ExternalTodo
+)
+
+const (
+	StatusTodo  = kaj.StatusTodo
+	StatusDoing = kaj.StatusDoing
+	StatusDone  = kaj.StatusDone
+)
+
+var Statuses = kaj.Statuses
+
+// Storage formats of a local todo list, chosen with kaj init --format.
+const (
+	formatSQLite = "sqlite"
+	formatText   = "text"
+
+	// formatShared is a list created with kaj init --shared, kept in
+	// .todos/ops.
+	formatShared = "shared"
+)
+
+// todoStore is what kaj's commands need from a store: kaj.SQLiteStore,
+// kaj.TextStore or kaj.SharedStore.
+type todoStore interface {
+	kaj.Store
+	DB() *sql.DB
+	ImportTodos(ctx context.Context, todos []Todo) ([]int, error)
+	ArchiveTodos(ctx context.Context, ids []int) error
+	GetDeletedTodos(ctx context.Context) ([]DeletedTodo, error)
+	Undo(ctx context.Context) ([]kaj.Event, error)
+	Redo(ctx context.Context) ([]kaj.Event, error)
+	Events(ctx context.Context) ([]kaj.Event, error)
+	TodosAt(ctx context.Context, at time.Time) ([]Todo, error)
+	SetSourceRef(ctx context.Context, id int, ref string) error
+	ExternalIDs(ctx context.Context, source string) (map[int]string, error)
+	LinkExternalID(ctx context.Context, source, externalID string, todoID int) error
+	ImportExternal(ctx context.Context, source string, items []ExternalTodo) (added, updated int, err error)
+	RestoreTodos(ctx context.Context, todos []Todo, trash []DeletedTodo, mode string, dryRun bool) (*kaj.RestoreResult, error)
 }
 
+// Database is the store of the current directory or the global one,
+// plus the tables kaj's commands keep next to the todos.
 type Database struct {
+	todoStore
 	db *sql.DB
 }
 
@@ -28,18 +72,38 @@ func NewDatabase() (*Database, error) {
 		return nil, err
 	}
 
-	os.MkdirAll(filepath.Dir(dbPath), 0755)
-
-	db, err := sql.Open("sqlite3", dbPath)
+	keys := &keyring{}
+	var store todoStore
+	switch databaseFormat(dbPath) {
+	case formatText:
+		store, err = kaj.OpenText(dbPath)
+	case formatShared:
+		store, err = kaj.OpenShared(filepath.Dir(dbPath), sharedAuthor())
+	default:
+		store, err = kaj.OpenSQLiteWithKey(dbPath, keys.key)
+	}
 	if err != nil {
 		return nil, err
 	}
+	keys.remember()
 
-	database := &Database{db: db}
+	database := &Database{todoStore: store, db: store.DB()}
 	if err := database.createTables(); err != nil {
+		store.Close()
 		return nil, err
 	}
 
+	if sqliteStore, ok := store.(*kaj.SQLiteStore); ok {
+		config, err := LoadConfig()
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		if err := autoBackup(context.Background(), sqliteStore, config.Backups, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "kaj: daily backup failed: %v\n", err)
+		}
+	}
+
 	return database, nil
 }
 
@@ -52,6 +116,14 @@ func getDatabasePath() (string, error) {
 	localTodosPath := filepath.Join(cwd, ".todos", "todos.db")
 
 	if _, err := os.Stat(filepath.Dir(localTodosPath)); err == nil {
+		opsPath := filepath.Join(cwd, ".todos", "ops")
+		if _, err := os.Stat(opsPath); err == nil {
+			return opsPath, nil
+		}
+		textPath := filepath.Join(cwd, ".todos", "todos.txt")
+		if _, err := os.Stat(textPath); err == nil {
+			return textPath, nil
+		}
 		return localTodosPath, nil
 	}
 
@@ -63,7 +135,61 @@ func getDatabasePath() (string, error) {
 	return filepath.Join(homeDir, ".todos", "todos.db"), nil
 }
 
-func InitLocalDatabase() error {
+// isLocalDatabasePath reports whether dbPath is the .todos database of
+// the current directory rather than the global one.
+func isLocalDatabasePath(dbPath string) bool {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	return filepath.Dir(dbPath) == filepath.Join(cwd, ".todos")
+}
+
+// databaseFormat tells the storage format from the path returned by
+// getDatabasePath.
+func databaseFormat(dbPath string) string {
+	switch {
+	case filepath.Ext(dbPath) == ".txt":
+		return formatText
+	case filepath.Base(dbPath) == "ops":
+		return formatShared
+	}
+	return formatSQLite
+}
+
+// sharedAuthor names the author of changes to a shared list: the git
+// user.email, or user@host where git has none.
+func sharedAuthor() string {
+	if email, err := git("config", "user.email"); err == nil && email != "" {
+		return email
+	}
+
+	name := os.Getenv("USER")
+	if name == "" {
+		name = "unknown"
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + host
+}
+
+// InitLocalDatabase creates the .todos directory. With formatText the
+// todos are kept in .todos/todos.txt, and with shared in operation logs
+// in .todos/ops; both are meant to be committed, so only their cache is
+// git-ignored. encrypt encrypts a new SQLite database.
+func InitLocalDatabase(format string, shared bool, encrypt bool) error {
+	if format != formatSQLite && format != formatText {
+		return usageError(fmt.Sprintf("unknown format %q, use %s or %s", format, formatSQLite, formatText))
+	}
+	if shared && format != formatSQLite {
+		return usageError("--shared keeps todos in operation logs and cannot be combined with --format " + format)
+	}
+	if encrypt && (shared || format != formatSQLite) {
+		return usageError("only the sqlite format can be encrypted")
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
@@ -80,13 +206,35 @@ func InitLocalDatabase() error {
 		return err
 	}
 
+	ignore := ".todos/"
+	switch {
+	case shared:
+		ignore = ".todos/cache.db*"
+		if err := os.Mkdir(filepath.Join(localTodosDir, "ops"), 0755); err != nil {
+			return err
+		}
+	case format == formatText:
+		ignore = ".todos/cache.db*"
+		if err := os.WriteFile(filepath.Join(localTodosDir, "todos.txt"), nil, 0644); err != nil {
+			return err
+		}
+	}
+
 	db, err := NewDatabase()
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	err = addToGitignore(cwd)
+	if encrypt {
+		if err := db.Encrypt(context.Background()); err != nil {
+			db.Close()
+			os.RemoveAll(localTodosDir)
+			return err
+		}
+	}
+
+	err = addToGitignore(cwd, ignore)
 	if err != nil {
 		return fmt.Errorf("failed to update .gitignore: %v", err)
 	}
@@ -94,7 +242,36 @@ func InitLocalDatabase() error {
 	return nil
 }
 
-func addToGitignore(dir string) error {
+// databaseEncrypted reports whether the SQLite database at dbPath is
+// encrypted, without unlocking it.
+func databaseEncrypted(dbPath string) bool {
+	store, err := kaj.OpenSQLite(dbPath)
+	if err != nil {
+		return errors.Is(err, kaj.ErrEncrypted)
+	}
+	store.Close()
+	return false
+}
+
+// Encrypt encrypts a plain SQLite database under a new passphrase,
+// together with the todo text kept in kaj's own tables.
+func (d *Database) Encrypt(ctx context.Context) error {
+	store, err := d.sqliteStore()
+	if err != nil {
+		return err
+	}
+
+	keys := &keyring{}
+	if err := store.Encrypt(ctx, keys.newKey, "markdown_sync.text", "todo_commits.subject"); err != nil {
+		return err
+	}
+	keys.remember()
+	return nil
+}
+
+// addToGitignore adds entry to the .gitignore of dir unless it is there
+// already.
+func addToGitignore(dir string, entry string) error {
 	gitignorePath := filepath.Join(dir, ".gitignore")
 
 	if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
@@ -104,7 +281,7 @@ func addToGitignore(dir string) error {
 		}
 		defer file.Close()
 
-		_, err = file.WriteString("# Local todos\n.todos/\n")
+		_, err = file.WriteString("# Local todos\n" + entry + "\n")
 		return err
 	}
 
@@ -118,7 +295,7 @@ func addToGitignore(dir string) error {
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
-		if line == ".todos/" || line == ".todos" {
+		if line == entry || line == strings.TrimSuffix(entry, "/") {
 			hasTodosEntry = true
 			break
 		}
@@ -150,7 +327,7 @@ func addToGitignore(dir string) error {
 			}
 		}
 
-		_, err = file.WriteString("\n# Local todos\n.todos/\n")
+		_, err = file.WriteString("\n# Local todos\n" + entry + "\n")
 		return err
 	}
 
@@ -158,287 +335,125 @@ func addToGitignore(dir string) error {
 }
 
 func (d *Database) createTables() error {
-	todosQuery := `
-	CREATE TABLE IF NOT EXISTS todos (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
+	// markdown_sync remembers each synced checklist item as it was after
+	// the last kaj sync-md, to tell which side changed since.
+	markdownSyncQuery := `
+	CREATE TABLE IF NOT EXISTS markdown_sync (
+		path TEXT NOT NULL,
+		todo_id INTEGER NOT NULL,
 		text TEXT NOT NULL,
-		done BOOLEAN DEFAULT FALSE,
-		position INTEGER DEFAULT 0
+		done BOOLEAN NOT NULL,
+		PRIMARY KEY (path, todo_id)
 	);`
 
-	deletedQuery := `
-	CREATE TABLE IF NOT EXISTS deleted_todos (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		original_id INTEGER NOT NULL,
-		text TEXT NOT NULL,
-		done BOOLEAN DEFAULT FALSE,
-		position INTEGER DEFAULT 0,
-		deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	// todo_commits links todos to the git commits that referenced them
+	// as kaj#ID, recorded by the post-commit hook.
+	todoCommitsQuery := `
+	CREATE TABLE IF NOT EXISTS todo_commits (
+		todo_id INTEGER NOT NULL,
+		hash TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		committed_at DATETIME,
+		PRIMARY KEY (todo_id, hash)
 	);`
 
-	_, err := d.db.Exec(todosQuery)
+	_, err := d.db.Exec(markdownSyncQuery)
 	if err != nil {
 		return err
 	}
 
-	_, err = d.db.Exec(deletedQuery)
-	return err
-}
-
-func (d *Database) AddTodo(text string) error {
-	maxPosition, err := d.getMaxPosition()
+	_, err = d.db.Exec(todoCommitsQuery)
 	if err != nil {
 		return err
 	}
 
-	query := `INSERT INTO todos (text, done, position) VALUES (?, FALSE, ?)`
-	_, err = d.db.Exec(query, text, maxPosition+1)
+	return d.convertTodoEvents(context.Background())
+}
+
+// LinkedCommit is a git commit linked to a todo.
+type LinkedCommit struct {
+	Hash        string
+	Subject     string
+	CommittedAt time.Time
+}
+
+// LinkCommit records that a commit referenced a todo. Linking the same
+// commit twice, e.g. after an amend that kept the hash, is a no-op.
+func (d *Database) LinkCommit(ctx context.Context, todoID int, commit LinkedCommit) error {
+	query := `INSERT OR IGNORE INTO todo_commits (todo_id, hash, subject, committed_at) VALUES (?, ?, ?, ?)`
+	_, err := d.db.ExecContext(ctx, query, todoID, commit.Hash, kaj.Secret(commit.Subject), commit.CommittedAt.UTC())
 	return err
 }
 
-func (d *Database) GetTodos() ([]Todo, error) {
-	query := `SELECT id, text, done, position FROM todos ORDER BY position ASC`
-	rows, err := d.db.Query(query)
+func (d *Database) GetCommits(ctx context.Context, todoID int) ([]LinkedCommit, error) {
+	query := `SELECT hash, subject, committed_at FROM todo_commits WHERE todo_id = ? ORDER BY committed_at ASC`
+	rows, err := d.db.QueryContext(ctx, query, todoID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var todos []Todo
+	var commits []LinkedCommit
 	for rows.Next() {
-		var todo Todo
-		err := rows.Scan(&todo.ID, &todo.Text, &todo.Done, &todo.Position)
-		if err != nil {
+		var commit LinkedCommit
+		var committedAt sql.NullTime
+		if err := rows.Scan(&commit.Hash, &commit.Subject, &committedAt); err != nil {
 			return nil, err
 		}
-		todos = append(todos, todo)
+		commit.CommittedAt = committedAt.Time
+		commits = append(commits, commit)
 	}
 
-	return todos, nil
+	return commits, rows.Err()
 }
 
-func (d *Database) UpdateTodo(id int, text string) error {
-	query := `UPDATE todos SET text = ? WHERE id = ?`
-	_, err := d.db.Exec(query, text, id)
-	return err
-}
-
-func (d *Database) ToggleTodo(id int) error {
-	query := `UPDATE todos SET done = NOT done WHERE id = ?`
-	_, err := d.db.Exec(query, id)
-	return err
+// markdownSyncItem is the synced state of one checklist item.
+type markdownSyncItem struct {
+	Text string
+	Done bool
 }
 
-func (d *Database) DeleteTodo(id int) error {
-	todo, err := d.getTodoByID(id)
-	if err != nil {
-		return err
-	}
-
-	tx, err := d.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	insertQuery := `INSERT INTO deleted_todos (original_id, text, done, position) VALUES (?, ?, ?, ?)`
-	_, err = tx.Exec(insertQuery, todo.ID, todo.Text, todo.Done, todo.Position)
-	if err != nil {
-		return err
-	}
-
-	deleteQuery := `DELETE FROM todos WHERE id = ?`
-	_, err = tx.Exec(deleteQuery, id)
-	if err != nil {
-		return err
-	}
-
-	return tx.Commit()
-}
-
-func (d *Database) getTodoByID(id int) (*Todo, error) {
-	query := `SELECT id, text, done, position FROM todos WHERE id = ?`
-	row := d.db.QueryRow(query, id)
-
-	var todo Todo
-	err := row.Scan(&todo.ID, &todo.Text, &todo.Done, &todo.Position)
-	if err != nil {
-		return nil, err
-	}
-
-	return &todo, nil
-}
-
-func (d *Database) UndoLastDelete() (*Todo, error) {
-	query := `SELECT original_id, text, done, position FROM deleted_todos ORDER BY deleted_at DESC LIMIT 1`
-	row := d.db.QueryRow(query)
-
-	var originalID int
-	var text string
-	var done bool
-	var position int
-
-	err := row.Scan(&originalID, &text, &done, &position)
-	if err != nil {
-		return nil, err
-	}
-
-	tx, err := d.db.Begin()
-	if err != nil {
-		return nil, err
-	}
-	defer tx.Rollback()
-
-	maxPosition, err := d.getMaxPosition()
-	if err != nil {
-		return nil, err
-	}
-
-	insertQuery := `INSERT INTO todos (text, done, position) VALUES (?, ?, ?)`
-	result, err := tx.Exec(insertQuery, text, done, maxPosition+1)
-	if err != nil {
-		return nil, err
-	}
-
-	newID, err := result.LastInsertId()
-	if err != nil {
-		return nil, err
-	}
-
-	deleteQuery := `DELETE FROM deleted_todos WHERE id = (SELECT id FROM deleted_todos WHERE original_id = ? ORDER BY deleted_at DESC LIMIT 1)`
-	_, err = tx.Exec(deleteQuery, originalID)
-	if err != nil {
-		return nil, err
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		return nil, err
-	}
-
-	return &Todo{
-		ID:       int(newID),
-		Text:     text,
-		Done:     done,
-		Position: maxPosition + 1,
-	}, nil
-}
-
-func (d *Database) GetRecentlyDeleted(limit int) ([]Todo, error) {
-	query := `SELECT original_id, text, done, position FROM deleted_todos ORDER BY deleted_at DESC LIMIT ?`
-	rows, err := d.db.Query(query, limit)
+func (d *Database) GetMarkdownSyncState(ctx context.Context, path string) (map[int]markdownSyncItem, error) {
+	query := `SELECT todo_id, text, done FROM markdown_sync WHERE path = ?`
+	rows, err := d.db.QueryContext(ctx, query, path)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var deletedTodos []Todo
+	state := map[int]markdownSyncItem{}
 	for rows.Next() {
-		var todo Todo
-		err := rows.Scan(&todo.ID, &todo.Text, &todo.Done, &todo.Position)
-		if err != nil {
+		var id int
+		var item markdownSyncItem
+		if err := rows.Scan(&id, &item.Text, &item.Done); err != nil {
 			return nil, err
 		}
-		deletedTodos = append(deletedTodos, todo)
+		state[id] = item
 	}
 
-	return deletedTodos, nil
+	return state, rows.Err()
 }
 
-func (d *Database) getMaxPosition() (int, error) {
-	var maxPos sql.NullInt64
-	query := `SELECT MAX(position) FROM todos`
-	err := d.db.QueryRow(query).Scan(&maxPos)
-	if err != nil {
-		return 0, err
-	}
-
-	if maxPos.Valid {
-		return int(maxPos.Int64), nil
-	}
-	return 0, nil
-}
-
-func (d *Database) MoveTodoUp(id int) error {
-	todos, err := d.GetTodos()
-	if err != nil {
-		return err
-	}
-
-	var currentIndex = -1
-	for i, todo := range todos {
-		if todo.ID == id {
-			currentIndex = i
-			break
-		}
-	}
-
-	if currentIndex <= 0 {
-		return nil
-	}
-
-	tx, err := d.db.Begin()
+// SaveMarkdownSyncState replaces the remembered state of a file.
+func (d *Database) SaveMarkdownSyncState(ctx context.Context, path string, state map[int]markdownSyncItem) error {
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	currentTodo := todos[currentIndex]
-	aboveTodo := todos[currentIndex-1]
-
-	_, err = tx.Exec("UPDATE todos SET position = ? WHERE id = ?", aboveTodo.Position, currentTodo.ID)
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.Exec("UPDATE todos SET position = ? WHERE id = ?", currentTodo.Position, aboveTodo.ID)
+	_, err = tx.ExecContext(ctx, `DELETE FROM markdown_sync WHERE path = ?`, path)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
-}
-
-func (d *Database) MoveTodoDown(id int) error {
-	todos, err := d.GetTodos()
-	if err != nil {
-		return err
-	}
-
-	var currentIndex = -1
-	for i, todo := range todos {
-		if todo.ID == id {
-			currentIndex = i
-			break
+	insertQuery := `INSERT INTO markdown_sync (path, todo_id, text, done) VALUES (?, ?, ?, ?)`
+	for id, item := range state {
+		_, err = tx.ExecContext(ctx, insertQuery, path, id, kaj.Secret(item.Text), item.Done)
+		if err != nil {
+			return err
 		}
 	}
 
-	if currentIndex == -1 || currentIndex >= len(todos)-1 {
-		return nil
-	}
-
-	tx, err := d.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	currentTodo := todos[currentIndex]
-	belowTodo := todos[currentIndex+1]
-
-	_, err = tx.Exec("UPDATE todos SET position = ? WHERE id = ?", belowTodo.Position, currentTodo.ID)
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.Exec("UPDATE todos SET position = ? WHERE id = ?", currentTodo.Position, belowTodo.ID)
-	if err != nil {
-		return err
-	}
-
 	return tx.Commit()
 }
-
-func (d *Database) Close() error {
-	return d.db.Close()
-}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// The key of an encrypted database comes from, in order: a running kaj
+// agent, the passphrase in the key file, or a passphrase typed at the
+// terminal. A key derived from a passphrase is handed to the agent, if
+// one runs, once it opened the database, so the passphrase is asked for
+// once per session.
+
+func getKeyFilePath() (string, error) {
+	if path := os.Getenv("KAJ_KEY_FILE"); path != "" {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, ".todos", "key"), nil
+}
+
+// readKeyFile returns the passphrase in the key file, or "" when there is
+// none. A key file others can read is refused, like ssh does.
+func readKeyFile() (string, error) {
+	path, err := getKeyFilePath()
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("key file %s is accessible by others, run chmod 600 on it", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readPassphrase asks for a passphrase on the terminal, without echo.
+func readPassphrase(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("%w: no terminal to ask for the passphrase, use a key file or kaj agent", kaj.ErrEncrypted)
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	passphrase, err := term.ReadPassword(tty.Fd())
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", err
+	}
+	return string(passphrase), nil
+}
+
+// keyring finds the key of an encrypted database for NewDatabase and
+// remembers a newly derived one until the database opened with it.
+type keyring struct {
+	salt    []byte
+	derived []byte
+}
+
+// key is a kaj.KeyFunc for an existing database.
+func (k *keyring) key(salt []byte) ([]byte, error) {
+	if key, err := agentGetKey(salt); err == nil && key != nil {
+		return key, nil
+	}
+
+	passphrase, err := readKeyFile()
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		if passphrase, err = readPassphrase("Passphrase: "); err != nil {
+			return nil, err
+		}
+	}
+	return k.derive(passphrase, salt)
+}
+
+// newKey is a kaj.KeyFunc for a database being encrypted. Without a key
+// file the passphrase is asked for twice.
+func (k *keyring) newKey(salt []byte) ([]byte, error) {
+	passphrase, err := readKeyFile()
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		if passphrase, err = readPassphrase("New passphrase: "); err != nil {
+			return nil, err
+		}
+		repeated, err := readPassphrase("Repeat passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if passphrase != repeated {
+			return nil, usageError("passphrases do not match")
+		}
+	}
+	if passphrase == "" {
+		return nil, usageError("empty passphrase")
+	}
+	return k.derive(passphrase, salt)
+}
+
+func (k *keyring) derive(passphrase string, salt []byte) ([]byte, error) {
+	key, err := kaj.DeriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	k.salt, k.derived = salt, key
+	return key, nil
+}
+
+// remember hands a key derived from a passphrase to the agent, if one
+// runs.
+func (k *keyring) remember() {
+	if k.derived != nil {
+		agentPutKey(k.salt, k.derived)
+	}
+}
+
+func saltID(salt []byte) string {
+	return hex.EncodeToString(salt)
+}
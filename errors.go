@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// Exit codes, so scripts can tell failures apart without parsing
+// messages.
+const (
+	exitFailure         = 1
+	exitUsage           = 2
+	exitNotFound        = 3
+	exitIndexOutOfRange = 4
+	exitNothingToUndo   = 5
+	exitReadOnly        = 6
+	exitLocked          = 7
+)
+
+// jsonErrors makes fatal print errors as JSON on stderr.
+var jsonErrors bool
+
+// usageError is a problem with the arguments of a command.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// errorKind returns the exit code and the code name used in JSON output
+// for err.
+func errorKind(err error) (int, string) {
+	var usage usageError
+	switch {
+	case errors.As(err, &usage):
+		return exitUsage, "usage"
+	case errors.Is(err, kaj.ErrNotFound):
+		return exitNotFound, "not_found"
+	case errors.Is(err, kaj.ErrIndexOutOfRange):
+		return exitIndexOutOfRange, "index_out_of_range"
+	case errors.Is(err, kaj.ErrNothingToUndo):
+		return exitNothingToUndo, "nothing_to_undo"
+	case errors.Is(err, kaj.ErrNothingToRedo):
+		return exitNothingToUndo, "nothing_to_redo"
+	case errors.Is(err, kaj.ErrReadOnly):
+		return exitReadOnly, "read_only"
+	case errors.Is(err, kaj.ErrEncrypted):
+		return exitLocked, "encrypted"
+	case errors.Is(err, kaj.ErrWrongKey):
+		return exitLocked, "wrong_key"
+	}
+	return exitFailure, "error"
+}
+
+// fatal reports err, prefixed with what kaj was doing, and exits with
+// the code of its kind. With --json-errors it prints
+//
+//	{"error": {"code": "not_found", "message": "...", "exit_code": 3}}
+//
+// to stderr instead.
+func fatal(err error, format string, args ...any) {
+	message := err.Error()
+	if format != "" {
+		message = fmt.Sprintf(format, args...) + ": " + message
+	}
+	status, code := errorKind(err)
+
+	if jsonErrors {
+		var out struct {
+			Error struct {
+				Code     string `json:"code"`
+				Message  string `json:"message"`
+				ExitCode int    `json:"exit_code"`
+			} `json:"error"`
+		}
+		out.Error.Code = code
+		out.Error.Message = message
+		out.Error.ExitCode = status
+		json.NewEncoder(os.Stderr).Encode(out)
+	} else {
+		fmt.Println(message)
+	}
+	os.Exit(status)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Git integration: a post-commit hook runs `kaj git post-commit`, which
+// looks for kaj#ID references in the new commit's message, marks those
+// todos done and links the commit to them. Only the repository's local
+// .todos database is touched, and no remote is needed.
+
+var todoReference = regexp.MustCompile(`(?i)\bkaj#(\d+)\b`)
+
+// hookMarker identifies the line kaj adds to a hook script.
+const hookMarker = "git post-commit"
+
+// parseTodoReferences returns the todo IDs referenced in a commit
+// message, such as "closes kaj#42", without duplicates.
+func parseTodoReferences(message string) []int {
+	var ids []int
+	seen := map[int]bool{}
+	for _, m := range todoReference.FindAllStringSubmatch(message, -1) {
+		id, err := strconv.Atoi(m[1])
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func git(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// installHooks adds a post-commit hook to the current repository, keeping
+// any hook that is already there. The kaj line goes right after the shebang
+// of an existing sh hook; hooks in other languages are refused. It returns
+// the path of the hook.
+func installHooks() (string, error) {
+	hooksDir, err := git("rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return "", err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	quoted := "'" + strings.ReplaceAll(executable, "'", `'\''`) + "'"
+	line := fmt.Sprintf("%s %s || true", quoted, hookMarker)
+
+	hookPath := filepath.Join(hooksDir, "post-commit")
+	content, err := os.ReadFile(hookPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	var lines []string
+	if len(content) == 0 {
+		lines = []string{"#!/bin/sh", "# Marks todos referenced as kaj#ID in the commit message done."}
+	} else {
+		lines = strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	}
+
+	// Replace an earlier kaj line, which may point at an old binary.
+	installed := false
+	for i, existing := range lines {
+		if strings.HasSuffix(existing, hookMarker+" || true") {
+			lines[i] = line
+			installed = true
+		}
+	}
+	if !installed {
+		// Run before the rest of the hook, which may exit early.
+		at := 0
+		if strings.HasPrefix(lines[0], "#!") {
+			if !shellShebang(lines[0]) {
+				return "", fmt.Errorf("%s is not a sh script (%s); add this line to it by hand:\n%s", hookPath, lines[0], line)
+			}
+			at = 1
+		}
+		lines = append(lines[:at], append([]string{line}, lines[at:]...)...)
+	}
+
+	if err := os.WriteFile(hookPath, []byte(strings.Join(lines, "\n")+"\n"), 0755); err != nil {
+		return "", err
+	}
+	return hookPath, os.Chmod(hookPath, 0755)
+}
+
+// shellShebang reports whether a #! line runs a shell that understands the
+// kaj hook line.
+func shellShebang(shebang string) bool {
+	fields := strings.Fields(strings.TrimPrefix(shebang, "#!"))
+	if len(fields) == 0 {
+		return false
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = fields[1]
+	}
+	switch interpreter {
+	case "sh", "bash", "dash", "ksh", "zsh":
+		return true
+	}
+	return false
+}
+
+// commitResult is what the post-commit hook did for one reference.
+type commitResult struct {
+	ID      int
+	Text    string
+	Missing bool
+}
+
+// recordCommit links the HEAD commit to the todos its message references
+// and marks them done.
+func recordCommit(ctx context.Context, db *Database) ([]commitResult, error) {
+	out, err := git("log", "-1", "--format=%H%x00%ct%x00%s%x00%B", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.SplitN(out, "\x00", 4)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("unexpected git log output")
+	}
+	seconds, _ := strconv.ParseInt(fields[1], 10, 64)
+	commit := LinkedCommit{
+		Hash:        fields[0],
+		Subject:     fields[2],
+		CommittedAt: time.Unix(seconds, 0),
+	}
+
+	var results []commitResult
+	for _, id := range parseTodoReferences(fields[3]) {
+		todo, err := db.GetTodo(ctx, id)
+		if err != nil {
+			results = append(results, commitResult{ID: id, Missing: true})
+			continue
+		}
+
+		if err := db.LinkCommit(ctx, id, commit); err != nil {
+			return nil, err
+		}
+		if !todo.Done {
+			if err := db.SetStatus(ctx, id, StatusDone); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, commitResult{ID: id, Text: todo.Text})
+	}
+
+	return results, nil
+}
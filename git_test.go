@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"testing"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// gitRepo makes a temporary directory the current one and a git
+// repository with one commit, and returns a function that runs git in it.
+func gitRepo(t *testing.T) func(args ...string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Chdir(t.TempDir())
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	for _, key := range []string{"GIT_AUTHOR", "GIT_COMMITTER"} {
+		t.Setenv(key+"_NAME", "Ada")
+		t.Setenv(key+"_EMAIL", "ada@example.com")
+	}
+
+	run := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "-q", "-b", "main")
+	run("commit", "-q", "--allow-empty", "-m", "start")
+	return run
+}
+
+func TestParseTodoReferences(t *testing.T) {
+	tests := []struct {
+		message string
+		want    []int
+	}{
+		{"fix the parser", nil},
+		{"closes kaj#42", []int{42}},
+		{"KAJ#1, kaj#2 and kaj#1 again\n\nsee also kaj#3", []int{1, 2, 3}},
+		{"mykaj#4 kaj#5x kaj#", nil},
+		{"(kaj#6)", []int{6}},
+	}
+	for _, test := range tests {
+		if got := parseTodoReferences(test.message); !slices.Equal(got, test.want) {
+			t.Errorf("%q: %v, want %v", test.message, got, test.want)
+		}
+	}
+}
+
+func TestRecordCommit(t *testing.T) {
+	ctx := t.Context()
+	db := testDatabase(t)
+	run := gitRepo(t)
+
+	open, err := db.AddTodo(ctx, kaj.NewTodo("fix the parser"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := db.AddTodo(ctx, kaj.NewTodo("write docs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetStatus(ctx, done, StatusDone); err != nil {
+		t.Fatal(err)
+	}
+
+	run("commit", "-q", "--allow-empty", "-m", "Fix the parser", "-m", fmt.Sprintf("closes kaj#%d, kaj#%d and kaj#99", open, done))
+	results, err := recordCommit(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []commitResult{{ID: open, Text: "fix the parser"}, {ID: done, Text: "write docs"}, {ID: 99, Missing: true}}
+	if !slices.Equal(results, want) {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+
+	todo, err := db.GetTodo(ctx, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.Status != StatusDone {
+		t.Errorf("referenced todo is %s", todo.Status)
+	}
+	commits, err := db.GetCommits(ctx, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || commits[0].Subject != "Fix the parser" || len(commits[0].Hash) != 40 {
+		t.Errorf("linked commits: %+v", commits)
+	}
+
+	// Running the hook again for the same commit links it once.
+	if _, err := recordCommit(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if commits, _ := db.GetCommits(ctx, open); len(commits) != 1 {
+		t.Errorf("commits after a second run: %+v", commits)
+	}
+}
+
+func TestShellShebang(t *testing.T) {
+	for shebang, want := range map[string]bool{
+		"#!/bin/sh":              true,
+		"#!/bin/bash -e":         true,
+		"#!/usr/bin/env bash":    true,
+		"#!/usr/bin/env python3": false,
+		"#!/usr/bin/perl":        false,
+		"#!":                     false,
+	} {
+		if got := shellShebang(shebang); got != want {
+			t.Errorf("shellShebang(%q) = %v, want %v", shebang, got, want)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// kaj log reads the history of the todos from the todo log of package
+// kaj, which holds every todo after every change. What a change did is
+// found by comparing the todo with the one before it.
+
+// historyEntry is one change of a todo, for kaj log. Text is the text of
+// the todo after the change, so the whole list's log can name todos that
+// are gone.
+type historyEntry struct {
+	TodoID int
+	Kind   string
+	Text   string
+	At     time.Time
+
+	// Description says what changed, with the values before and after.
+	Description string
+}
+
+// Kinds of history entries.
+const (
+	changeCreate  = "create"
+	changeEdit    = "edit"
+	changeStatus  = "status"
+	changeMove    = "move"
+	changeUpdate  = "update"
+	changeDelete  = "delete"
+	changeRestore = "restore"
+	changeArchive = "archive"
+	changeRemove  = "remove"
+)
+
+// convertTodoEvents moves the history of an older kaj log, kept in a
+// todo_events table, to the start of the todo log and drops the table.
+// Its rows hold only the changed value, so each todo is replayed backward
+// from its first logged event.
+func (d *Database) convertTodoEvents(ctx context.Context) error {
+	var tables int
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'todo_events'`
+	if err := d.db.QueryRowContext(ctx, query).Scan(&tables); err != nil || tables == 0 {
+		return err
+	}
+
+	logged, err := d.Events(ctx)
+	if err != nil {
+		return err
+	}
+
+	// current is each todo as the replay has it, after the row at hand.
+	current := map[int]kaj.Event{}
+	for _, event := range logged {
+		if _, ok := current[event.Todo.ID]; !ok {
+			current[event.Todo.ID] = event
+		}
+	}
+
+	tx, err := kaj.Begin(ctx, d.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type row struct {
+		todoID    int
+		action    string
+		text, old string
+		at        time.Time
+	}
+	var rows []row
+	result, err := tx.QueryContext(ctx, `SELECT todo_id, action, text, old_value, at FROM todo_events ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	for result.Next() {
+		var r row
+		if err := result.Scan(&r.todoID, &r.action, &r.text, &r.old, &r.at); err != nil {
+			result.Close()
+			return err
+		}
+		rows = append(rows, r)
+	}
+	result.Close()
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	var events []kaj.Event
+	for i := len(rows) - 1; i >= 0; i-- {
+		r := rows[i]
+		after, ok := current[r.todoID]
+		if !ok {
+			// Gone without a trace in the log, like a todo purged from
+			// the trash.
+			after = kaj.Event{At: r.at, State: kaj.StateGone, Todo: kaj.Todo{ID: r.todoID, Text: r.text, Status: kaj.StatusTodo}}
+			events = append(events, after)
+		}
+
+		after.At = r.at
+		after.Todo.Text = r.text
+		after.State = kaj.StateListed
+		switch r.action {
+		case changeDelete:
+			after.State = kaj.StateTrashed
+		case changeArchive:
+			after.State = kaj.StateArchived
+		}
+		events = append(events, after)
+
+		before := after
+		switch r.action {
+		case changeCreate:
+			before.State = kaj.StateGone
+		case changeEdit:
+			before.Todo.Text = r.old
+		case changeStatus:
+			before.Todo.Status = r.old
+			if r.old != kaj.StatusDone {
+				before.Todo.CompletedAt = nil
+			}
+		case changeMove:
+			index, _ := strconv.Atoi(r.old)
+			before.Todo.Position = listPosition(current, r.todoID, index)
+		case changeDelete, changeArchive:
+			before.State = kaj.StateListed
+		case changeRestore:
+			before.State = kaj.StateTrashed
+		}
+		current[r.todoID] = before
+	}
+	slices.Reverse(events)
+
+	if err := tx.Prepend(ctx, events); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DROP TABLE todo_events`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// listPosition returns the position that puts a todo at a 1-based index
+// among the other listed todos of a replay.
+func listPosition(todos map[int]kaj.Event, id int, index int) float64 {
+	var others []kaj.Todo
+	for _, event := range todos {
+		if event.State == kaj.StateListed && event.Todo.ID != id {
+			others = append(others, event.Todo)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if others[i].Position != others[j].Position {
+			return others[i].Position < others[j].Position
+		}
+		return others[i].ID < others[j].ID
+	})
+
+	i := min(max(index-1, 0), len(others))
+	switch {
+	case len(others) == 0:
+		return 1
+	case i == 0:
+		return others[0].Position - 1
+	case i == len(others):
+		return others[i-1].Position + 1
+	}
+	return (others[i-1].Position + others[i].Position) / 2
+}
+
+// GetHistory returns the changes of a todo, or of all todos for todoID 0,
+// since a time, oldest first.
+func (d *Database) GetHistory(ctx context.Context, todoID int, since time.Time) ([]historyEntry, error) {
+	events, err := d.Events(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []historyEntry
+	for _, entry := range history(events) {
+		if (todoID == 0 || entry.TodoID == todoID) && !entry.At.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// history replays events and describes each change they made.
+func history(events []kaj.Event) []historyEntry {
+	last := map[int]kaj.Event{}
+	listed := map[int]kaj.Todo{}
+
+	var entries []historyEntry
+	for _, event := range events {
+		todo := event.Todo
+		previous, ok := last[todo.ID]
+		last[todo.ID] = event
+		if !ok {
+			previous.State = kaj.StateGone
+		}
+
+		from := 0
+		if previous.State == kaj.StateListed && event.State == kaj.StateListed {
+			from = listIndex(listed, todo.ID)
+		}
+		if event.State == kaj.StateListed {
+			listed[todo.ID] = todo
+		} else {
+			delete(listed, todo.ID)
+		}
+
+		add := func(kind, format string, args ...any) {
+			description := fmt.Sprintf(format, args...)
+			if event.Action == kaj.ActionUndo || event.Action == kaj.ActionRedo {
+				description += " (" + event.Action + ")"
+			}
+			entries = append(entries, historyEntry{TodoID: todo.ID, Kind: kind, Text: todo.Text, At: event.At, Description: description})
+		}
+
+		switch {
+		case previous.State == event.State && event.State == kaj.StateListed:
+			old := previous.Todo
+			changed := false
+			if old.Text != todo.Text {
+				add(changeEdit, "edited %q -> %q", old.Text, todo.Text)
+				changed = true
+			}
+			if old.Status != todo.Status {
+				add(changeStatus, "status %s -> %s", old.Status, todo.Status)
+				changed = true
+			}
+			if to := listIndex(listed, todo.ID); from != to {
+				add(changeMove, "moved %d -> %d", from, to)
+				changed = true
+			}
+			if !changed && detailsChanged(old, todo) {
+				add(changeUpdate, "updated %q", todo.Text)
+			}
+		case previous.State == event.State:
+		case event.State == kaj.StateListed && previous.State == kaj.StateGone:
+			add(changeCreate, "created %q", todo.Text)
+		case event.State == kaj.StateListed:
+			add(changeRestore, "restored %q", todo.Text)
+		case event.State == kaj.StateTrashed:
+			add(changeDelete, "deleted %q", todo.Text)
+		case event.State == kaj.StateArchived:
+			add(changeArchive, "archived %q", todo.Text)
+		default:
+			add(changeRemove, "removed %q", todo.Text)
+		}
+	}
+	return entries
+}
+
+// listIndex returns the 1-based index of a todo in the list, or 0.
+func listIndex(listed map[int]kaj.Todo, id int) int {
+	if _, ok := listed[id]; !ok {
+		return 0
+	}
+	todos := make([]kaj.Todo, 0, len(listed))
+	for _, todo := range listed {
+		todos = append(todos, todo)
+	}
+	sort.Slice(todos, func(i, j int) bool {
+		if todos[i].Position != todos[j].Position {
+			return todos[i].Position < todos[j].Position
+		}
+		return todos[i].ID < todos[j].ID
+	})
+	for i, todo := range todos {
+		if todo.ID == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// detailsChanged reports whether fields kaj log does not describe one by
+// one differ between two versions of a todo.
+func detailsChanged(a, b kaj.Todo) bool {
+	sameTime := func(a, b *time.Time) bool {
+		return a == nil && b == nil || a != nil && b != nil && a.Equal(*b)
+	}
+	return a.Priority != b.Priority || !sameTime(a.Due, b.Due) || a.Notes != b.Notes || a.ParentID != b.ParentID ||
+		a.Source != b.Source || a.SourceRef != b.SourceRef || a.Branch != b.Branch || !maps.Equal(a.Extensions, b.Extensions)
+}
+
+// loggedStore is a store that keeps the todo log, as every store kaj
+// opens does.
+type loggedStore interface {
+	Undo(ctx context.Context) ([]kaj.Event, error)
+	Redo(ctx context.Context) ([]kaj.Event, error)
+}
+
+// undoChange undoes the last change, or redoes the last undo, like kaj
+// undo and redo, for the TUI, kaj serve and kaj rpc. A store without the
+// log, such as a kaj.MemoryStore, can only undo its last delete.
+func undoChange(ctx context.Context, db kaj.Store, redo bool) ([]kaj.Event, error) {
+	if logged, ok := db.(loggedStore); ok {
+		if redo {
+			return logged.Redo(ctx)
+		}
+		return logged.Undo(ctx)
+	}
+
+	if redo {
+		return nil, kaj.ErrNothingToRedo
+	}
+	todo, err := db.UndoLastDelete(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []kaj.Event{{Action: kaj.ActionDelete, State: kaj.StateListed, Todo: *todo}}, nil
+}
+
+// describeBatch says what a batch of events did, for kaj undo and redo:
+// the action and the todo it changed, or how many.
+func describeBatch(events []kaj.Event) string {
+	if len(events) == 0 {
+		return "nothing: the todos were changed since"
+	}
+	ids := map[int]bool{}
+	for _, event := range events {
+		ids[event.Todo.ID] = true
+	}
+
+	action := events[0].Action
+	switch action {
+	case kaj.ActionCreate:
+		action = "add"
+	case kaj.ActionStatus:
+		action = "status change"
+	}
+	if len(ids) == 1 {
+		return fmt.Sprintf("%s of %q", action, events[0].Todo.Text)
+	}
+	return fmt.Sprintf("%s of %d todos", action, len(ids))
+}
+
+// parseSince reads the --since of kaj log: today, yesterday, a date, or
+// a time ago such as 3d, 2w or 90m.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch value {
+	case "":
+		return time.Time{}, nil
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", value, now.Location()); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, now.Location()); err == nil {
+		return t, nil
+	}
+	for suffix, unit := range map[string]int{"d": 1, "w": 7} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(value, suffix)); err == nil && strings.HasSuffix(value, suffix) && n >= 0 {
+			return now.AddDate(0, 0, -n*unit), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, usageError(fmt.Sprintf("%q, want today, yesterday, a date like 2026-10-01 or a time ago like 3d", value))
+}
+
+// parseAt reads the --at of kaj list like parseSince, but a date or a
+// time means its end, so the list includes the changes made during it.
+func parseAt(value string, now time.Time) (time.Time, error) {
+	switch value {
+	case "today":
+		return now, nil
+	case "yesterday":
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		return midnight.Add(-time.Nanosecond), nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", value, now.Location()); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, now.Location()); err == nil {
+		return t.Add(time.Minute - time.Nanosecond), nil
+	}
+	return parseSince(value, now)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// TestConvertTodoEvents opens a database of a kaj that kept the history
+// in todo_events, and expects kaj log to show the same changes.
+func TestConvertTodoEvents(t *testing.T) {
+	ctx := t.Context()
+	path := filepath.Join(t.TempDir(), "todos.db")
+
+	store, err := kaj.OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := store.AddTodo(ctx, kaj.NewTodo("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.AddTodo(ctx, kaj.NewTodo("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetStatus(ctx, b, StatusDone); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MoveTodoTo(ctx, b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tables of that version: no todo log, and todo_events.
+	start := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	setup := []string{
+		`DELETE FROM todo_log`,
+		`CREATE TABLE todo_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			todo_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			text TEXT NOT NULL,
+			old_value TEXT NOT NULL,
+			new_value TEXT NOT NULL,
+			at DATETIME NOT NULL
+		)`,
+	}
+	for _, query := range setup {
+		if _, err := store.DB().Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows := []struct {
+		id                     int
+		action, text, old, new string
+	}{
+		{a, "create", "a0", "", "a0"},
+		{b, "create", "b", "", "b"},
+		{a, "edit", "a", "a0", "a"},
+		{b, "status", "b", StatusTodo, StatusDone},
+		{b, "move", "b", "2", "1"},
+		{3, "create", "c", "", "c"},
+		{3, "delete", "c", "", ""},
+	}
+	for i, row := range rows {
+		query := `INSERT INTO todo_events (todo_id, action, text, old_value, new_value, at) VALUES (?, ?, ?, ?, ?, ?)`
+		at := start.Add(time.Duration(i) * time.Hour)
+		if _, err := store.DB().Exec(query, row.id, row.action, row.text, row.old, row.new, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	store, err = kaj.OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	d := &Database{todoStore: store, db: store.DB()}
+	if err := d.createTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := d.GetHistory(ctx, 0, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Description)
+	}
+	want := []string{
+		`created "a0"`,
+		`created "b"`,
+		`edited "a0" -> "a"`,
+		`status todo -> done`,
+		`moved 2 -> 1`,
+		`created "c"`,
+		`deleted "c"`,
+		`removed "c"`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+
+	todos, err := d.TodosAt(ctx, start.Add(150*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todos) != 2 || todos[0].Text != "a" || todos[1].Status != StatusTodo {
+		t.Errorf("todos before the status change: %+v", todos)
+	}
+	if _, err := d.Undo(ctx); !errors.Is(err, kaj.ErrNothingToUndo) {
+		t.Errorf("undo of converted history: %v", err)
+	}
+	if err := d.createTables(); err != nil {
+		t.Errorf("opening again: %v", err)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// iCalendar (RFC 5545) VTODO components. Todos are exported with a UID
+// that is stored in external_ids on first export, so calendars that
+// subscribe to the file see the same items across exports, and importing
+// a file again updates the todos it created instead of duplicating them.
+
+const icsSource = "ics"
+
+const (
+	icsDateTime = "20060102T150405Z"
+	icsDate     = "20060102"
+)
+
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+func icsEscape(s string) string {
+	return icsEscaper.Replace(s)
+}
+
+func icsUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// icsSplitList splits a comma separated value, honoring escaped commas.
+func icsSplitList(s string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ',':
+			parts = append(parts, icsUnescape(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, icsUnescape(s[start:]))
+}
+
+// icsFold writes a content line, folding it at 75 octets without
+// splitting UTF-8 sequences.
+func icsFold(w io.Writer, line string) error {
+	for len(line) > 75 {
+		cut := 75
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		if _, err := io.WriteString(w, line[:cut]+"\r\n "); err != nil {
+			return err
+		}
+		line = line[cut:]
+	}
+	_, err := io.WriteString(w, line+"\r\n")
+	return err
+}
+
+// icsPriority maps priorities A-I onto 1-9, RFC 5545's highest to lowest.
+func icsPriority(priority string) int {
+	if len(priority) != 1 || priority[0] < 'A' || priority[0] > 'Z' {
+		return 0
+	}
+	if priority[0] > 'I' {
+		return 9
+	}
+	return int(priority[0]-'A') + 1
+}
+
+func priorityFromICS(value int) string {
+	if value < 1 || value > 9 {
+		return ""
+	}
+	return string(rune('A' + value - 1))
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format(icsDateTime)
+}
+
+// icsDue writes date-only due dates (midnight local time) as DATE values.
+func icsDue(t time.Time) string {
+	local := t.In(time.Local)
+	if local.Hour() == 0 && local.Minute() == 0 && local.Second() == 0 {
+		return "DUE;VALUE=DATE:" + local.Format(icsDate)
+	}
+	return "DUE:" + icsTime(t)
+}
+
+func writeICS(ctx context.Context, w io.Writer, db *Database) error {
+	todos, err := db.GetTodos(ctx)
+	if err != nil {
+		return err
+	}
+
+	uids, err := db.ExternalIDs(ctx, icsSource)
+	if err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		if _, ok := uids[todo.ID]; !ok {
+			uids[todo.ID] = newUUID() + "@kaj"
+			if err := db.LinkExternalID(ctx, icsSource, uids[todo.ID], todo.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	now := icsTime(time.Now())
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//kaj//kaj " + Version + "//EN",
+		"CALSCALE:GREGORIAN",
+	}
+	for _, todo := range todos {
+		lines = append(lines,
+			"BEGIN:VTODO",
+			"UID:"+uids[todo.ID],
+			"DTSTAMP:"+now,
+			"SUMMARY:"+icsEscape(todo.Text),
+		)
+
+		switch todo.Status {
+		case StatusDone:
+			lines = append(lines, "STATUS:COMPLETED")
+		case StatusDoing:
+			lines = append(lines, "STATUS:IN-PROCESS")
+		default:
+			lines = append(lines, "STATUS:NEEDS-ACTION")
+		}
+
+		if todo.Notes != "" {
+			lines = append(lines, "DESCRIPTION:"+icsEscape(todo.Notes))
+		}
+		if todo.CreatedAt != nil {
+			lines = append(lines, "CREATED:"+icsTime(*todo.CreatedAt))
+		}
+		if todo.CompletedAt != nil && todo.Done {
+			lines = append(lines, "COMPLETED:"+icsTime(*todo.CompletedAt))
+		}
+		if todo.Due != nil {
+			lines = append(lines, icsDue(*todo.Due))
+		}
+		if p := icsPriority(todo.Priority); p > 0 {
+			lines = append(lines, "PRIORITY:"+strconv.Itoa(p))
+		}
+
+		var categories []string
+		for _, project := range todo.Projects {
+			categories = append(categories, icsEscape(project))
+		}
+		for _, context := range todo.Contexts {
+			categories = append(categories, icsEscape("@"+context))
+		}
+		if len(categories) > 0 {
+			lines = append(lines, "CATEGORIES:"+strings.Join(categories, ","))
+		}
+
+		if parent, ok := uids[todo.ParentID]; ok && todo.ParentID != 0 {
+			lines = append(lines, "RELATED-TO;RELTYPE=PARENT:"+parent)
+		}
+
+		lines = append(lines, "END:VTODO")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if err := icsFold(bw, line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func parseICSProperty(line string) (icsProperty, bool) {
+	// The value starts at the first colon outside a quoted parameter.
+	inQuotes := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			inQuotes = !inQuotes
+		} else if c == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icsProperty{}, false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	prop := icsProperty{
+		name:   strings.ToUpper(parts[0]),
+		params: map[string]string{},
+		value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		if key, value, ok := strings.Cut(param, "="); ok {
+			prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return prop, true
+}
+
+func parseICSTime(prop icsProperty) (*time.Time, error) {
+	value := prop.value
+	loc := time.Local
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	var t time.Time
+	var err error
+	switch {
+	case prop.params["VALUE"] == "DATE" || len(value) == len(icsDate):
+		t, err = time.ParseInLocation(icsDate, value, time.Local)
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse(icsDateTime, value)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", prop.name, value)
+	}
+	return &t, nil
+}
+
+// readICS returns the VTODO components of a calendar, ignoring all other
+// components. Parents are ordered before their children where possible.
+func readICS(r io.Reader) ([]ExternalTodo, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var items []ExternalTodo
+	var current *ExternalTodo
+	depth := 0
+	for _, line := range lines {
+		prop, ok := parseICSProperty(line)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VTODO"):
+			current = &ExternalTodo{Todo: Todo{Status: StatusTodo}}
+			depth = 0
+			continue
+		case current == nil:
+			continue
+		case prop.name == "BEGIN":
+			// Nested components such as VALARM.
+			depth++
+			continue
+		case prop.name == "END" && depth > 0:
+			depth--
+			continue
+		case depth > 0:
+			continue
+		case prop.name == "END" && strings.EqualFold(prop.value, "VTODO"):
+			if current.ExternalID == "" {
+				return nil, fmt.Errorf("VTODO without UID")
+			}
+			if current.Text == "" {
+				current.Text = "(no summary)"
+			}
+			current.Done = current.Status == StatusDone
+			current.Projects, current.Contexts = kaj.ParseTags(current.Text)
+			items = append(items, *current)
+			current = nil
+			continue
+		}
+
+		var err error
+		switch prop.name {
+		case "UID":
+			current.ExternalID = prop.value
+		case "SUMMARY":
+			current.Text = strings.Join(strings.Fields(icsUnescape(prop.value)), " ")
+		case "DESCRIPTION":
+			current.Notes = icsUnescape(prop.value)
+		case "STATUS":
+			switch strings.ToUpper(prop.value) {
+			case "COMPLETED", "CANCELLED":
+				current.Status = StatusDone
+			case "IN-PROCESS":
+				current.Status = StatusDoing
+			default:
+				current.Status = StatusTodo
+			}
+		case "PRIORITY":
+			value, _ := strconv.Atoi(prop.value)
+			current.Priority = priorityFromICS(value)
+		case "DUE":
+			current.Due, err = parseICSTime(prop)
+		case "CREATED":
+			current.CreatedAt, err = parseICSTime(prop)
+		case "COMPLETED":
+			current.CompletedAt, err = parseICSTime(prop)
+		case "CATEGORIES":
+			for _, category := range icsSplitList(prop.value) {
+				current.Text = addTag(current.Text, category)
+			}
+		case "RELATED-TO":
+			if reltype := prop.params["RELTYPE"]; reltype == "" || strings.EqualFold(reltype, "PARENT") {
+				current.ParentExternalID = prop.value
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return orderParentsFirst(items), nil
+}
+
+// addTag appends a category to a todo text as a +project, or as a
+// @context if it starts with "@", unless the text already has it.
+func addTag(text, category string) string {
+	word := strings.Join(strings.Fields(category), "-")
+	if word == "" {
+		return text
+	}
+	if !strings.HasPrefix(word, "@") && !strings.HasPrefix(word, "+") {
+		word = "+" + word
+	}
+
+	for _, existing := range strings.Fields(text) {
+		if existing == word {
+			return text
+		}
+	}
+	return text + " " + word
+}
+
+// orderParentsFirst moves children after their parents, so that
+// ImportExternal can resolve parent IDs in a single pass.
+func orderParentsFirst(items []ExternalTodo) []ExternalTodo {
+	byID := map[string]ExternalTodo{}
+	for _, item := range items {
+		byID[item.ExternalID] = item
+	}
+
+	ordered := make([]ExternalTodo, 0, len(items))
+	visited := map[string]bool{}
+	var visit func(item ExternalTodo)
+	visit = func(item ExternalTodo) {
+		if visited[item.ExternalID] {
+			return
+		}
+		visited[item.ExternalID] = true
+		if parent, ok := byID[item.ParentExternalID]; ok {
+			visit(parent)
+		}
+		ordered = append(ordered, item)
+	}
+	for _, item := range items {
+		visit(item)
+	}
+	return ordered
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+func TestICSFold(t *testing.T) {
+	line := "SUMMARY:" + strings.Repeat("ünïcödé ", 30)
+	var buf bytes.Buffer
+	if err := icsFold(&buf, line); err != nil {
+		t.Fatal(err)
+	}
+
+	physical := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n ")
+	for _, part := range physical {
+		if len(part) > 75 || !utf8.ValidString(part) {
+			t.Errorf("folded line %q: %d octets", part, len(part))
+		}
+	}
+	if len(physical) < 2 || strings.Join(physical, "") != line {
+		t.Errorf("folded into %q", physical)
+	}
+}
+
+func TestICSEscape(t *testing.T) {
+	for _, s := range []string{`plain`, `a;b,c\d`, "two\nlines", `trailing\`} {
+		if got := icsUnescape(icsEscape(s)); got != s {
+			t.Errorf("%q read back as %q", s, got)
+		}
+	}
+	if got := icsUnescape(`a\Nb\;c`); got != "a\nb;c" {
+		t.Errorf("unescaped %q", got)
+	}
+	if got := icsSplitList(`work,a\,b,@phone`); !slices.Equal(got, []string{"work", "a,b", "@phone"}) {
+		t.Errorf("split into %q", got)
+	}
+}
+
+func TestParseICSTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		line string
+		want time.Time
+	}{
+		{"DUE;VALUE=DATE:20240105", time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)},
+		{"DUE:20240105", time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)},
+		{"DUE:20240105T093000Z", time.Date(2024, 1, 5, 9, 30, 0, 0, time.UTC)},
+		{"DUE;TZID=America/New_York:20240105T093000", time.Date(2024, 1, 5, 9, 30, 0, 0, newYork)},
+		{`DUE;TZID="America/New_York":20240105T093000`, time.Date(2024, 1, 5, 9, 30, 0, 0, newYork)},
+		{"DUE:20240105T093000", time.Date(2024, 1, 5, 9, 30, 0, 0, time.Local)},
+	}
+	for _, test := range tests {
+		prop, ok := parseICSProperty(test.line)
+		if !ok {
+			t.Errorf("%q: not a property", test.line)
+			continue
+		}
+		got, err := parseICSTime(prop)
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("%q: %v %v, want %v", test.line, got, err, test.want)
+		}
+	}
+
+	prop, _ := parseICSProperty("DUE:tomorrow")
+	if _, err := parseICSTime(prop); err == nil {
+		t.Error("invalid time: no error")
+	}
+}
+
+func TestReadICS(t *testing.T) {
+	calendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VTODO",
+		"UID:child",
+		"SUMMARY:write the intro, then",
+		"  the rest",
+		"STATUS:IN-PROCESS",
+		"RELATED-TO;RELTYPE=PARENT:parent",
+		"BEGIN:VALARM",
+		"SUMMARY:not the todo",
+		"END:VALARM",
+		"END:VTODO",
+		"BEGIN:VEVENT",
+		"UID:event",
+		"SUMMARY:a meeting",
+		"END:VEVENT",
+		"BEGIN:VTODO",
+		"UID:parent",
+		"SUMMARY:write a report",
+		"STATUS:CANCELLED",
+		"PRIORITY:2",
+		`CATEGORIES:work,@desk,big plans`,
+		"END:VTODO",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	items, err := readICS(strings.NewReader(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("items: %+v", items)
+	}
+	parent, child := items[0], items[1]
+	if parent.ExternalID != "parent" || parent.Text != "write a report +work @desk +big-plans" || parent.Status != StatusDone || parent.Priority != "B" {
+		t.Errorf("parent: %+v", parent)
+	}
+	if child.Text != "write the intro, then the rest" || child.Status != StatusDoing || child.ParentExternalID != "parent" {
+		t.Errorf("child: %+v", child)
+	}
+
+	if _, err := readICS(strings.NewReader("BEGIN:VTODO\r\nSUMMARY:no uid\r\nEND:VTODO\r\n")); err == nil {
+		t.Error("VTODO without UID: no error")
+	}
+}
+
+// TestICSRoundTrip exports todos and imports the file again, and
+// expects the todos to be updated in place rather than duplicated.
+func TestICSRoundTrip(t *testing.T) {
+	ctx := t.Context()
+	db := testDatabase(t)
+
+	due := time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)
+	parent := kaj.NewTodo("plan the trip; pack, book +travel @home" + strings.Repeat(" and more", 10))
+	parent.Due = &due
+	parent.Priority = "A"
+	parent.Notes = "first line\nsecond line"
+	parentID, err := db.AddTodo(ctx, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := kaj.NewTodo(`book a \ hotel`)
+	child.ParentID = parentID
+	if _, err := db.AddTodo(ctx, child); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeICS(ctx, &buf, db); err != nil {
+		t.Fatal(err)
+	}
+	items, err := readICS(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("items: %+v", items)
+	}
+	got := items[0]
+	if got.Text != parent.Text || got.Notes != parent.Notes || got.Priority != "A" || got.Due == nil || !got.Due.Equal(due) {
+		t.Errorf("exported %+v, want %+v", got.Todo, parent)
+	}
+	if items[1].Text != child.Text || items[1].ParentExternalID != got.ExternalID {
+		t.Errorf("exported child %+v", items[1])
+	}
+
+	added, updated, err := db.ImportExternal(ctx, icsSource, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 0 || updated != 2 {
+		t.Errorf("import of the export: %d added, %d updated", added, updated)
+	}
+}
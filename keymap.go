@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type keyBinding struct {
+	action string
+	keys   []string
+	desc   string
+}
+
+func (b keyBinding) matches(msg tea.KeyMsg) bool {
+	for _, k := range b.keys {
+		if msg.String() == k {
+			return true
+		}
+	}
+	return false
+}
+
+// help renders the binding as "↑/k" for the footer and help overlay.
+func (b keyBinding) help() string {
+	names := make([]string, len(b.keys))
+	for i, k := range b.keys {
+		names[i] = keyDisplayName(k)
+	}
+	return strings.Join(names, "/")
+}
+
+func keyDisplayName(k string) string {
+	replacer := strings.NewReplacer("up", "↑", "down", "↓", "left", "←", "right", "→")
+	switch k {
+	case " ":
+		return "space"
+	case "pgup", "pgdown":
+		return k
+	}
+	return replacer.Replace(k)
+}
+
+type keyMap struct {
+	Up         keyBinding
+	Down       keyBinding
+	Toggle     keyBinding
+	Add        keyBinding
+	Edit       keyBinding
+	Delete     keyBinding
+	Undo       keyBinding
+	Redo       keyBinding
+	MoveUp     keyBinding
+	MoveDown   keyBinding
+	MoveTop    keyBinding
+	MoveBottom keyBinding
+	Refresh    keyBinding
+	Help       keyBinding
+	Quit       keyBinding
+
+	Board      keyBinding
+	FocusLeft  keyBinding
+	FocusRight keyBinding
+	MoveLeft   keyBinding
+	MoveRight  keyBinding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		Up:         keyBinding{"up", []string{"up", "k"}, "move cursor up"},
+		Down:       keyBinding{"down", []string{"down", "j"}, "move cursor down"},
+		Toggle:     keyBinding{"toggle", []string{" ", "enter"}, "toggle done"},
+		Add:        keyBinding{"add", []string{"a"}, "add todo"},
+		Edit:       keyBinding{"edit", []string{"e"}, "edit todo"},
+		Delete:     keyBinding{"delete", []string{"d"}, "delete todo"},
+		Undo:       keyBinding{"undo", []string{"u"}, "undo last change"},
+		Redo:       keyBinding{"redo", []string{"U"}, "redo last undo"},
+		MoveUp:     keyBinding{"move_up", []string{"ctrl+up", "K"}, "move todo up"},
+		MoveDown:   keyBinding{"move_down", []string{"ctrl+down", "J"}, "move todo down"},
+		MoveTop:    keyBinding{"move_top", []string{"ctrl+home", "T"}, "move todo to top"},
+		MoveBottom: keyBinding{"move_bottom", []string{"ctrl+end", "B"}, "move todo to bottom"},
+		Refresh:    keyBinding{"refresh", []string{"r"}, "refresh list"},
+		Help:       keyBinding{"help", []string{"?"}, "toggle help"},
+		Quit:       keyBinding{"quit", []string{"q"}, "quit"},
+
+		Board:      keyBinding{"board", []string{"b"}, "switch list/board view"},
+		FocusLeft:  keyBinding{"focus_left", []string{"left", "shift+tab"}, "board: previous column"},
+		FocusRight: keyBinding{"focus_right", []string{"right", "tab"}, "board: next column"},
+		MoveLeft:   keyBinding{"move_left", []string{"h"}, "board: move todo to previous column"},
+		MoveRight:  keyBinding{"move_right", []string{"l"}, "board: move todo to next column"},
+	}
+}
+
+// bindings returns pointers to every binding, in help overlay order.
+func (k *keyMap) bindings() []*keyBinding {
+	return []*keyBinding{
+		&k.Up, &k.Down, &k.Toggle, &k.Add, &k.Edit, &k.Delete,
+		&k.Undo, &k.Redo, &k.MoveUp, &k.MoveDown, &k.MoveTop, &k.MoveBottom, &k.Refresh, &k.Board,
+		&k.FocusLeft, &k.FocusRight, &k.MoveLeft, &k.MoveRight, &k.Help, &k.Quit,
+	}
+}
+
+// newKeyMap applies the "keys" section of the config on top of the
+// defaults. Each entry replaces all keys of one action. A key may only
+// be bound to one action, since the first action matching a key wins.
+func newKeyMap(overrides map[string][]string) (keyMap, error) {
+	keys := defaultKeyMap()
+
+	for action, override := range overrides {
+		found := false
+		for _, b := range keys.bindings() {
+			if b.action == action {
+				if len(override) == 0 {
+					return keys, fmt.Errorf("no keys given for action %q", action)
+				}
+				b.keys = override
+				found = true
+				break
+			}
+		}
+		if !found {
+			return keys, fmt.Errorf("unknown key action %q", action)
+		}
+	}
+
+	bound := map[string]string{}
+	for _, b := range keys.bindings() {
+		for _, k := range b.keys {
+			if other, ok := bound[k]; ok {
+				return keys, fmt.Errorf("key %q is bound to both %s and %s", k, other, b.action)
+			}
+			bound[k] = b.action
+		}
+	}
+
+	return keys, nil
+}
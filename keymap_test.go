@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewKeyMap(t *testing.T) {
+	keys, err := newKeyMap(map[string][]string{"move_up": {"ctrl+up", "ctrl+k"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys.MoveUp.help(); got != "ctrl+↑/ctrl+k" {
+		t.Errorf("move_up keys = %q", got)
+	}
+
+	for name, overrides := range map[string]map[string][]string{
+		"unknown action": {"jump": {"x"}},
+		"no keys":        {"up": {}},
+		"default key":    {"move_up": {"k"}},
+		"two overrides":  {"move_up": {"x"}, "move_down": {"x"}},
+	} {
+		if _, err := newKeyMap(overrides); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	_, err = newKeyMap(map[string][]string{"move_up": {"k"}})
+	if err == nil || !strings.Contains(err.Error(), `key "k" is bound to both up and move_up`) {
+		t.Errorf("duplicate key error = %v", err)
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
-	"fmt"
 	"os"
+	"slices"
 )
 
 func main() {
 	if err := Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		// Flag parsing stops at the first bad flag, which may come
+		// before --json-errors.
+		if slices.Contains(os.Args[1:], "--json-errors") {
+			jsonErrors = true
+		}
+		fatal(usageError(err.Error()), "")
 	}
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// TestMain runs the test binary as kaj when KAJ_TEST_MAIN is set, so tests
+// can start kaj processes without building it first.
+func TestMain(m *testing.M) {
+	if os.Getenv("KAJ_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// testDatabase returns a Database on a new SQLite file, with the tables
+// kaj's commands keep next to the todos.
+func testDatabase(t *testing.T) *Database {
+	t.Helper()
+	store, err := kaj.OpenSQLite(filepath.Join(t.TempDir(), "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	db := &Database{todoStore: store, db: store.DB()}
+	if err := db.createTables(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// The first time kaj opens a SQLite database on a day, it copies it to
+// backups/auto-<date>.db next to the database and removes the automatic
+// backups beyond the number kept. Backups taken with kaj db backup are
+// kept until removed by hand.
+
+const (
+	autoBackupPrefix  = "auto-"
+	defaultBackupKeep = 7
+)
+
+// getBackupDir returns the directory of the backups of a database.
+func getBackupDir(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "backups")
+}
+
+// backupPath returns a new path for a backup named after prefix and now.
+func backupPath(dbPath string, prefix string, now time.Time) string {
+	return filepath.Join(getBackupDir(dbPath), prefix+now.Format("20060102-150405")+".db")
+}
+
+// sqliteStore returns the store of a database in the sqlite format.
+func (d *Database) sqliteStore() (*kaj.SQLiteStore, error) {
+	store, ok := d.todoStore.(*kaj.SQLiteStore)
+	if !ok {
+		return nil, usageError("only databases in the sqlite format support this, the other formats are kept in files you commit")
+	}
+	return store, nil
+}
+
+// autoBackup takes the daily backup of a database unless it was taken
+// already, by this or another process.
+func autoBackup(ctx context.Context, store *kaj.SQLiteStore, config BackupConfig, now time.Time) error {
+	if config.Disabled {
+		return nil
+	}
+	keep := config.Keep
+	if keep <= 0 {
+		keep = defaultBackupKeep
+	}
+
+	dir := getBackupDir(store.Path())
+	path := filepath.Join(dir, autoBackupPrefix+now.Format("2006-01-02")+".db")
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := store.Backup(ctx, path); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, autoBackupPrefix+"*.db"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// restoreBackup replaces the todos of a database with those of the backup
+// at path, after backing the database up to before-restore-<time>.db. It
+// returns the path of that copy.
+func restoreBackup(ctx context.Context, store *kaj.SQLiteStore, path string, now time.Time) (string, error) {
+	saved := backupPath(store.Path(), "before-restore-", now)
+	if err := store.Backup(ctx, saved); err != nil {
+		return "", fmt.Errorf("backing up the database before restoring: %w", err)
+	}
+	if err := store.Restore(ctx, path); err != nil {
+		return saved, err
+	}
+	return saved, nil
+}
+
+// formatSize formats a size in bytes for people.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, suffix := float64(size)/unit, "KB"
+	for _, next := range []string{"MB", "GB"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// backupFiles returns the names of the files in the backup directory of
+// store.
+func backupFiles(t *testing.T, store *kaj.SQLiteStore) []string {
+	t.Helper()
+	entries, err := os.ReadDir(getBackupDir(store.Path()))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestAutoBackup(t *testing.T) {
+	ctx := t.Context()
+	store, err := kaj.OpenSQLite(filepath.Join(t.TempDir(), "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	day := time.Date(2026, 9, 1, 9, 0, 0, 0, time.Local)
+	if err := autoBackup(ctx, store, BackupConfig{Disabled: true}, day); err != nil {
+		t.Fatal(err)
+	}
+	if got := backupFiles(t, store); len(got) != 0 {
+		t.Errorf("disabled backups: %v", got)
+	}
+
+	// Once a day, keeping the newest.
+	config := BackupConfig{Keep: 2}
+	for _, at := range []time.Time{day, day.Add(8 * time.Hour), day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)} {
+		if err := autoBackup(ctx, store, config, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"auto-2026-09-02.db", "auto-2026-09-03.db"}
+	if got := backupFiles(t, store); !slices.Equal(got, want) {
+		t.Errorf("backups = %v, want %v", got, want)
+	}
+
+	// Backups taken by hand are not pruned.
+	if err := store.Backup(ctx, backupPath(store.Path(), "todos-", day)); err != nil {
+		t.Fatal(err)
+	}
+	if err := autoBackup(ctx, store, config, day.AddDate(0, 0, 3)); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"auto-2026-09-03.db", "auto-2026-09-04.db", "todos-20260901-090000.db"}
+	if got := backupFiles(t, store); !slices.Equal(got, want) {
+		t.Errorf("backups = %v, want %v", got, want)
+	}
+}
+
+// TestRestoreBackup expects a restore to keep a copy of the database it
+// replaces, from which the todos can be restored again.
+func TestRestoreBackup(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	store, err := kaj.OpenSQLite(filepath.Join(dir, "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTodo(ctx, kaj.NewTodo("old")); err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(dir, "old.db")
+	if err := store.Backup(ctx, backup); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddTodo(ctx, kaj.NewTodo("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 9, 1, 9, 0, 0, 0, time.Local)
+	saved, err := restoreBackup(ctx, store, backup, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(saved, "before-restore-20260901-090000.db") {
+		t.Errorf("saved to %s", saved)
+	}
+	if todos, _ := store.GetTodos(ctx); len(todos) != 1 {
+		t.Errorf("after restore: %+v", todos)
+	}
+
+	if _, err := restoreBackup(ctx, store, saved, now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if todos, _ := store.GetTodos(ctx); len(todos) != 2 {
+		t.Errorf("after undoing the restore: %+v", todos)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// Markdown checklists:
+//
+//	## backend
+//	- [ ] fix login +backend
+//	  - [x] write test
+//
+// Nested items become children of the item above them. Level 2+ headings
+// name a project, which import adds to the text of the items below it.
+// kaj sync-md additionally tags each item with an HTML comment holding
+// its todo ID, so the file can be edited freely between syncs.
+
+var (
+	markdownItem    = regexp.MustCompile(`^(\s*)([-*+]) \[([ xX])\] (.*?)\s*(?:<!-- kaj:(\d+) -->)?\s*$`)
+	markdownHeading = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+)
+
+type markdownLine struct {
+	raw    string
+	isItem bool
+	indent string
+	bullet string
+	done   bool
+	text   string
+	id     int
+
+	headingLevel int
+	heading      string
+}
+
+func parseMarkdownLine(raw string) markdownLine {
+	line := markdownLine{raw: raw}
+
+	if m := markdownItem.FindStringSubmatch(raw); m != nil {
+		line.isItem = true
+		line.indent = m[1]
+		line.bullet = m[2]
+		line.done = m[3] != " "
+		line.text = m[4]
+		line.id, _ = strconv.Atoi(m[5])
+		return line
+	}
+
+	if m := markdownHeading.FindStringSubmatch(raw); m != nil {
+		line.headingLevel = len(m[1])
+		line.heading = m[2]
+	}
+
+	return line
+}
+
+// indentWidth counts a tab as four spaces.
+func indentWidth(indent string) int {
+	return len(strings.ReplaceAll(indent, "\t", "    "))
+}
+
+func formatMarkdownItem(indent, bullet string, done bool, text string, id int) string {
+	check := " "
+	if done {
+		check = "x"
+	}
+
+	line := fmt.Sprintf("%s%s [%s] %s", indent, bullet, check, text)
+	if id > 0 {
+		line += fmt.Sprintf(" <!-- kaj:%d -->", id)
+	}
+	return line
+}
+
+func markdownProject(heading string) string {
+	return strings.Join(strings.Fields(heading), "-")
+}
+
+// markdownParents tracks the open items above the current line, so that
+// each item can find its parent by indentation.
+type markdownParents struct {
+	indents []int
+	refs    []int
+}
+
+// push records an item and returns the ref of its parent, or 0.
+func (p *markdownParents) push(indent string, ref int) int {
+	width := indentWidth(indent)
+	for len(p.indents) > 0 && p.indents[len(p.indents)-1] >= width {
+		p.indents = p.indents[:len(p.indents)-1]
+		p.refs = p.refs[:len(p.refs)-1]
+	}
+
+	parent := 0
+	if len(p.refs) > 0 {
+		parent = p.refs[len(p.refs)-1]
+	}
+
+	p.indents = append(p.indents, width)
+	p.refs = append(p.refs, ref)
+	return parent
+}
+
+func (p *markdownParents) reset() {
+	p.indents = nil
+	p.refs = nil
+}
+
+func readMarkdown(r io.Reader) ([]Todo, error) {
+	var todos []Todo
+	var parents markdownParents
+	project := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := parseMarkdownLine(scanner.Text())
+
+		if line.headingLevel > 0 {
+			project = ""
+			if line.headingLevel > 1 {
+				project = markdownProject(line.heading)
+			}
+			parents.reset()
+			continue
+		}
+
+		if !line.isItem || line.text == "" {
+			continue
+		}
+
+		todo := Todo{Text: line.text, Status: StatusTodo}
+		if line.done {
+			todo.Status = StatusDone
+			todo.Done = true
+		}
+
+		if project != "" {
+			projects, _ := kaj.ParseTags(todo.Text)
+			found := false
+			for _, p := range projects {
+				found = found || p == project
+			}
+			if !found {
+				todo.Text += " +" + project
+			}
+		}
+
+		todo.ParentRef = parents.push(line.indent, len(todos)+1)
+		todos = append(todos, todo)
+	}
+
+	return todos, scanner.Err()
+}
+
+func writeMarkdown(w io.Writer, todos []Todo) error {
+	ids := map[int]bool{}
+	for _, todo := range todos {
+		ids[todo.ID] = true
+	}
+
+	children := map[int][]Todo{}
+	var groups []string
+	grouped := map[string][]Todo{}
+	for _, todo := range todos {
+		if todo.ParentID != 0 && ids[todo.ParentID] {
+			children[todo.ParentID] = append(children[todo.ParentID], todo)
+			continue
+		}
+
+		group := ""
+		if len(todo.Projects) > 0 {
+			group = todo.Projects[0]
+		}
+		if _, ok := grouped[group]; !ok {
+			groups = append(groups, group)
+		}
+		grouped[group] = append(grouped[group], todo)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# Todos")
+
+	var writeItems func(items []Todo, depth int)
+	writeItems = func(items []Todo, depth int) {
+		for _, todo := range items {
+			fmt.Fprintln(bw, formatMarkdownItem(strings.Repeat("  ", depth), "-", todo.Done, todo.Text, 0))
+			writeItems(children[todo.ID], depth+1)
+		}
+	}
+
+	// Items without a project come first, without a heading.
+	if items, ok := grouped[""]; ok {
+		fmt.Fprintln(bw)
+		writeItems(items, 0)
+	}
+	for _, group := range groups {
+		if group == "" {
+			continue
+		}
+		fmt.Fprintf(bw, "\n## %s\n\n", group)
+		writeItems(grouped[group], 0)
+	}
+
+	return bw.Flush()
+}
+
+type markdownSyncReport struct {
+	AddedToDatabase   int
+	AddedToFile       int
+	UpdatedDatabase   int
+	UpdatedFile       int
+	DeletedInDatabase int
+	DeletedInFile     int
+	Conflicts         []string
+}
+
+// syncMarkdown two-way syncs a checklist file with the database. Each
+// item is compared with its state after the previous sync: whichever side
+// differs from it wins, and if both changed the database wins and the
+// item is reported as a conflict. An item whose ID an earlier line
+// already has was copied in the file, and is added as a new todo.
+func syncMarkdown(ctx context.Context, db *Database, path string) (*markdownSyncReport, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	content, err := os.ReadFile(absPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(content) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+
+	base, err := db.GetMarkdownSyncState(ctx, absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	todos, err := db.GetTodos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int]Todo{}
+	for _, todo := range todos {
+		byID[todo.ID] = todo
+	}
+
+	report := &markdownSyncReport{}
+	state := map[int]markdownSyncItem{}
+	seen := map[int]bool{}
+	var out []string
+	var parents markdownParents
+
+	for _, raw := range lines {
+		line := parseMarkdownLine(raw)
+		if line.headingLevel > 0 {
+			parents.reset()
+		}
+		if !line.isItem || line.text == "" {
+			out = append(out, raw)
+			continue
+		}
+
+		fileItem := markdownSyncItem{Text: line.text, Done: line.done}
+		todo, inDatabase := byID[line.id]
+		previous, synced := base[line.id]
+		if seen[line.id] {
+			inDatabase, synced = false, false
+		}
+		seen[line.id] = true
+
+		switch {
+		case line.id != 0 && inDatabase:
+			dbItem := markdownSyncItem{Text: todo.Text, Done: todo.Done}
+			result := dbItem
+
+			switch {
+			case fileItem == dbItem:
+			case synced && dbItem == previous:
+				if err := applyMarkdownItem(ctx, db, todo, fileItem); err != nil {
+					return nil, err
+				}
+				result = fileItem
+				report.UpdatedDatabase++
+			case synced && fileItem == previous:
+				report.UpdatedFile++
+			default:
+				report.Conflicts = append(report.Conflicts, todo.Text)
+				report.UpdatedFile++
+			}
+
+			state[line.id] = result
+			delete(byID, line.id)
+			parents.push(line.indent, line.id)
+			out = append(out, formatMarkdownItem(line.indent, line.bullet, result.Done, result.Text, line.id))
+
+		case line.id != 0 && synced:
+			// Deleted from the database since the last sync.
+			report.DeletedInFile++
+
+		default:
+			parentID := parents.push(line.indent, 0)
+			status := StatusTodo
+			if line.done {
+				status = StatusDone
+			}
+
+			id, err := db.AddTodo(ctx, Todo{Text: line.text, Status: status, ParentID: parentID})
+			if err != nil {
+				return nil, err
+			}
+			parents.refs[len(parents.refs)-1] = id
+
+			state[id] = fileItem
+			report.AddedToDatabase++
+			out = append(out, formatMarkdownItem(line.indent, line.bullet, line.done, line.text, id))
+		}
+	}
+
+	for _, todo := range todos {
+		if _, ok := byID[todo.ID]; !ok {
+			continue
+		}
+
+		if _, synced := base[todo.ID]; synced {
+			if err := db.DeleteTodo(ctx, todo.ID); err != nil {
+				return nil, err
+			}
+			report.DeletedInDatabase++
+			continue
+		}
+
+		state[todo.ID] = markdownSyncItem{Text: todo.Text, Done: todo.Done}
+		report.AddedToFile++
+		out = append(out, formatMarkdownItem("", "-", todo.Done, todo.Text, todo.ID))
+	}
+
+	tmpPath := absPath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strings.Join(out, "\n")+"\n"), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, absPath); err != nil {
+		return nil, err
+	}
+
+	return report, db.SaveMarkdownSyncState(ctx, absPath, state)
+}
+
+func applyMarkdownItem(ctx context.Context, db *Database, todo Todo, item markdownSyncItem) error {
+	if item.Text != todo.Text {
+		if err := db.UpdateTodo(ctx, todo.ID, item.Text); err != nil {
+			return err
+		}
+	}
+
+	if item.Done != todo.Done {
+		status := StatusTodo
+		if item.Done {
+			status = StatusDone
+		}
+		return db.SetStatus(ctx, todo.ID, status)
+	}
+
+	return nil
+}
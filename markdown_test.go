@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// syncFile writes a checklist, syncs it and returns the file afterwards.
+func syncFile(t *testing.T, db *Database, path, content string) (string, *markdownSyncReport) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := syncMarkdown(t.Context(), db, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), report
+}
+
+// dbTexts returns the texts of the todos, with [x] before done ones.
+func dbTexts(t *testing.T, db *Database) []string {
+	t.Helper()
+	todos, err := db.GetTodos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, todo := range todos {
+		text := todo.Text
+		if todo.Done {
+			text = "[x] " + text
+		}
+		texts = append(texts, text)
+	}
+	return texts
+}
+
+func TestSyncMarkdown(t *testing.T) {
+	ctx := t.Context()
+	db := testDatabase(t)
+	path := filepath.Join(t.TempDir(), "TODO.md")
+
+	c, err := db.AddTodo(ctx, kaj.NewTodo("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first sync adds the file's items to the database and the
+	// database's todos to the file.
+	file, report := syncFile(t, db, path, "# Plan\n\n- [ ] a\n- [x] b\n")
+	want := "# Plan\n\n- [ ] a <!-- kaj:2 -->\n- [x] b <!-- kaj:3 -->\n- [ ] c <!-- kaj:1 -->\n"
+	if file != want {
+		t.Fatalf("file after the first sync:\n%s\nwant:\n%s", file, want)
+	}
+	if report.AddedToDatabase != 2 || report.AddedToFile != 1 {
+		t.Errorf("first sync: %+v", report)
+	}
+
+	// Changes in the file go to the database, changes in the database to
+	// the file.
+	if err := db.UpdateTodo(ctx, c, "c from kaj"); err != nil {
+		t.Fatal(err)
+	}
+	file, report = syncFile(t, db, path, strings.Replace(strings.Replace(file, "[ ] a", "[x] a from file", 1), "[x] b", "[ ] b", 1))
+	if got := dbTexts(t, db); !slices.Equal(got, []string{"c from kaj", "[x] a from file", "b"}) {
+		t.Errorf("database after edits: %v", got)
+	}
+	if !strings.Contains(file, "- [ ] c from kaj <!-- kaj:1 -->") {
+		t.Errorf("file after edits:\n%s", file)
+	}
+	if report.UpdatedDatabase != 2 || report.UpdatedFile != 1 || len(report.Conflicts) != 0 {
+		t.Errorf("edits: %+v", report)
+	}
+
+	// Both sides changed: the database wins.
+	if err := db.UpdateTodo(ctx, c, "c again from kaj"); err != nil {
+		t.Fatal(err)
+	}
+	file, report = syncFile(t, db, path, strings.Replace(file, "c from kaj", "c from file", 1))
+	if !strings.Contains(file, "c again from kaj") || !slices.Equal(report.Conflicts, []string{"c again from kaj"}) {
+		t.Errorf("conflict: %+v\n%s", report, file)
+	}
+
+	// Deleting on either side deletes on the other.
+	if err := db.DeleteTodo(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	file, report = syncFile(t, db, path, strings.Replace(file, "- [ ] b <!-- kaj:3 -->\n", "", 1))
+	if got := dbTexts(t, db); !slices.Equal(got, []string{"[x] a from file"}) {
+		t.Errorf("database after deletes: %v", got)
+	}
+	if strings.Contains(file, "kaj:1") || report.DeletedInDatabase != 1 || report.DeletedInFile != 1 {
+		t.Errorf("deletes: %+v\n%s", report, file)
+	}
+}
+
+// TestSyncMarkdownCopiedItem copies an item in the file, comment and
+// all, and expects the copy to become a new todo.
+func TestSyncMarkdownCopiedItem(t *testing.T) {
+	db := testDatabase(t)
+	path := filepath.Join(t.TempDir(), "TODO.md")
+
+	file, _ := syncFile(t, db, path, "- [ ] a\n")
+	file, report := syncFile(t, db, path, file+strings.Replace(file, "[ ] a", "[ ] a copy", 1))
+
+	want := "- [ ] a <!-- kaj:1 -->\n- [ ] a copy <!-- kaj:2 -->\n"
+	if file != want {
+		t.Errorf("file:\n%s\nwant:\n%s", file, want)
+	}
+	if got := dbTexts(t, db); !slices.Equal(got, []string{"a", "a copy"}) {
+		t.Errorf("database: %v", got)
+	}
+	if report.AddedToDatabase != 1 || report.DeletedInFile != 0 {
+		t.Errorf("report: %+v", report)
+	}
+}
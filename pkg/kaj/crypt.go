@@ -0,0 +1,364 @@
+package kaj
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// An encrypted database keeps the text, notes and tags of todos
+// encrypted with AES-256-GCM, under a key derived from a passphrase with
+// PBKDF2. The connections of a store seal values written as Secret and
+// open sealed values as they are read, so queries, transactions and the
+// helpers of this package work the same on encrypted and plain
+// databases. Sealed values are stored as text starting with sealPrefix.
+
+const sealPrefix = "kaj:enc:v1:"
+
+// keyIterations is the PBKDF2-SHA256 work factor recommended by OWASP.
+const keyIterations = 600000
+
+// sealCheck is sealed into the encryption table, to tell a wrong key
+// from a right one. It is stored without sealPrefix, so that reading it
+// does not need the key.
+const sealCheck = "kaj"
+
+// Secret marks a query argument that is encrypted when the database is.
+// Values read back are decrypted whether or not they were written in the
+// same session.
+type Secret string
+
+// KeyFunc returns the key for the salt of an encrypted database, for
+// example by asking for the passphrase and calling DeriveKey.
+type KeyFunc func(salt []byte) ([]byte, error)
+
+// DeriveKey turns a passphrase into the key of an encrypted database.
+func DeriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, keyIterations, 32)
+}
+
+// sealer holds the cipher of a store, shared by all its connections. It
+// has none until the database is unlocked.
+type sealer struct {
+	mu   sync.RWMutex
+	aead cipher.AEAD
+}
+
+func (s *sealer) setKey(key []byte) error {
+	var aead cipher.AEAD
+	if key != nil {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		if aead, err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.aead = aead
+	s.mu.Unlock()
+	return nil
+}
+
+// seal encrypts a value, or returns it unchanged when there is no key.
+func (s *sealer) seal(value string) (string, error) {
+	s.mu.RLock()
+	aead := s.aead
+	s.mu.RUnlock()
+	if aead == nil {
+		return value, nil
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), nil)
+	return sealPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a sealed value and returns any other value unchanged.
+func (s *sealer) open(value string) (string, error) {
+	if !strings.HasPrefix(value, sealPrefix) {
+		return value, nil
+	}
+
+	s.mu.RLock()
+	aead := s.aead
+	s.mu.RUnlock()
+	if aead == nil {
+		return "", ErrEncrypted
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(value[len(sealPrefix):])
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted value")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrWrongKey
+	}
+	return string(plain), nil
+}
+
+// unlock sets the key of an encrypted database, asking key for it, and
+// does nothing for a plain one.
+func (s *SQLiteStore) unlock(ctx context.Context, key KeyFunc) error {
+	var salt []byte
+	var check string
+	err := s.db.QueryRowContext(ctx, `SELECT salt, check_value FROM encryption WHERE id = 1`).Scan(&salt, &check)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.encrypted = true
+
+	if key == nil {
+		return ErrEncrypted
+	}
+	k, err := key(salt)
+	if err != nil {
+		return err
+	}
+	if err := s.sealer.setKey(k); err != nil {
+		return err
+	}
+
+	if opened, err := s.sealer.open(sealPrefix + check); err != nil || opened != sealCheck {
+		s.sealer.setKey(nil)
+		return ErrWrongKey
+	}
+	return nil
+}
+
+// Encrypted reports whether the database is encrypted.
+func (s *SQLiteStore) Encrypted() bool {
+	return s.encrypted
+}
+
+// Encrypt encrypts a plain database under a new salt and the key
+// returned for it. Besides the todo columns, extra columns given as
+// "table.column" are encrypted, for tables kept next to the todos whose
+// values are written as Secret.
+func (s *SQLiteStore) Encrypt(ctx context.Context, key KeyFunc, columns ...string) error {
+	if s.encrypted {
+		return errors.New("database is already encrypted")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	k, err := key(salt)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storeError(err)
+	}
+	defer tx.Rollback()
+
+	// Values are sealed once the key is set; take it back if anything
+	// fails, since the database stays plain then.
+	if err := s.sealer.setKey(k); err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.sealer.setKey(nil)
+		}
+	}()
+
+	var todoColumns []string
+	for _, table := range []string{"todos", "deleted_todos", "archived_todos", "todo_log"} {
+		for _, column := range []string{"text", "notes", "projects", "contexts"} {
+			todoColumns = append(todoColumns, table+"."+column)
+		}
+	}
+	for _, column := range append(todoColumns, columns...) {
+		if err := sealColumn(ctx, tx, column); err != nil {
+			return storeError(err)
+		}
+	}
+
+	check, err := s.sealer.seal(sealCheck)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO encryption (id, salt, check_value) VALUES (1, ?, ?)`, salt, strings.TrimPrefix(check, sealPrefix))
+	if err != nil {
+		return storeError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return storeError(err)
+	}
+	committed = true
+	s.encrypted = true
+
+	// The plain values are still in the free pages the sealed ones
+	// replaced and in the write-ahead log; rebuild the file without them.
+	return s.Vacuum(ctx)
+}
+
+// sealColumn rewrites every value of a "table.column" as a Secret.
+func sealColumn(ctx context.Context, tx *sql.Tx, column string) error {
+	table, name, ok := strings.Cut(column, ".")
+	if !ok {
+		return fmt.Errorf("invalid column %q, want table.column", column)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT rowid, `+name+` FROM `+table)
+	if err != nil {
+		return err
+	}
+	values := map[int64]string{}
+	for rows.Next() {
+		var rowid int64
+		var value string
+		if err := rows.Scan(&rowid, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		values[rowid] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for rowid, value := range values {
+		_, err := tx.ExecContext(ctx, `UPDATE `+table+` SET `+name+` = ? WHERE rowid = ?`, Secret(value), rowid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealingConnector opens SQLite connections that seal and open values
+// with the sealer of a store.
+type sealingConnector struct {
+	dsn    string
+	sealer *sealer
+}
+
+func (c *sealingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sealingConn{Conn: conn, sealer: c.sealer}, nil
+}
+
+func (c *sealingConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+type sealingConn struct {
+	driver.Conn
+	sealer *sealer
+}
+
+// CheckNamedValue seals Secret arguments and leaves the others to the
+// default conversion.
+func (c *sealingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	secret, ok := nv.Value.(Secret)
+	if !ok {
+		return driver.ErrSkip
+	}
+	value, err := c.sealer.seal(string(secret))
+	nv.Value = value
+	return err
+}
+
+func (c *sealingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *sealingConn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+func (c *sealingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &sealingStmt{Stmt: stmt, sealer: c.sealer}, nil
+}
+
+func (c *sealingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *sealingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &sealingRows{Rows: rows, sealer: c.sealer}, nil
+}
+
+type sealingStmt struct {
+	driver.Stmt
+	sealer *sealer
+}
+
+func (s *sealingStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func (s *sealingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return &sealingRows{Rows: rows, sealer: s.sealer}, nil
+}
+
+type sealingRows struct {
+	driver.Rows
+	sealer *sealer
+}
+
+func (r *sealingRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+
+	for i, value := range dest {
+		var err error
+		switch v := value.(type) {
+		case string:
+			dest[i], err = r.sealer.open(v)
+		case []byte:
+			if strings.HasPrefix(string(v), sealPrefix) {
+				dest[i], err = r.sealer.open(string(v))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
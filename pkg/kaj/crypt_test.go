@@ -0,0 +1,109 @@
+package kaj
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// passphrase returns a KeyFunc for a fixed passphrase.
+func passphrase(p string) KeyFunc {
+	return func(salt []byte) ([]byte, error) {
+		return DeriveKey(p, salt)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	ctx := t.Context()
+	path := filepath.Join(t.TempDir(), "todos.db")
+
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := addTodos(t, store, "call the bank +finance", "renew passport")
+	if err := store.DeleteTodo(ctx, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Encrypt(ctx, passphrase("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Encrypted() {
+		t.Error("Encrypted() = false after Encrypt")
+	}
+	if err := store.Encrypt(ctx, passphrase("correct horse")); err == nil {
+		t.Error("encrypting twice succeeded")
+	}
+	addTodos(t, store, "added after encrypting")
+	store.Close()
+
+	if _, err := OpenSQLite(path); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("open without a key: %v, want ErrEncrypted", err)
+	}
+	if _, err := OpenSQLiteWithKey(path, passphrase("wrong")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("open with the wrong key: %v, want ErrWrongKey", err)
+	}
+
+	store, err = OpenSQLiteWithKey(path, passphrase("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if got := texts(t, store); !slices.Equal(got, []string{"call the bank +finance", "added after encrypting"}) {
+		t.Errorf("todos = %v", got)
+	}
+	if todo := mustGetTodo(t, store, ids[0]); !slices.Equal(todo.Projects, []string{"finance"}) {
+		t.Errorf("projects = %v", todo.Projects)
+	}
+	if _, err := store.UndoLastDelete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if todo := mustGetTodo(t, store, ids[1]); todo.Text != "renew passport" {
+		t.Errorf("restored %q", todo.Text)
+	}
+}
+
+// TestEncryptLeavesNoPlaintext looks for the text of todos in the database
+// file and its write-ahead log after encrypting, including old versions
+// of edited todos.
+func TestEncryptLeavesNoPlaintext(t *testing.T) {
+	ctx := t.Context()
+	path := filepath.Join(t.TempDir(), "todos.db")
+
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	secrets := []string{"zebra-dentist-appointment", "quokka-tax-return", "narwhal-diary-entry"}
+	ids := addTodos(t, store, secrets[0], secrets[1])
+	if err := store.UpdateTodo(ctx, ids[0], secrets[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteTodo(ctx, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Encrypt(ctx, passphrase("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{path, path + "-wal"} {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range secrets {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("%s contains %q after encrypting", filepath.Base(file), secret)
+			}
+		}
+	}
+}
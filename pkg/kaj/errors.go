@@ -0,0 +1,91 @@
+package kaj
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Errors returned by stores and list helpers. Check for them with
+// errors.Is; they may be wrapped with more detail.
+var (
+	// ErrNotFound is returned for a todo ID that does not exist.
+	ErrNotFound = errors.New("todo not found")
+
+	// ErrIndexOutOfRange is matched by every *IndexError.
+	ErrIndexOutOfRange = errors.New("index out of range")
+
+	// ErrNothingToUndo is returned by UndoLastDelete when the trash is
+	// empty, and matched by the error of Undo when every change was
+	// undone.
+	ErrNothingToUndo = errors.New("no recently deleted todos to restore")
+
+	// ErrNothingToRedo is returned by Redo when there is no undo to
+	// revert, or the todos were changed since.
+	ErrNothingToRedo = errors.New("nothing to redo")
+
+	// ErrReadOnly is returned for a change to a store that cannot be
+	// written, such as a database file without write permission.
+	ErrReadOnly = errors.New("database is read-only")
+
+	// ErrEncrypted is returned for an encrypted database opened without
+	// a key.
+	ErrEncrypted = errors.New("database is encrypted")
+
+	// ErrWrongKey is returned for an encrypted database opened with the
+	// wrong passphrase or key.
+	ErrWrongKey = errors.New("wrong passphrase for the encrypted database")
+)
+
+// IndexError reports a 1-based list index, as shown by kaj list, that
+// does not name a todo.
+type IndexError struct {
+	Index int
+	Len   int
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("no todo at index %d, the list has %d", e.Index, e.Len)
+}
+
+func (e *IndexError) Is(target error) bool {
+	return target == ErrIndexOutOfRange
+}
+
+// errNothingToUndo is the error of Undo, with a message about changes
+// rather than the trash.
+var errNothingToUndo = &undoError{}
+
+type undoError struct{}
+
+func (e *undoError) Error() string {
+	return "no changes to undo"
+}
+
+func (e *undoError) Is(target error) bool {
+	return target == ErrNothingToUndo
+}
+
+// TodoAt returns the todo at a 1-based list index.
+func TodoAt(todos []Todo, index int) (Todo, error) {
+	if index < 1 || index > len(todos) {
+		return Todo{}, &IndexError{Index: index, Len: len(todos)}
+	}
+	return todos[index-1], nil
+}
+
+// notFound wraps ErrNotFound with the ID that was asked for.
+func notFound(id int) error {
+	return fmt.Errorf("todo %d: %w", id, ErrNotFound)
+}
+
+// storeError turns SQLite errors that callers act on into the errors of
+// this package, keeping the original message.
+func storeError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrReadonly {
+		return fmt.Errorf("%w: %v", ErrReadOnly, err)
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package kaj
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ExternalTodo is a todo read from another task app, identified there by
+// ExternalID. ParentExternalID optionally names its parent in the same app.
+type ExternalTodo struct {
+	Todo
+	ExternalID       string
+	ParentExternalID string
+}
+
+// ExternalIDs returns the external ID of every mapped todo of a source.
+func (s *SQLiteStore) ExternalIDs(ctx context.Context, source string) (map[int]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT todo_id, external_id FROM external_ids WHERE source = ?`, source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[int]string{}
+	for rows.Next() {
+		var todoID int
+		var externalID string
+		if err := rows.Scan(&todoID, &externalID); err != nil {
+			return nil, err
+		}
+		ids[todoID] = externalID
+	}
+
+	return ids, rows.Err()
+}
+
+// LinkExternalID maps the ID a todo has in another app to it.
+func (s *SQLiteStore) LinkExternalID(ctx context.Context, source, externalID string, todoID int) error {
+	query := `INSERT OR REPLACE INTO external_ids (source, external_id, todo_id) VALUES (?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, source, externalID, todoID)
+	return storeError(err)
+}
+
+// ImportExternal upserts todos from another app in one transaction.
+// Items whose external ID is already mapped to an existing todo update
+// that todo in place, keeping its position; the rest are appended.
+// Parents must come before their children.
+func (s *SQLiteStore) ImportExternal(ctx context.Context, source string, items []ExternalTodo) (added, updated int, err error) {
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	position, err := getMaxPosition(ctx, tx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	todoIDs := map[string]int{}
+	for _, item := range items {
+		todo := item.Todo
+		if parentID, ok := todoIDs[item.ParentExternalID]; ok {
+			todo.ParentID = parentID
+		} else if item.ParentExternalID != "" {
+			parent, err := externalTodo(ctx, tx, source, item.ParentExternalID)
+			if err != nil {
+				return 0, 0, err
+			}
+			if parent != nil {
+				todo.ParentID = parent.ID
+			}
+		}
+		if todo.Status == "" {
+			todo.Status = StatusTodo
+		}
+
+		existing, err := externalTodo(ctx, tx, source, item.ExternalID)
+		if err != nil {
+			return 0, 0, err
+		}
+		if existing == nil {
+			position++
+			todo.ID, err = tx.Insert(ctx, ActionImport, todo, position)
+			if err != nil {
+				return 0, 0, storeError(err)
+			}
+			added++
+		} else {
+			todo.ID = existing.ID
+			todo.Position = existing.Position
+
+			// Keep extensions the other app does not know about.
+			if len(existing.Extensions) > 0 {
+				extensions := existing.Extensions
+				for key, value := range todo.Extensions {
+					extensions[key] = value
+				}
+				todo.Extensions = extensions
+			}
+			if err := tx.Record(ctx, ActionImport, StateListed, todo); err != nil {
+				return 0, 0, storeError(err)
+			}
+			updated++
+		}
+
+		query := `INSERT OR REPLACE INTO external_ids (source, external_id, todo_id) VALUES (?, ?, ?)`
+		if _, err := tx.ExecContext(ctx, query, source, item.ExternalID, todo.ID); err != nil {
+			return 0, 0, storeError(err)
+		}
+		todoIDs[item.ExternalID] = todo.ID
+	}
+
+	return added, updated, storeError(tx.Commit())
+}
+
+// externalTodo returns the listed todo an external ID is mapped to, or nil.
+func externalTodo(ctx context.Context, tx *Tx, source, externalID string) (*Todo, error) {
+	var id int
+	query := `SELECT todo_id FROM external_ids WHERE source = ? AND external_id = ?`
+	err := tx.QueryRowContext(ctx, query, source, externalID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	todo, err := getTodo(ctx, tx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return todo, err
+}
+
+// SetSourceRef updates where a todo was found, such as the file:line of
+// a scanned code comment.
+func (s *SQLiteStore) SetSourceRef(ctx context.Context, id int, ref string) error {
+	return s.changeTodo(ctx, id, ActionEdit, StateListed, func(todo *Todo) error {
+		todo.SourceRef = ref
+		return nil
+	})
+}
@@ -0,0 +1,55 @@
+package kaj
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestImportExternal(t *testing.T) {
+	ctx := t.Context()
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	addTodos(t, store, "local")
+	items := []ExternalTodo{
+		{Todo: Todo{Text: "parent", Extensions: map[string]string{"x": "1"}}, ExternalID: "p"},
+		{Todo: Todo{Text: "child"}, ExternalID: "c", ParentExternalID: "p"},
+	}
+	added, updated, err := store.ImportExternal(ctx, "ics", items)
+	if err != nil || added != 2 || updated != 0 {
+		t.Fatalf("first import: %d added, %d updated, %v", added, updated, err)
+	}
+
+	ids, err := store.ExternalIDs(ctx, "ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byExternal := map[string]int{}
+	for id, external := range ids {
+		byExternal[external] = id
+	}
+	if child := mustGetTodo(t, store, byExternal["c"]); child.ParentID != byExternal["p"] {
+		t.Errorf("child's parent = %d, want %d", child.ParentID, byExternal["p"])
+	}
+
+	// A second import updates in place and keeps extensions it does not
+	// name.
+	if err := store.MoveTodoTo(ctx, byExternal["p"], 0); err != nil {
+		t.Fatal(err)
+	}
+	items = []ExternalTodo{{Todo: Todo{Text: "parent renamed", Status: StatusDone, Extensions: map[string]string{"y": "2"}}, ExternalID: "p"}}
+	added, updated, err = store.ImportExternal(ctx, "ics", items)
+	if err != nil || added != 0 || updated != 1 {
+		t.Fatalf("second import: %d added, %d updated, %v", added, updated, err)
+	}
+	if got := texts(t, store); !slices.Equal(got, []string{"parent renamed", "local", "child"}) {
+		t.Errorf("todos = %v", got)
+	}
+	if parent := mustGetTodo(t, store, byExternal["p"]); parent.Extensions["x"] != "1" || parent.Extensions["y"] != "2" {
+		t.Errorf("extensions = %v", parent.Extensions)
+	}
+}
@@ -0,0 +1,579 @@
+package kaj
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+)
+
+// A SQLite store keeps its todos as an append-only log of events in
+// todo_log. Each event holds the whole todo after a change and where it
+// is then: in the list, the trash, the archive, or gone. The todos,
+// deleted_todos and archived_todos tables are projections of the log:
+// every change appends its events in a transaction that also applies
+// them to the projections, Rebuild regenerates the projections from the
+// log, and TodosAt replays the log up to a time.
+//
+// The events appended by one transaction form a batch. Undo appends a
+// batch that returns the todos of the last batch to their state before
+// it, and Redo reverts an undo the same way.
+
+// Actions of events, saying what changed a todo.
+const (
+	ActionCreate  = "create"
+	ActionImport  = "import"
+	ActionEdit    = "edit"
+	ActionStatus  = "status"
+	ActionMove    = "move"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionArchive = "archive"
+
+	// ActionSync is a change read from the files of a text or shared
+	// list.
+	ActionSync = "sync"
+
+	// ActionMigrate is the synthetic create event of a todo stored
+	// without the log, by a version of kaj from before it, or an event
+	// of history such a version kept, see Tx.Prepend.
+	ActionMigrate = "migrate"
+
+	ActionUndo = "undo"
+	ActionRedo = "redo"
+)
+
+// States of a todo after an event.
+const (
+	StateListed   = "listed"
+	StateTrashed  = "trashed"
+	StateArchived = "archived"
+	StateGone     = "gone"
+)
+
+// Event is an entry of the log.
+type Event struct {
+	Seq    int64
+	Batch  int64
+	Action string
+
+	// Reverts is the batch an undo reverts, or the undo a redo reverts.
+	Reverts int64
+
+	At    time.Time
+	State string
+
+	// Todo is the todo after the change, or as it was when it left the
+	// list.
+	Todo Todo
+}
+
+// eventColumns are selected with scanEvent.
+const eventColumns = `todo_id, ` + TodoColumns + `, seq, batch, action, reverts, at, state`
+
+func scanEvent(row RowScanner) (Event, error) {
+	var event Event
+	todo, err := ScanTodo(row, &event.Seq, &event.Batch, &event.Action, &event.Reverts, &event.At, &event.State)
+	event.Todo = todo
+	return event, err
+}
+
+func queryEvents(ctx context.Context, db Queryer, query string, args ...any) ([]Event, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// Tx is a transaction that changes todos by appending events to the log.
+// All its events share a batch and a time.
+type Tx struct {
+	*sql.Tx
+	at      time.Time
+	batch   int64
+	reverts int64
+}
+
+// Begin starts a transaction on the database of a SQLite store, as
+// returned by DB().
+func Begin(ctx context.Context, db *sql.DB) (*Tx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &Tx{Tx: tx, at: time.Now().UTC()}, nil
+}
+
+// Record appends an event putting todo, by its ID, in state, and applies
+// it to the projections. Nothing is recorded when the todo is already in
+// that state with the same fields.
+func (tx *Tx) Record(ctx context.Context, action, state string, todo Todo) error {
+	if tx.reverts == 0 {
+		last, err := lastEvent(ctx, tx, todo.ID, 0)
+		if err != nil {
+			return err
+		}
+		if last == nil && state == StateGone || last != nil && last.State == state && sameTodo(last.Todo, todo) {
+			return nil
+		}
+	}
+
+	if tx.batch == 0 {
+		query := `SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'todo_log'), 0) + 1`
+		if err := tx.QueryRowContext(ctx, query).Scan(&tx.batch); err != nil {
+			return err
+		}
+	}
+
+	query := `INSERT INTO todo_log (batch, action, reverts, at, state, todo_id, ` + TodoColumns + `) VALUES (?, ?, ?, ?, ?, ?, ` + TodoPlaceholders + `)`
+	args := append([]any{tx.batch, action, tx.reverts, tx.at, state, todo.ID}, TodoArgs(todo)...)
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	return apply(ctx, tx.Tx, state, todo, tx.at)
+}
+
+// Insert adds todo to the list at position under a new ID, and returns
+// the ID.
+func (tx *Tx) Insert(ctx context.Context, action string, todo Todo, position float64) (int, error) {
+	if todo.Status == "" {
+		todo.Status = StatusTodo
+		if todo.Done {
+			todo.Status = StatusDone
+		}
+	}
+	if !ValidStatus(todo.Status) {
+		return 0, errors.New("invalid status " + todo.Status)
+	}
+	todo.Position = position
+
+	id, err := tx.NewID(ctx)
+	if err != nil {
+		return 0, err
+	}
+	todo.ID = id
+
+	return todo.ID, tx.Record(ctx, action, StateListed, todo)
+}
+
+// NewID returns an ID no todo has had. IDs are never reused, so the log
+// of one todo is never mixed with that of another.
+func (tx *Tx) NewID(ctx context.Context) (int, error) {
+	var id sql.NullInt64
+	query := `SELECT MAX(id) FROM (
+		SELECT MAX(todo_id) AS id FROM todo_log
+		UNION ALL SELECT seq FROM sqlite_sequence WHERE name = 'todos'
+		UNION ALL SELECT MAX(id) FROM todos)`
+	if err := tx.QueryRowContext(ctx, query).Scan(&id); err != nil {
+		return 0, err
+	}
+	return int(id.Int64) + 1, nil
+}
+
+// Prepend inserts events before the whole log, oldest first, as migrate
+// events each in its own batch, for history an older version of kaj kept
+// elsewhere. The tables are left as they are, so the events of a todo
+// must end in the state its logged events start from, or in the state
+// the tables have it in when it has none.
+func (tx *Tx) Prepend(ctx context.Context, events []Event) error {
+	n := int64(len(events))
+	if n == 0 {
+		return nil
+	}
+
+	// The log moves up by n; seq goes through negative values so no two
+	// events share one midway.
+	query := `UPDATE todo_log SET seq = -seq - ?, batch = batch + ?, reverts = CASE reverts WHEN 0 THEN 0 ELSE reverts + ? END`
+	if _, err := tx.ExecContext(ctx, query, n, n, n); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE todo_log SET seq = -seq`); err != nil {
+		return err
+	}
+	if tx.batch != 0 {
+		tx.batch += n
+	}
+
+	query = `INSERT INTO todo_log (seq, batch, action, at, state, todo_id, ` + TodoColumns + `) VALUES (?, ?, ?, ?, ?, ?, ` + TodoPlaceholders + `)`
+	for i, event := range events {
+		seq := int64(i) + 1
+		args := append([]any{seq, seq, ActionMigrate, event.At.UTC(), event.State, event.Todo.ID}, TodoArgs(event.Todo)...)
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	// sqlite_sequence only follows inserts.
+	_, err := tx.ExecContext(ctx, `UPDATE sqlite_sequence SET seq = (SELECT MAX(seq) FROM todo_log) WHERE name = 'todo_log'`)
+	return err
+}
+
+// apply puts a todo in the projection of its state.
+func apply(ctx context.Context, tx *sql.Tx, state string, todo Todo, at time.Time) error {
+	for _, query := range []string{
+		`DELETE FROM todos WHERE id = ?`,
+		`DELETE FROM deleted_todos WHERE original_id = ?`,
+		`DELETE FROM archived_todos WHERE original_id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, todo.ID); err != nil {
+			return err
+		}
+	}
+
+	args := append([]any{todo.ID}, TodoArgs(todo)...)
+	var query string
+	switch state {
+	case StateListed:
+		query = `INSERT INTO todos (id, ` + TodoColumns + `) VALUES (?, ` + TodoPlaceholders + `)`
+	case StateTrashed:
+		query = `INSERT INTO deleted_todos (original_id, ` + TodoColumns + `, deleted_at) VALUES (?, ` + TodoPlaceholders + `, ?)`
+		args = append(args, at)
+	case StateArchived:
+		query = `INSERT INTO archived_todos (original_id, ` + TodoColumns + `, archived_at) VALUES (?, ` + TodoPlaceholders + `, ?)`
+		args = append(args, at)
+	default:
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// lastEvent returns the latest event of a todo before seq, or the
+// latest of all for seq 0, or nil when there is none.
+func lastEvent(ctx context.Context, db Queryer, id int, seq int64) (*Event, error) {
+	query := `SELECT ` + eventColumns + ` FROM todo_log WHERE todo_id = ? AND (? = 0 OR seq < ?) ORDER BY seq DESC LIMIT 1`
+	events, err := queryEvents(ctx, db, query, id, seq, seq)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	return &events[0], nil
+}
+
+// sameTodo reports whether two todos have the same stored fields.
+// Projects, Contexts and Done follow from Text and Status.
+func sameTodo(a, b Todo) bool {
+	sameTime := func(a, b *time.Time) bool {
+		return a == nil && b == nil || a != nil && b != nil && a.Equal(*b)
+	}
+	return a.ID == b.ID && a.Text == b.Text && a.Position == b.Position && a.Status == b.Status &&
+		a.Priority == b.Priority && sameTime(a.CreatedAt, b.CreatedAt) && sameTime(a.CompletedAt, b.CompletedAt) &&
+		sameTime(a.Due, b.Due) && maps.Equal(a.Extensions, b.Extensions) && a.ParentID == b.ParentID &&
+		a.Notes == b.Notes && a.Source == b.Source && a.SourceRef == b.SourceRef && a.Branch == b.Branch
+}
+
+// Events returns the whole log, oldest first.
+func (s *SQLiteStore) Events(ctx context.Context) ([]Event, error) {
+	return queryEvents(ctx, s.db, `SELECT `+eventColumns+` FROM todo_log ORDER BY seq`)
+}
+
+// latestEvents returns the last event of every todo up to a time, or of
+// all time for the zero time, in list order.
+func latestEvents(ctx context.Context, db Queryer, at time.Time) ([]Event, error) {
+	query := `SELECT ` + eventColumns + ` FROM todo_log WHERE seq IN (
+		SELECT MAX(seq) FROM todo_log WHERE ? OR at <= ? GROUP BY todo_id
+	) ORDER BY position, todo_id`
+	return queryEvents(ctx, db, query, at.IsZero(), at.UTC())
+}
+
+// TodosAt returns the list as it was at a time, in list order.
+func (s *SQLiteStore) TodosAt(ctx context.Context, at time.Time) ([]Todo, error) {
+	events, err := latestEvents(ctx, s.db, at)
+	if err != nil {
+		return nil, err
+	}
+
+	var todos []Todo
+	for _, event := range events {
+		if event.State == StateListed {
+			todos = append(todos, event.Todo)
+		}
+	}
+	return todos, nil
+}
+
+// RebuildReport says what Rebuild regenerated.
+type RebuildReport struct {
+	Events   int
+	Todos    int
+	Trash    int
+	Archived int
+}
+
+// Rebuild regenerates the todos, the trash and the archive from the log.
+func (s *SQLiteStore) Rebuild(ctx context.Context) (RebuildReport, error) {
+	var report RebuildReport
+
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM todo_log`).Scan(&report.Events); err != nil {
+		return report, err
+	}
+	events, err := latestEvents(ctx, tx, time.Time{})
+	if err != nil {
+		return report, err
+	}
+
+	for _, table := range []string{"todos", "deleted_todos", "archived_todos"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table); err != nil {
+			return report, storeError(err)
+		}
+	}
+	for _, event := range events {
+		if err := apply(ctx, tx.Tx, event.State, event.Todo, event.At); err != nil {
+			return report, storeError(err)
+		}
+		switch event.State {
+		case StateListed:
+			report.Todos++
+		case StateTrashed:
+			report.Trash++
+		case StateArchived:
+			report.Archived++
+		}
+	}
+
+	return report, storeError(tx.Commit())
+}
+
+// Undo reverts the last batch of changes that was not undone yet, and
+// returns its events. Changes read from files by sync are not undone:
+// they were made outside kaj, by hand or by others, and the todos they
+// changed are left out of the undo of an earlier batch.
+func (s *SQLiteStore) Undo(ctx context.Context) ([]Event, error) {
+	query := `SELECT batch FROM todo_log AS l
+		WHERE action NOT IN ('undo', 'migrate', 'sync')
+		AND NOT EXISTS (SELECT 1 FROM todo_log AS u WHERE u.action = 'undo' AND u.reverts = l.batch)
+		ORDER BY seq DESC LIMIT 1`
+	return s.revert(ctx, ActionUndo, query, errNothingToUndo)
+}
+
+// Redo reverts the last undo, unless the todos were changed since, and
+// returns the events of the batch the undo reverted. Like Undo it leaves
+// out todos changed by sync since.
+func (s *SQLiteStore) Redo(ctx context.Context) ([]Event, error) {
+	query := `SELECT batch FROM todo_log AS l
+		WHERE action = 'undo'
+		AND NOT EXISTS (SELECT 1 FROM todo_log AS r WHERE r.action = 'redo' AND r.reverts = l.batch)
+		AND NOT EXISTS (SELECT 1 FROM todo_log AS n WHERE n.seq > l.seq AND n.action NOT IN ('undo', 'redo', 'migrate', 'sync'))
+		ORDER BY seq DESC LIMIT 1`
+	return s.revert(ctx, ActionRedo, query, ErrNothingToRedo)
+}
+
+// revert returns the todos of the batch found by query to their state
+// before it, in a batch of action, and returns the events of the todos
+// it reverted. A todo changed since the batch, such as by a hand edit
+// read by sync, is left as it is; the batch still counts as reverted.
+func (s *SQLiteStore) revert(ctx context.Context, action, query string, nothing error) ([]Event, error) {
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query).Scan(&tx.reverts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nothing
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := queryEvents(ctx, tx, `SELECT `+eventColumns+` FROM todo_log WHERE batch = ? ORDER BY seq`, tx.reverts)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int]bool{}
+	skipped := map[int]bool{}
+	for i := len(events) - 1; i >= 0; i-- {
+		todo := events[i].Todo
+		if seen[todo.ID] {
+			continue
+		}
+		seen[todo.ID] = true
+
+		latest, err := lastEvent(ctx, tx, todo.ID, 0)
+		if err != nil {
+			return nil, err
+		}
+		if latest.State != events[i].State || !sameTodo(latest.Todo, todo) {
+			// Recorded as it is, so the batch is not found again.
+			skipped[todo.ID] = true
+			if err := tx.Record(ctx, action, latest.State, latest.Todo); err != nil {
+				return nil, storeError(err)
+			}
+			continue
+		}
+
+		before, err := lastEvent(ctx, tx, todo.ID, events[0].Seq)
+		if err != nil {
+			return nil, err
+		}
+		if before == nil {
+			err = tx.Record(ctx, action, StateGone, todo)
+		} else {
+			err = tx.Record(ctx, action, before.State, before.Todo)
+		}
+		if err != nil {
+			return nil, storeError(err)
+		}
+	}
+
+	if action == ActionRedo {
+		events, err = queryEvents(ctx, tx, `SELECT `+eventColumns+` FROM todo_log WHERE batch = ? ORDER BY seq`, events[0].Reverts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	events = slices.DeleteFunc(events, func(event Event) bool { return skipped[event.Todo.ID] })
+	return events, storeError(tx.Commit())
+}
+
+// adoptTodos appends migrate events for the todos, trashed and archived
+// todos the log does not have where they are, and for the logged todos
+// that are in none of them, so the log matches the tables. Such todos
+// come from a database from before the log, or were changed by such a
+// version of kaj since.
+func (s *SQLiteStore) adoptTodos() error {
+	// Checked before taking the write lock, so opening a read-only
+	// database that needs nothing still works.
+	unlogged := `SELECT
+		(SELECT COUNT(*) FROM todo_log AS l WHERE ` + missing + `) +
+		(SELECT COUNT(*) FROM todos AS t WHERE NOT ` + loggedAs("t.id", StateListed) + `) +
+		(SELECT COUNT(*) FROM deleted_todos AS t WHERE NOT ` + loggedAs("t.original_id", StateTrashed) + `) +
+		(SELECT COUNT(*) FROM archived_todos AS t WHERE NOT ` + loggedAs("t.original_id", StateArchived) + `)`
+	var count int
+	if err := s.db.QueryRow(unlogged).Scan(&count); err != nil || count == 0 {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := uniqueOriginalIDs(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'todo_log'), 0) + 1`).Scan(&tx.batch); err != nil {
+		return err
+	}
+
+	// Values are copied as stored, so encrypted ones need no key.
+	insert := `INSERT INTO todo_log (batch, action, at, state, todo_id, ` + TodoColumns + `) `
+	for _, query := range []string{
+		insert + `SELECT ?, 'migrate', datetime(?), 'gone', todo_id, ` + TodoColumns + `
+			FROM todo_log AS l WHERE ` + missing + ` ORDER BY seq`,
+		insert + `SELECT ?, 'migrate', COALESCE(datetime(created_at), datetime(?)), 'listed', id, ` + TodoColumns + `
+			FROM todos AS t WHERE NOT ` + loggedAs("t.id", StateListed) + ` ORDER BY position, id`,
+		insert + `SELECT ?, 'migrate', COALESCE(datetime(deleted_at), datetime(?)), 'trashed', original_id, ` + TodoColumns + `
+			FROM deleted_todos AS t WHERE NOT ` + loggedAs("t.original_id", StateTrashed) + ` ORDER BY id`,
+		insert + `SELECT ?, 'migrate', COALESCE(datetime(archived_at), datetime(?)), 'archived', original_id, ` + TodoColumns + `
+			FROM archived_todos AS t WHERE NOT ` + loggedAs("t.original_id", StateArchived) + ` ORDER BY id`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, tx.batch, tx.at); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// missing is a condition on an event of todo_log: that it is the last
+// event of a todo the tables do not have.
+const missing = `state != 'gone' AND seq = (SELECT MAX(seq) FROM todo_log WHERE todo_id = l.todo_id)
+	AND NOT EXISTS (SELECT 1 FROM todos WHERE id = l.todo_id)
+	AND NOT EXISTS (SELECT 1 FROM deleted_todos WHERE original_id = l.todo_id)
+	AND NOT EXISTS (SELECT 1 FROM archived_todos WHERE original_id = l.todo_id)`
+
+// loggedAs is a condition on a todo ID: that its last event left it in
+// state.
+func loggedAs(id, state string) string {
+	return `EXISTS (SELECT 1 FROM todo_log AS l WHERE l.todo_id = ` + id + ` AND l.state = '` + state + `'
+		AND l.seq = (SELECT MAX(seq) FROM todo_log WHERE todo_id = ` + id + `))`
+}
+
+// uniqueOriginalIDs gives trashed and archived todos whose ID is used by
+// another todo a new one, since the log follows a todo by its ID. Before
+// the log, restoring a backup could bring in such duplicates.
+func uniqueOriginalIDs(ctx context.Context, tx *Tx) error {
+	used := map[int]bool{}
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM todos`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		used[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var next sql.NullInt64
+	query := `SELECT MAX(id) FROM (
+		SELECT MAX(todo_id) AS id FROM todo_log
+		UNION ALL SELECT MAX(id) FROM todos
+		UNION ALL SELECT MAX(original_id) FROM deleted_todos
+		UNION ALL SELECT MAX(original_id) FROM archived_todos)`
+	if err := tx.QueryRowContext(ctx, query).Scan(&next); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"deleted_todos", "archived_todos"} {
+		type row struct{ rowid, id int }
+		var duplicates []row
+		rows, err := tx.QueryContext(ctx, `SELECT id, original_id FROM `+table+` ORDER BY id`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.rowid, &r.id); err != nil {
+				rows.Close()
+				return err
+			}
+			if used[r.id] {
+				duplicates = append(duplicates, r)
+			}
+			used[r.id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, r := range duplicates {
+			next.Int64++
+			if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET original_id = ? WHERE id = ?`, next.Int64, r.rowid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package kaj
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// loggedStore is a Store that keeps the todo log.
+type loggedStore interface {
+	Store
+	Undo(ctx context.Context) ([]Event, error)
+	Redo(ctx context.Context) ([]Event, error)
+	Events(ctx context.Context) ([]Event, error)
+	TodosAt(ctx context.Context, at time.Time) ([]Todo, error)
+}
+
+// testLoggedStores returns the stores of testStores that keep the log.
+func testLoggedStores(t *testing.T) map[string]loggedStore {
+	t.Helper()
+	stores := map[string]loggedStore{}
+	for name, store := range testStores(t) {
+		if logged, ok := store.(loggedStore); ok {
+			stores[name] = logged
+		}
+	}
+	return stores
+}
+
+func TestUndoRedo(t *testing.T) {
+	for name, store := range testLoggedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := t.Context()
+			if _, err := store.Undo(ctx); !errors.Is(err, ErrNothingToUndo) {
+				t.Errorf("undo of an empty log: %v", err)
+			}
+
+			ids := addTodos(t, store, "a", "b")
+			if err := store.UpdateTodo(ctx, ids[0], "a edited"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.DeleteTodo(ctx, ids[1]); err != nil {
+				t.Fatal(err)
+			}
+
+			events, err := store.Undo(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 || events[0].Action != ActionDelete {
+				t.Errorf("undid %+v, want the delete", events)
+			}
+			if got := texts(t, store); !slices.Equal(got, []string{"a edited", "b"}) {
+				t.Errorf("after undoing the delete: %v", got)
+			}
+
+			if _, err := store.Undo(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(t, store); !slices.Equal(got, []string{"a", "b"}) {
+				t.Errorf("after undoing the edit: %v", got)
+			}
+
+			events, err = store.Redo(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 || events[0].Action != ActionEdit {
+				t.Errorf("redid %+v, want the edit", events)
+			}
+			if got := texts(t, store); !slices.Equal(got, []string{"a edited", "b"}) {
+				t.Errorf("after redo: %v", got)
+			}
+
+			// A new change drops what is left to redo.
+			if err := store.ToggleTodo(ctx, ids[0]); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Redo(ctx); !errors.Is(err, ErrNothingToRedo) {
+				t.Errorf("redo after a change: %v", err)
+			}
+		})
+	}
+}
+
+func TestTodosAt(t *testing.T) {
+	for name, store := range testLoggedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := t.Context()
+			ids := addTodos(t, store, "a", "b")
+
+			time.Sleep(10 * time.Millisecond)
+			at := time.Now()
+			time.Sleep(10 * time.Millisecond)
+
+			if err := store.UpdateTodo(ctx, ids[0], "a edited"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.DeleteTodo(ctx, ids[1]); err != nil {
+				t.Fatal(err)
+			}
+			addTodos(t, store, "c")
+
+			todos, err := store.TodosAt(ctx, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, todo := range todos {
+				got = append(got, todo.Text)
+			}
+			if !slices.Equal(got, []string{"a", "b"}) {
+				t.Errorf("todos at %v: %v", at, got)
+			}
+		})
+	}
+}
+
+// TestRebuild replays the log into the tables and expects the same todos,
+// trash and archive as before.
+func TestRebuild(t *testing.T) {
+	ctx := t.Context()
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ids := addTodos(t, store, "a +p", "b", "c", "d")
+	steps := []error{
+		store.UpdateTodo(ctx, ids[0], "a +q"),
+		store.SetStatus(ctx, ids[1], StatusDoing),
+		store.MoveTodoTo(ctx, ids[3], 0),
+		store.DeleteTodo(ctx, ids[2]),
+		store.ArchiveTodos(ctx, []int{ids[1]}),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	todos, err := store.GetTodos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trash, err := store.GetDeletedTodos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := store.Rebuild(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Todos != 2 || report.Trash != 1 || report.Archived != 1 {
+		t.Errorf("rebuild report = %+v", report)
+	}
+
+	rebuilt, err := store.GetTodos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuiltTrash, err := store.GetDeletedTodos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rebuilt) != len(todos) || len(rebuiltTrash) != len(trash) {
+		t.Fatalf("rebuilt %d todos and %d trashed, want %d and %d", len(rebuilt), len(rebuiltTrash), len(todos), len(trash))
+	}
+	for i := range todos {
+		if !sameTodo(todos[i], rebuilt[i]) {
+			t.Errorf("todo %d: rebuilt %+v, want %+v", i, rebuilt[i], todos[i])
+		}
+	}
+	if rebuiltTrash[0].ID != trash[0].ID || rebuiltTrash[0].Text != trash[0].Text {
+		t.Errorf("trash: rebuilt %+v, want %+v", rebuiltTrash[0], trash[0])
+	}
+}
+
+// TestEventsInChangeTransaction checks that a change and its event are
+// written together: a change that fails, or whose transaction is rolled
+// back, leaves neither.
+func TestEventsInChangeTransaction(t *testing.T) {
+	ctx := t.Context()
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ids := addTodos(t, store, "a")
+	before, err := store.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := WithPrecondition(ctx, func(Todo) error { return errors.New("stale") })
+	if err := store.UpdateTodo(failed, ids[0], "changed"); err == nil {
+		t.Fatal("UpdateTodo ignored the precondition")
+	}
+
+	tx, err := Begin(ctx, store.DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Insert(ctx, ActionCreate, NewTodo("rolled back"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := store.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("%d events after failed changes, want %d", len(after), len(before))
+	}
+	if got := texts(t, store); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("todos = %v", got)
+	}
+}
+
+// TestUndoKeepsHandEdits edits a todo in kaj and then in the text file,
+// and expects undo to leave the hand edit alone.
+func TestUndoKeepsHandEdits(t *testing.T) {
+	ctx := t.Context()
+	store, err := OpenText(filepath.Join(t.TempDir(), "todos.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Both todos are edited in one batch.
+	ids := addTodos(t, store, "orig", "other")
+	todos := []Todo{mustGetTodo(t, store, ids[0]), mustGetTodo(t, store, ids[1])}
+	tx, err := Begin(ctx, store.DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, text := range []string{"kaj-edit", "other edited"} {
+		todo := todos[i]
+		todo.Text = text
+		if err := tx.Record(ctx, ActionEdit, StateListed, todo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("kaj-edit"), []byte("hand-edit"), 1)
+	if err := os.WriteFile(store.Path(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := store.Undo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Todo.ID != ids[1] {
+		t.Errorf("undid %+v, want only the edit of the other todo", events)
+	}
+	if got := texts(t, store); !slices.Equal(got, []string{"hand-edit", "other"}) {
+		t.Errorf("after undo: %v", got)
+	}
+
+	// The batch counts as undone, so the next undo reverts the adds.
+	if _, err := store.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := texts(t, store); !slices.Equal(got, []string{"hand-edit"}) {
+		t.Errorf("after undoing the add of other: %v", got)
+	}
+}
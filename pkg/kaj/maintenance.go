@@ -0,0 +1,211 @@
+package kaj
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Path returns the path of the database file.
+func (s *SQLiteStore) Path() string {
+	return s.path
+}
+
+// Backup writes a consistent copy of the database to path, which must
+// not exist, while other processes keep using it. The copy of an
+// encrypted database is encrypted under the same passphrase.
+//
+// The copy is written to a temporary file and linked to path when
+// complete, so path never holds a partial backup, and of two processes
+// backing up to the same path one fails with fs.ErrExist.
+func (s *SQLiteStore) Backup(ctx context.Context, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return &os.PathError{Op: "backup", Path: path, Err: fs.ErrExist}
+	}
+
+	tmp := fmt.Sprintf("%s.%s.tmp", path, newUID())
+	defer os.Remove(tmp)
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, tmp); err != nil {
+		return err
+	}
+	return os.Link(tmp, path)
+}
+
+// Restore replaces the contents of the database with the backup at path,
+// after checking that it is an intact kaj database. Processes using the
+// database see the restored todos on their next read. The store should
+// be closed and reopened afterwards, since the backup may be encrypted
+// differently.
+func (s *SQLiteStore) Restore(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	src := sql.OpenDB(&sealingConnector{dsn: "file:" + path + "?mode=ro", sealer: &sealer{}})
+	defer src.Close()
+
+	problems, err := integrityCheck(ctx, src)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s is damaged: %s", path, problems[0])
+	}
+	var tables int
+	if err := src.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'todos'`).Scan(&tables); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if tables == 0 {
+		return fmt.Errorf("%s is not a kaj database", path)
+	}
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	dstConn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	return dstConn.Raw(func(dst any) error {
+		return srcConn.Raw(func(src any) error {
+			backup, err := sqliteConn(dst).Backup("main", sqliteConn(src), "main")
+			if err != nil {
+				return storeError(err)
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return storeError(err)
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// sqliteConn returns the SQLite connection under a connection of a
+// store.
+func sqliteConn(conn any) *sqlite3.SQLiteConn {
+	return conn.(*sealingConn).Conn.(*sqlite3.SQLiteConn)
+}
+
+// Vacuum rebuilds the database file, returning the space of deleted rows
+// to the file system.
+func (s *SQLiteStore) Vacuum(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `VACUUM`); err != nil {
+		return storeError(err)
+	}
+	// VACUUM goes through the write-ahead log like any change; move it
+	// into the database file so the log does not keep the old size.
+	_, err := s.db.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`)
+	return storeError(err)
+}
+
+// IntegrityCheck returns the problems SQLite finds in the database file,
+// or none when it is intact.
+func (s *SQLiteStore) IntegrityCheck(ctx context.Context) ([]string, error) {
+	return integrityCheck(ctx, s.db)
+}
+
+func integrityCheck(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// Stats describes the database file.
+type Stats struct {
+	Path string
+
+	// Size is the size of the database file and its write-ahead log.
+	Size int64
+
+	// Rows counts the rows of each table, including the tables
+	// applications keep next to the todos.
+	Rows map[string]int
+
+	// Trash is the number of deleted todos and OldestTrash when the
+	// oldest of them was deleted.
+	Trash       int
+	OldestTrash *time.Time
+
+	Encrypted bool
+}
+
+func (s *SQLiteStore) Stats(ctx context.Context) (Stats, error) {
+	stats := Stats{Path: s.path, Rows: map[string]int{}, Encrypted: s.encrypted}
+
+	for _, suffix := range []string{"", "-wal"} {
+		info, err := os.Stat(s.path + suffix)
+		if err == nil {
+			stats.Size += info.Size()
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return stats, err
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return stats, err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return stats, err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	for _, table := range tables {
+		var count int
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM "`+table+`"`).Scan(&count); err != nil {
+			return stats, err
+		}
+		stats.Rows[table] = count
+	}
+
+	// Selected as a column rather than MIN(), which loses the DATETIME
+	// type the driver parses times by.
+	var oldest time.Time
+	err = s.db.QueryRowContext(ctx, `SELECT deleted_at FROM deleted_todos ORDER BY deleted_at LIMIT 1`).Scan(&oldest)
+	if err == nil {
+		stats.OldestTrash = &oldest
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return stats, err
+	}
+	stats.Trash = stats.Rows["deleted_todos"]
+
+	return stats, nil
+}
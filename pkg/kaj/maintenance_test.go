@@ -0,0 +1,89 @@
+package kaj
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	store, err := OpenSQLite(filepath.Join(dir, "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	addTodos(t, store, "a", "b")
+	path := filepath.Join(dir, "backups", "todos.db")
+	if err := store.Backup(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Backup(ctx, path); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("backup over an existing file: %v", err)
+	}
+
+	addTodos(t, store, "c")
+	if err := store.Restore(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := texts(t, store); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("after restore: %v", got)
+	}
+}
+
+// TestRestoreChecksBackup restores files that are not intact kaj
+// databases, and expects the todos to stay as they were.
+func TestRestoreChecksBackup(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	store, err := OpenSQLite(filepath.Join(dir, "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	addTodos(t, store, "a")
+
+	garbage := filepath.Join(dir, "garbage.db")
+	if err := os.WriteFile(garbage, []byte("these are not the todos you are looking for"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	other := filepath.Join(dir, "other.db")
+	db, err := sql.Open("sqlite3", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE notes (text TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// A backup cut off halfway through.
+	damaged := filepath.Join(dir, "damaged.db")
+	addTodos(t, store, "b", "c")
+	if err := store.Backup(ctx, damaged); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(damaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(damaged, info.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{garbage, other, damaged, filepath.Join(dir, "missing.db")} {
+		if err := store.Restore(ctx, path); err == nil {
+			t.Errorf("restored %s", filepath.Base(path))
+		}
+	}
+	if got := texts(t, store); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("todos after failed restores: %v", got)
+	}
+}
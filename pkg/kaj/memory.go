@@ -0,0 +1,214 @@
+package kaj
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps todos in memory only, for tests and
+// tools that work on a throwaway list. It is safe for concurrent use.
+type MemoryStore struct {
+	mu      sync.Mutex
+	todos   []Todo
+	trash   []DeletedTodo
+	nextID  int
+	version int64
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{nextID: 1}
+}
+
+// index returns the position of a todo in s.todos, or -1.
+func (s *MemoryStore) index(id int) int {
+	for i, todo := range s.todos {
+		if todo.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// find returns the position of a todo in s.todos after checking the
+// precondition of ctx against it.
+func (s *MemoryStore) find(ctx context.Context, id int) (int, error) {
+	i := s.index(id)
+	if i < 0 {
+		return -1, notFound(id)
+	}
+	return i, precondition(ctx, s.todos[i])
+}
+
+// changed is called with s.mu held after every change.
+func (s *MemoryStore) changed() {
+	s.version++
+	sortTodos(s.todos)
+}
+
+func (s *MemoryStore) maxPosition() float64 {
+	var max float64
+	for _, todo := range s.todos {
+		if todo.Position > max {
+			max = todo.Position
+		}
+	}
+	return max
+}
+
+func (s *MemoryStore) GetTodos(ctx context.Context) ([]Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Todo(nil), s.todos...), nil
+}
+
+func (s *MemoryStore) GetTodo(ctx context.Context, id int) (*Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.index(id)
+	if i < 0 {
+		return nil, notFound(id)
+	}
+	todo := s.todos[i]
+	return &todo, nil
+}
+
+func (s *MemoryStore) AddTodo(ctx context.Context, todo Todo) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	normalize(&todo)
+	if !ValidStatus(todo.Status) {
+		return 0, fmt.Errorf("invalid status %q", todo.Status)
+	}
+
+	todo.ID = s.nextID
+	todo.Position = s.maxPosition() + 1
+	s.nextID++
+	s.todos = append(s.todos, todo)
+	s.changed()
+	return todo.ID, nil
+}
+
+func (s *MemoryStore) UpdateTodo(ctx context.Context, id int, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.find(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.todos[i].Text = text
+	s.todos[i].Projects, s.todos[i].Contexts = ParseTags(text)
+	s.changed()
+	return nil
+}
+
+func (s *MemoryStore) EditTodo(ctx context.Context, id int, text, status string) error {
+	if status != "" && !ValidStatus(status) {
+		return fmt.Errorf("invalid status %q", status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.find(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if text != "" {
+		s.todos[i].Text = text
+		s.todos[i].Projects, s.todos[i].Contexts = ParseTags(text)
+	}
+	if status != "" {
+		setStatus(&s.todos[i], status)
+	}
+	s.changed()
+	return nil
+}
+
+func (s *MemoryStore) SetStatus(ctx context.Context, id int, status string) error {
+	if !ValidStatus(status) {
+		return fmt.Errorf("invalid status %q", status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.find(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	setStatus(&s.todos[i], status)
+	s.changed()
+	return nil
+}
+
+func (s *MemoryStore) ToggleTodo(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.find(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	status := StatusDone
+	if s.todos[i].Status == StatusDone {
+		status = StatusTodo
+	}
+	setStatus(&s.todos[i], status)
+	s.changed()
+	return nil
+}
+
+func (s *MemoryStore) DeleteTodo(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.find(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.trash = append(s.trash, DeletedTodo{Todo: s.todos[i], DeletedAt: time.Now()})
+	s.todos = append(s.todos[:i], s.todos[i+1:]...)
+	s.changed()
+	return nil
+}
+
+func (s *MemoryStore) UndoLastDelete(ctx context.Context) (*Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.trash) == 0 {
+		return nil, ErrNothingToUndo
+	}
+
+	todo := s.trash[len(s.trash)-1].Todo
+	s.trash = s.trash[:len(s.trash)-1]
+
+	todo.Position = s.maxPosition() + 1
+	s.todos = append(s.todos, todo)
+	s.changed()
+	return &todo, nil
+}
+
+func (s *MemoryStore) DataVersion(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.version, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
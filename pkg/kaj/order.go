@@ -0,0 +1,283 @@
+package kaj
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+)
+
+// Todos are ordered by Position, a fraction. A todo that moves gets a
+// position between those of its new neighbours, so a move changes only
+// the moved todo. When repeated moves into the same gap use up the
+// precision of a float64, the list is renumbered once.
+
+// between returns a position that sorts after before and ahead of after.
+// Either may be nil for the start or the end of the list. ok is false
+// when no float64 is left between them.
+func between(before, after *float64) (position float64, ok bool) {
+	switch {
+	case before == nil && after == nil:
+		return 1, true
+	case before == nil:
+		return *after - 1, true
+	case after == nil:
+		return *before + 1, true
+	}
+
+	position = *before + (*after-*before)/2
+	return position, position > *before && position < *after
+}
+
+// neighbour finds the todo a move swaps places with. current is the
+// zero Todo when id is not in todos.
+func neighbour(todos []Todo, id int, direction int, sameStatus bool) (current, other Todo, ok bool) {
+	currentIndex := -1
+	for i, todo := range todos {
+		if todo.ID == id {
+			currentIndex = i
+			break
+		}
+	}
+	if currentIndex == -1 {
+		return current, other, false
+	}
+
+	current = todos[currentIndex]
+	otherIndex := currentIndex + direction
+	for sameStatus && otherIndex >= 0 && otherIndex < len(todos) && todos[otherIndex].Status != current.Status {
+		otherIndex += direction
+	}
+	if otherIndex < 0 || otherIndex >= len(todos) {
+		return current, other, false
+	}
+
+	return current, todos[otherIndex], true
+}
+
+func (s *SQLiteStore) MoveTodo(ctx context.Context, id int, direction int, sameStatus bool) error {
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	todo, err := getTodo(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := precondition(ctx, *todo); err != nil {
+		return err
+	}
+	position, status := todo.Position, todo.Status
+
+	cmp, order := "<", "DESC"
+	if direction > 0 {
+		cmp, order = ">", "ASC"
+	}
+	query := `SELECT id, position FROM todos WHERE (position ` + cmp + ` ? OR (position = ? AND id ` + cmp + ` ?))`
+	args := []any{position, position, id}
+	if sameStatus {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY position ` + order + `, id ` + order + ` LIMIT 1`
+
+	var otherID int
+	var otherPosition float64
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&otherID, &otherPosition)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Already first or last.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Index of the other todo in the list without the moved one.
+	var index int
+	countQuery := `SELECT COUNT(*) FROM todos WHERE id != ? AND (position < ? OR (position = ? AND id < ?))`
+	if err := tx.QueryRowContext(ctx, countQuery, id, otherPosition, otherPosition, otherID).Scan(&index); err != nil {
+		return err
+	}
+	if direction > 0 {
+		index++
+	}
+
+	if err := moveTo(ctx, tx, id, index); err != nil {
+		return err
+	}
+	return storeError(tx.Commit())
+}
+
+func (s *SQLiteStore) MoveTodoTo(ctx context.Context, id int, index int) error {
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	todo, err := getTodo(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := precondition(ctx, *todo); err != nil {
+		return err
+	}
+
+	if err := moveTo(ctx, tx, id, index); err != nil {
+		return err
+	}
+	return storeError(tx.Commit())
+}
+
+// moveTo gives a todo the position that puts it at index of the list of
+// the other todos.
+func moveTo(ctx context.Context, tx *Tx, id int, index int) error {
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM todos WHERE id != ?`, id).Scan(&count); err != nil {
+		return err
+	}
+	index = max(0, min(index, count))
+
+	for renumbered := false; ; renumbered = true {
+		before, err := positionAt(ctx, tx, id, index-1)
+		if err != nil {
+			return err
+		}
+		after, err := positionAt(ctx, tx, id, index)
+		if err != nil {
+			return err
+		}
+
+		position, ok := between(before, after)
+		if !ok && !renumbered {
+			if err := renumber(ctx, tx); err != nil {
+				return storeError(err)
+			}
+			continue
+		}
+
+		todo, err := getTodo(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		todo.Position = position
+		return storeError(tx.Record(ctx, ActionMove, StateListed, *todo))
+	}
+}
+
+// positionAt returns the position of the todo at index of the list
+// without the todo id, or nil past either end.
+func positionAt(ctx context.Context, tx *Tx, id int, index int) (*float64, error) {
+	if index < 0 {
+		return nil, nil
+	}
+
+	var position float64
+	query := `SELECT position FROM todos WHERE id != ? ORDER BY position, id LIMIT 1 OFFSET ?`
+	err := tx.QueryRowContext(ctx, query, id, index).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &position, nil
+}
+
+// renumber sets the positions of all todos to 1, 2, 3, ... in list order.
+func renumber(ctx context.Context, tx *Tx) error {
+	todos, err := QueryTodos(ctx, tx, `SELECT id, `+TodoColumns+` FROM todos ORDER BY position, id`)
+	if err != nil {
+		return err
+	}
+	for i, todo := range todos {
+		todo.Position = float64(i + 1)
+		if err := tx.Record(ctx, ActionMove, StateListed, todo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) MoveTodo(ctx context.Context, id int, direction int, sameStatus bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.find(ctx, id); err != nil {
+		return err
+	}
+	current, other, ok := neighbour(s.todos, id, direction, sameStatus)
+	if !ok {
+		return nil
+	}
+
+	index := s.index(other.ID)
+	if s.index(current.ID) < index {
+		index--
+	}
+	if direction > 0 {
+		index++
+	}
+	s.moveTo(id, index)
+	return nil
+}
+
+func (s *MemoryStore) MoveTodoTo(ctx context.Context, id int, index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.find(ctx, id); err != nil {
+		return err
+	}
+	s.moveTo(id, index)
+	return nil
+}
+
+// moveTo puts a todo at index of the list of the other todos, like the
+// SQLite moveTo.
+func (s *MemoryStore) moveTo(id int, index int) {
+	var others []*Todo
+	var moved *Todo
+	for i := range s.todos {
+		if s.todos[i].ID == id {
+			moved = &s.todos[i]
+		} else {
+			others = append(others, &s.todos[i])
+		}
+	}
+	index = max(0, min(index, len(others)))
+
+	for renumbered := false; ; renumbered = true {
+		var before, after *float64
+		if index > 0 {
+			before = &others[index-1].Position
+		}
+		if index < len(others) {
+			after = &others[index].Position
+		}
+
+		position, ok := between(before, after)
+		if !ok && !renumbered {
+			for i := range s.todos {
+				s.todos[i].Position = float64(i + 1)
+			}
+			continue
+		}
+
+		moved.Position = position
+		s.changed()
+		return
+	}
+}
+
+// sortTodos puts todos in list order.
+func sortTodos(todos []Todo) {
+	sort.SliceStable(todos, func(i, j int) bool {
+		if todos[i].Position != todos[j].Position {
+			return todos[i].Position < todos[j].Position
+		}
+		return todos[i].ID < todos[j].ID
+	})
+}
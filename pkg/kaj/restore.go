@@ -0,0 +1,214 @@
+package kaj
+
+import (
+	"context"
+	"fmt"
+)
+
+// Modes of RestoreTodos.
+const (
+	// RestoreMerge appends the todos that are not duplicates of existing
+	// ones, with new IDs.
+	RestoreMerge = "merge"
+
+	// RestoreReplace replaces the todos and the trash wholesale.
+	RestoreReplace = "replace"
+)
+
+// RestoreResult describes what a restore changed, or would change for a
+// dry run.
+type RestoreResult struct {
+	Added          int
+	Duplicates     int
+	Removed        int
+	TrashAdded     int
+	TrashRemoved   int
+	TrashDuplicate int
+}
+
+// isDuplicate reports whether two todos are the same item: same text,
+// and the same creation time when both know it.
+func isDuplicate(a, b Todo) bool {
+	if a.Text != b.Text {
+		return false
+	}
+	if a.CreatedAt != nil && b.CreatedAt != nil {
+		return a.CreatedAt.Equal(*b.CreatedAt)
+	}
+	return true
+}
+
+// RestoreTodos loads a list and trash saved elsewhere, such as a JSON
+// backup, in one transaction. In RestoreReplace mode the todos and trash
+// are replaced wholesale, keeping the IDs and positions of todos where no
+// archived todo has them. In RestoreMerge mode todos that are not
+// duplicates of existing ones are appended with new IDs. With dryRun the
+// transaction is rolled back and only the result is reported.
+func (s *SQLiteStore) RestoreTodos(ctx context.Context, todos []Todo, trash []DeletedTodo, mode string, dryRun bool) (*RestoreResult, error) {
+	if mode != RestoreMerge && mode != RestoreReplace {
+		return nil, fmt.Errorf("invalid import mode %q (want merge or replace)", mode)
+	}
+
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	existing, err := QueryTodos(ctx, tx, `SELECT id, `+TodoColumns+` FROM todos ORDER BY position ASC`)
+	if err != nil {
+		return nil, err
+	}
+	existingTrash, err := QueryDeletedTodos(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{}
+
+	// IDs the restored todos cannot keep.
+	taken := map[int]bool{}
+
+	if mode == RestoreReplace {
+		// Todos being restored are replaced below.
+		restored := map[int]bool{}
+		for _, todo := range todos {
+			restored[todo.ID] = true
+		}
+		for _, deleted := range trash {
+			restored[deleted.ID] = true
+		}
+		removed := existing
+		for _, deleted := range existingTrash {
+			removed = append(removed, deleted.Todo)
+		}
+		for _, todo := range removed {
+			if restored[todo.ID] {
+				continue
+			}
+			if err := tx.Record(ctx, ActionImport, StateGone, todo); err != nil {
+				return nil, storeError(err)
+			}
+		}
+		result.Removed = len(existing)
+		result.TrashRemoved = len(existingTrash)
+
+		rows, err := tx.QueryContext(ctx, `SELECT original_id FROM archived_todos`)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			taken[id] = true
+		}
+		rows.Close()
+
+		for _, todo := range todos {
+			normalize(&todo)
+			if taken[todo.ID] {
+				if _, err := tx.Insert(ctx, ActionImport, todo, todo.Position); err != nil {
+					return nil, storeError(err)
+				}
+			} else if err := tx.Record(ctx, ActionImport, StateListed, todo); err != nil {
+				return nil, storeError(err)
+			}
+			taken[todo.ID] = true
+			result.Added++
+		}
+
+		existingTrash = nil
+	} else {
+		maxPosition, err := getMaxPosition(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Restored IDs are mapped to the IDs they get here. Todos whose
+		// parent comes later in the list get it in a second pass.
+		newIDs := map[int]int{}
+		var orphans []Todo
+		for _, todo := range todos {
+			duplicate := -1
+			for _, other := range existing {
+				if isDuplicate(todo, other) {
+					duplicate = other.ID
+					break
+				}
+			}
+			if duplicate != -1 {
+				newIDs[todo.ID] = duplicate
+				result.Duplicates++
+				continue
+			}
+
+			parent := todo.ParentID
+			todo.ParentID = newIDs[parent]
+			maxPosition++
+			id, err := tx.Insert(ctx, ActionImport, todo, maxPosition)
+			if err != nil {
+				return nil, storeError(err)
+			}
+			newIDs[todo.ID] = id
+			existing = append(existing, todo)
+			result.Added++
+
+			if parent != 0 && todo.ParentID == 0 {
+				orphans = append(orphans, Todo{ID: id, ParentID: parent})
+			}
+		}
+
+		for _, orphan := range orphans {
+			parent, ok := newIDs[orphan.ParentID]
+			if !ok {
+				continue
+			}
+			todo, err := getTodo(ctx, tx, orphan.ID)
+			if err != nil {
+				return nil, err
+			}
+			todo.ParentID = parent
+			if err := tx.Record(ctx, ActionImport, StateListed, *todo); err != nil {
+				return nil, storeError(err)
+			}
+		}
+	}
+
+	for _, deleted := range trash {
+		duplicate := false
+		for _, other := range existingTrash {
+			if isDuplicate(deleted.Todo, other.Todo) && deleted.DeletedAt.Equal(other.DeletedAt) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			result.TrashDuplicate++
+			continue
+		}
+
+		if deleted.Status == "" {
+			deleted.Status = StatusTodo
+		}
+		// In merge mode every restored todo gets a new ID.
+		if mode == RestoreMerge || taken[deleted.ID] {
+			if deleted.ID, err = tx.NewID(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if err := tx.Record(ctx, ActionImport, StateTrashed, deleted.Todo); err != nil {
+			return nil, storeError(err)
+		}
+		taken[deleted.ID] = true
+		result.TrashAdded++
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	return result, storeError(tx.Commit())
+}
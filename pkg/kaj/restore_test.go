@@ -0,0 +1,107 @@
+package kaj
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRestoreTodos(t *testing.T) {
+	ctx := t.Context()
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ids := addTodos(t, store, "a", "b")
+	saved, err := store.GetTodos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trash := []DeletedTodo{{Todo: Todo{ID: 50, Text: "old"}, DeletedAt: time.Now()}}
+
+	result, err := store.RestoreTodos(ctx, saved, trash, RestoreMerge, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 0 || result.Duplicates != 2 || result.TrashAdded != 1 {
+		t.Errorf("merge = %+v", result)
+	}
+
+	if err := store.UpdateTodo(ctx, ids[0], "a edited"); err != nil {
+		t.Fatal(err)
+	}
+	addTodos(t, store, "c")
+
+	result, err = store.RestoreTodos(ctx, saved, nil, RestoreReplace, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Removed != 3 || result.Added != 2 {
+		t.Errorf("replace dry run = %+v", result)
+	}
+	if got := texts(t, store); !slices.Equal(got, []string{"a edited", "b", "c"}) {
+		t.Errorf("dry run changed the todos: %v", got)
+	}
+
+	if _, err := store.RestoreTodos(ctx, saved, nil, RestoreReplace, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := texts(t, store); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("after replace: %v", got)
+	}
+	if todo := mustGetTodo(t, store, ids[0]); todo.Text != "a" {
+		t.Errorf("todo %d = %q, want its ID kept", ids[0], todo.Text)
+	}
+	deleted, err := store.GetDeletedTodos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("trash after replace: %v", deleted)
+	}
+
+	if _, err := store.RestoreTodos(ctx, nil, nil, "append", false); err == nil {
+		t.Error("accepted an invalid mode")
+	}
+}
+
+// TestRestoreTodosParents merges subtasks listed before their parents,
+// and expects them under the parents' new IDs.
+func TestRestoreTodosParents(t *testing.T) {
+	ctx := t.Context()
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	addTodos(t, store, "x", "y")
+
+	todos := []Todo{
+		{ID: 3, Text: "child", ParentID: 1},
+		{ID: 1, Text: "parent"},
+		{ID: 2, Text: "y"},
+		{ID: 4, Text: "under y", ParentID: 2},
+		{ID: 5, Text: "lost", ParentID: 9},
+	}
+	if _, err := store.RestoreTodos(ctx, todos, nil, RestoreMerge, false); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetTodos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]int{}
+	for _, todo := range got {
+		ids[todo.Text] = todo.ID
+	}
+	parents := map[string]string{"child": "parent", "under y": "y", "lost": "", "parent": ""}
+	for _, todo := range got {
+		if want, ok := parents[todo.Text]; ok && todo.ParentID != ids[want] {
+			t.Errorf("%q has parent %d, want %d (%q)", todo.Text, todo.ParentID, ids[want], want)
+		}
+	}
+}
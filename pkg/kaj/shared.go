@@ -0,0 +1,681 @@
+package kaj
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A shared list is kept as operation logs, one per author, in the ops
+// directory of a project's .todos:
+//
+//	{"clock":7,"author":"ada@example.com","id":"3f9c0e1a7b2d4c5e","op":"set","todo":"b41d2e6f0a9c8d7e","fields":{"status":"done"},"time":"..."}
+//
+// An author only ever appends to their own log, and .todos/.gitattributes
+// merges logs with git's union driver, so pulling never conflicts, not
+// even for one author working in two clones.
+//
+// Replaying all logs in order of (clock, author, id) gives the same list
+// in every clone that has the same logs. clock is a Lamport clock: an
+// operation gets one more than the highest clock in the logs when it is
+// recorded, so it replays after everything its author had seen. Of two
+// concurrent changes to the same field of a todo the one replayed last
+// wins; changes to different fields or todos all apply, and a todo
+// deleted in any clone stays deleted.
+//
+// Todos are named in the logs by a random uid; their IDs are local to a
+// clone.
+
+// sharedLogDir is the directory of the operation logs within .todos.
+const sharedLogDir = "ops"
+
+// sharedAttributes makes git merge the logs by keeping the lines of both
+// sides.
+const sharedAttributes = sharedLogDir + "/*.jsonl merge=union\n"
+
+// Operations of the logs.
+const (
+	opAdd    = "add"
+	opSet    = "set"
+	opDelete = "delete"
+)
+
+// sharedOp is one line of an operation log. Fields holds JSON values by
+// field name; null clears a field.
+type sharedOp struct {
+	Clock  int64                      `json:"clock"`
+	Author string                     `json:"author"`
+	ID     string                     `json:"id"`
+	Op     string                     `json:"op"`
+	Todo   string                     `json:"todo"`
+	Fields map[string]json.RawMessage `json:"fields,omitempty"`
+	Time   time.Time                  `json:"time"`
+}
+
+// sharedTodo holds the fields of a todo recorded in the logs. Parent is
+// the uid of the parent.
+type sharedTodo struct {
+	Text        string            `json:"text"`
+	Status      string            `json:"status"`
+	Position    float64           `json:"position"`
+	Priority    string            `json:"priority,omitempty"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+	Due         *time.Time        `json:"due,omitempty"`
+	Extensions  map[string]string `json:"extensions,omitempty"`
+	Parent      string            `json:"parent,omitempty"`
+	Notes       string            `json:"notes,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	SourceRef   string            `json:"source_ref,omitempty"`
+	Branch      string            `json:"branch,omitempty"`
+}
+
+// SharedStore is a Store kept in per-author operation logs that are
+// committed with a project, normally in .todos/ops.
+//
+// Like TextStore it works on a SQLite cache, synced before and after
+// every change: changes in the cache since the last sync are appended to
+// the author's log, and logs changed by a pull are replayed into the
+// cache.
+type SharedStore struct {
+	syncedStore
+	dir    string
+	author string
+}
+
+var _ Store = (*SharedStore)(nil)
+
+// SyncReport describes a replay of the logs.
+type SyncReport struct {
+	// Recorded is the number of local changes appended to the author's
+	// log.
+	Recorded int
+
+	// Operations and Authors count the operations replayed and the logs
+	// they came from.
+	Operations int
+	Authors    int
+}
+
+// OpenShared opens the shared list of the .todos directory dir, creating
+// its logs directory when it does not exist, and records changes as
+// author, usually a git user.email.
+func OpenShared(dir string, author string) (*SharedStore, error) {
+	if author == "" {
+		return nil, errors.New("a shared list needs an author")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, sharedLogDir), 0755); err != nil {
+		return nil, err
+	}
+	attributes := filepath.Join(dir, ".gitattributes")
+	if _, err := os.Stat(attributes); os.IsNotExist(err) {
+		if err := os.WriteFile(attributes, []byte(sharedAttributes), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	cache, err := OpenSQLite(filepath.Join(dir, cacheName))
+	if err != nil {
+		return nil, err
+	}
+
+	// shared_todos maps the uids of the logs to local IDs and keeps the
+	// fields of each todo as of the last sync, to tell what changed
+	// locally since.
+	_, err = cache.db.Exec(`CREATE TABLE IF NOT EXISTS shared_todos (
+		uid TEXT PRIMARY KEY,
+		todo_id INTEGER NOT NULL,
+		fields TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS shared_sync (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		fingerprint TEXT NOT NULL
+	)`)
+	if err != nil {
+		cache.Close()
+		return nil, storeError(err)
+	}
+
+	store := &SharedStore{dir: dir, author: author}
+	store.syncedStore = syncedStore{SQLiteStore: cache, sync: store.Sync}
+	if err := store.Sync(context.Background()); err != nil {
+		cache.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Path returns the directory of the operation logs.
+func (s *SharedStore) Path() string {
+	return filepath.Join(s.dir, sharedLogDir)
+}
+
+// Author returns the name changes are recorded under.
+func (s *SharedStore) Author() string {
+	return s.author
+}
+
+// Sync records local changes and replays the logs when they changed.
+func (s *SharedStore) Sync(ctx context.Context) error {
+	_, err := s.sync(ctx, false)
+	return err
+}
+
+// Replay records local changes and rebuilds the todos in the cache from
+// all logs, whether or not they changed.
+func (s *SharedStore) Replay(ctx context.Context) (SyncReport, error) {
+	return s.sync(ctx, true)
+}
+
+func (s *SharedStore) sync(ctx context.Context, force bool) (SyncReport, error) {
+	var report SyncReport
+
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	base, err := loadSharedBase(ctx, tx)
+	if err != nil {
+		return report, err
+	}
+	todos, err := QueryTodos(ctx, tx, `SELECT id, `+TodoColumns+` FROM todos ORDER BY position, id`)
+	if err != nil {
+		return report, err
+	}
+	local := diffShared(todos, base)
+
+	fingerprint, err := s.fingerprint()
+	if err != nil {
+		return report, err
+	}
+	var synced string
+	err = tx.QueryRowContext(ctx, `SELECT fingerprint FROM shared_sync WHERE id = 1`).Scan(&synced)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return report, err
+	}
+	if len(local) == 0 && fingerprint == synced && !force {
+		return report, nil
+	}
+
+	ops, err := s.readLogs()
+	if err != nil {
+		return report, err
+	}
+
+	if len(local) > 0 {
+		var clock int64
+		for _, op := range ops {
+			clock = max(clock, op.Clock)
+		}
+		now := time.Now().UTC()
+		for i := range local {
+			local[i].Clock = clock + 1
+			local[i].Author = s.author
+			local[i].ID = newUID()
+			local[i].Time = now
+		}
+		if err := s.appendLog(local); err != nil {
+			return report, err
+		}
+		ops = append(ops, local...)
+
+		if fingerprint, err = s.fingerprint(); err != nil {
+			return report, err
+		}
+	}
+
+	state := replayShared(ops)
+	spreadTies(state)
+	if err := materializeShared(ctx, tx, state, base); err != nil {
+		return report, storeError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT OR REPLACE INTO shared_sync (id, fingerprint) VALUES (1, ?)`, fingerprint)
+	if err != nil {
+		return report, storeError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return report, storeError(err)
+	}
+
+	authors := map[string]bool{}
+	for _, op := range ops {
+		authors[op.Author] = true
+	}
+	report.Recorded = len(local)
+	report.Operations = len(ops)
+	report.Authors = len(authors)
+	return report, nil
+}
+
+// sharedRow is a todo as of the last sync.
+type sharedRow struct {
+	todoID int
+	fields map[string]json.RawMessage
+}
+
+func loadSharedBase(ctx context.Context, tx *Tx) (map[string]sharedRow, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT uid, todo_id, fields FROM shared_todos`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	base := map[string]sharedRow{}
+	for rows.Next() {
+		var uid, fields string
+		var row sharedRow
+		if err := rows.Scan(&uid, &row.todoID, &fields); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(fields), &row.fields); err != nil {
+			return nil, fmt.Errorf("invalid shared fields for todo %d: %v", row.todoID, err)
+		}
+		base[uid] = row
+	}
+	return base, rows.Err()
+}
+
+// diffShared returns the operations that turn the todos of the last sync
+// into todos, without clock, author and id. Todos added since get a uid,
+// under which they are added to base without fields.
+func diffShared(todos []Todo, base map[string]sharedRow) []sharedOp {
+	uids := map[int]string{}
+	for uid, row := range base {
+		uids[row.todoID] = uid
+	}
+
+	var ops []sharedOp
+	var added []int
+	for i, todo := range todos {
+		if _, ok := uids[todo.ID]; !ok {
+			uids[todo.ID] = newUID()
+			added = append(added, i)
+		}
+	}
+	for _, i := range added {
+		todo := todos[i]
+		ops = append(ops, sharedOp{Op: opAdd, Todo: uids[todo.ID], Fields: sharedFields(todo, uids[todo.ParentID])})
+	}
+
+	present := map[string]bool{}
+	for _, todo := range todos {
+		uid := uids[todo.ID]
+		present[uid] = true
+		row, ok := base[uid]
+		if !ok {
+			continue
+		}
+
+		changed := map[string]json.RawMessage{}
+		fields := sharedFields(todo, uids[todo.ParentID])
+		for key, value := range fields {
+			if !bytes.Equal(row.fields[key], value) {
+				changed[key] = value
+			}
+		}
+		for key := range row.fields {
+			if _, ok := fields[key]; !ok {
+				changed[key] = json.RawMessage("null")
+			}
+		}
+		if len(changed) > 0 {
+			ops = append(ops, sharedOp{Op: opSet, Todo: uid, Fields: changed})
+		}
+	}
+
+	var deleted []string
+	for uid := range base {
+		if !present[uid] {
+			deleted = append(deleted, uid)
+		}
+	}
+	sort.Strings(deleted)
+	for _, uid := range deleted {
+		ops = append(ops, sharedOp{Op: opDelete, Todo: uid})
+	}
+
+	for _, i := range added {
+		base[uids[todos[i].ID]] = sharedRow{todoID: todos[i].ID}
+	}
+	return ops
+}
+
+// replayShared applies operations in log order and returns the fields of
+// each todo by uid.
+func replayShared(ops []sharedOp) map[string]map[string]json.RawMessage {
+	sort.Slice(ops, func(i, j int) bool {
+		a, b := ops[i], ops[j]
+		if a.Clock != b.Clock {
+			return a.Clock < b.Clock
+		}
+		if a.Author != b.Author {
+			return a.Author < b.Author
+		}
+		return a.ID < b.ID
+	})
+
+	state := map[string]map[string]json.RawMessage{}
+	deleted := map[string]bool{}
+	for _, op := range ops {
+		switch op.Op {
+		case opAdd:
+			if deleted[op.Todo] || state[op.Todo] != nil {
+				continue
+			}
+			fields := map[string]json.RawMessage{}
+			for key, value := range op.Fields {
+				fields[key] = value
+			}
+			state[op.Todo] = fields
+		case opSet:
+			fields := state[op.Todo]
+			if fields == nil {
+				continue
+			}
+			for key, value := range op.Fields {
+				if string(value) == "null" {
+					delete(fields, key)
+				} else {
+					fields[key] = value
+				}
+			}
+		case opDelete:
+			delete(state, op.Todo)
+			deleted[op.Todo] = true
+		}
+	}
+	return state
+}
+
+// spreadTies gives todos that ended up at the same position, such as
+// todos added concurrently in two clones, positions between that one and
+// the next, in order of uid. Every clone spreads them the same way, so
+// this is not recorded.
+func spreadTies(state map[string]map[string]json.RawMessage) {
+	uids, positions := sortedShared(state)
+
+	for i := 0; i < len(uids); {
+		j := i + 1
+		for j < len(uids) && positions[j] == positions[i] {
+			j++
+		}
+		if j-i > 1 {
+			next := positions[i] + 1
+			if j < len(uids) {
+				next = positions[j]
+			}
+			step := (next - positions[i]) / float64(j-i)
+			for k := i + 1; k < j; k++ {
+				data, _ := json.Marshal(positions[i] + step*float64(k-i))
+				state[uids[k]]["position"] = data
+			}
+		}
+		i = j
+	}
+}
+
+// sortedShared returns the uids of state in list order, with their
+// positions.
+func sortedShared(state map[string]map[string]json.RawMessage) ([]string, []float64) {
+	uids := make([]string, 0, len(state))
+	position := map[string]float64{}
+	for uid, fields := range state {
+		uids = append(uids, uid)
+		var p float64
+		json.Unmarshal(fields["position"], &p)
+		position[uid] = p
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		if position[uids[i]] != position[uids[j]] {
+			return position[uids[i]] < position[uids[j]]
+		}
+		return uids[i] < uids[j]
+	})
+
+	positions := make([]float64, len(uids))
+	for i, uid := range uids {
+		positions[i] = position[uid]
+	}
+	return uids, positions
+}
+
+// materializeShared replaces the todos in the cache with state, keeping
+// the local IDs of todos it already had, and remembers state as the last
+// sync.
+func materializeShared(ctx context.Context, tx *Tx, state map[string]map[string]json.RawMessage, base map[string]sharedRow) error {
+	uids, _ := sortedShared(state)
+
+	ids := map[string]int{}
+	todos := map[string]Todo{}
+	parents := map[string]string{}
+	for _, uid := range uids {
+		todo, parent := sharedTodoOf(state[uid])
+		todos[uid], parents[uid] = todo, parent
+
+		if row, ok := base[uid]; ok {
+			ids[uid] = row.todoID
+			continue
+		}
+		id, err := tx.Insert(ctx, ActionSync, todo, todo.Position)
+		if err != nil {
+			return err
+		}
+		ids[uid] = id
+	}
+
+	// A todo deleted here is already in the trash, and stays there.
+	for uid, row := range base {
+		if _, ok := state[uid]; ok {
+			continue
+		}
+		todo, err := getTodo(ctx, tx, row.todoID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Record(ctx, ActionSync, StateGone, *todo); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM shared_todos`); err != nil {
+		return err
+	}
+
+	for _, uid := range uids {
+		todo, parent := todos[uid], parents[uid]
+		if _, ok := ids[parent]; !ok {
+			parent = ""
+		}
+		todo.ID, todo.ParentID = ids[uid], ids[parent]
+
+		if err := tx.Record(ctx, ActionSync, StateListed, todo); err != nil {
+			return err
+		}
+
+		fields, _ := json.Marshal(sharedFields(todo, parent))
+		_, err := tx.ExecContext(ctx, `INSERT INTO shared_todos (uid, todo_id, fields) VALUES (?, ?, ?)`, uid, ids[uid], string(fields))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sharedFields returns the fields of todo as recorded in the logs.
+func sharedFields(todo Todo, parent string) map[string]json.RawMessage {
+	utc := func(t *time.Time) *time.Time {
+		if t == nil {
+			return nil
+		}
+		u := t.UTC()
+		return &u
+	}
+
+	data, _ := json.Marshal(sharedTodo{
+		Text:        todo.Text,
+		Status:      todo.Status,
+		Position:    todo.Position,
+		Priority:    todo.Priority,
+		CreatedAt:   utc(todo.CreatedAt),
+		CompletedAt: utc(todo.CompletedAt),
+		Due:         utc(todo.Due),
+		Extensions:  todo.Extensions,
+		Parent:      parent,
+		Notes:       todo.Notes,
+		Source:      todo.Source,
+		SourceRef:   todo.SourceRef,
+		Branch:      todo.Branch,
+	})
+
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// sharedTodoOf is the inverse of sharedFields.
+func sharedTodoOf(fields map[string]json.RawMessage) (Todo, string) {
+	data, _ := json.Marshal(fields)
+	var shared sharedTodo
+	json.Unmarshal(data, &shared)
+
+	todo := Todo{
+		Text:        shared.Text,
+		Status:      shared.Status,
+		Position:    shared.Position,
+		Priority:    shared.Priority,
+		CreatedAt:   shared.CreatedAt,
+		CompletedAt: shared.CompletedAt,
+		Due:         shared.Due,
+		Extensions:  shared.Extensions,
+		Notes:       shared.Notes,
+		Source:      shared.Source,
+		SourceRef:   shared.SourceRef,
+		Branch:      shared.Branch,
+	}
+	normalize(&todo)
+	return todo, shared.Parent
+}
+
+// logName returns the file name of an author's log.
+func logName(author string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-', r == '@':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, author)
+	return name + ".jsonl"
+}
+
+func (s *SharedStore) appendLog(ops []sharedOp) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, op := range ops {
+		if err := encoder.Encode(op); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(s.Path(), logName(s.author)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readLogs returns the operations of all logs. An operation found twice,
+// as a union merge can leave it, counts once.
+func (s *SharedStore) readLogs() ([]sharedOp, error) {
+	paths, err := filepath.Glob(filepath.Join(s.Path(), "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []sharedOp
+	seen := map[string]bool{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		lineNumber := 0
+		for scanner.Scan() {
+			lineNumber++
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var op sharedOp
+			if err := json.Unmarshal(line, &op); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+			}
+			if seen[op.ID] {
+				continue
+			}
+			seen[op.ID] = true
+			ops = append(ops, op)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return ops, nil
+}
+
+// fingerprint changes whenever a log is added, removed or written.
+func (s *SharedStore) fingerprint() (string, error) {
+	entries, err := os.ReadDir(s.Path())
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".jsonl" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "%s %d %d\n", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return hashText(buf.Bytes()), nil
+}
+
+// newUID returns a random ID for a todo or an operation of the logs.
+func newUID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
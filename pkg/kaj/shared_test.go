@@ -0,0 +1,87 @@
+package kaj
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// pull copies the operation logs of one clone's shared list into
+// another's, as git pull does with the union merge driver.
+func pull(t *testing.T, from, to *SharedStore) {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(from.Path(), "*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(to.Path(), filepath.Base(path)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSharedMerge(t *testing.T) {
+	ctx := t.Context()
+	ada, err := OpenShared(filepath.Join(t.TempDir(), ".todos"), "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ada.Close()
+	bob, err := OpenShared(filepath.Join(t.TempDir(), ".todos"), "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+
+	addTodos(t, ada, "plan release", "write changelog")
+	pull(t, ada, bob)
+	if got := texts(t, bob); !slices.Equal(got, []string{"plan release", "write changelog"}) {
+		t.Fatalf("bob after pull: %v", got)
+	}
+
+	// Concurrent changes to different fields of one todo both apply, and
+	// a todo deleted in one clone stays deleted.
+	adaTodos, err := ada.GetTodos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobTodos, err := bob.GetTodos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ada.UpdateTodo(ctx, adaTodos[0].ID, "plan the release"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.SetStatus(ctx, bobTodos[0].ID, StatusDone); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.DeleteTodo(ctx, bobTodos[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	addTodos(t, bob, "tag v1")
+
+	pull(t, ada, bob)
+	pull(t, bob, ada)
+
+	for name, store := range map[string]*SharedStore{"ada": ada, "bob": bob} {
+		todos, err := store.GetTodos(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(todos) != 2 {
+			t.Fatalf("%s has %d todos, want 2", name, len(todos))
+		}
+		if todos[0].Text != "plan the release" || todos[0].Status != StatusDone {
+			t.Errorf("%s: first todo %q %s, want both changes", name, todos[0].Text, todos[0].Status)
+		}
+		if todos[1].Text != "tag v1" {
+			t.Errorf("%s: second todo %q", name, todos[1].Text)
+		}
+	}
+}
@@ -0,0 +1,601 @@
+package kaj
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SQLiteStore is the Store kept in a SQLite file, normally
+// ~/.todos/todos.db or the .todos/todos.db of a project.
+type SQLiteStore struct {
+	db   *sql.DB
+	path string
+
+	// watchConn is pinned for DataVersion, since PRAGMA data_version is
+	// only meaningful when read repeatedly on the same connection.
+	watchConn *sql.Conn
+
+	sealer    *sealer
+	encrypted bool
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// sqliteOptions let several kaj processes share one database file: WAL
+// lets readers work while another process writes, a writer waits up to
+// five seconds for the lock instead of failing with "database is
+// locked", and transactions take the write lock when they begin, so a
+// read-modify-write transaction cannot lose the lock to another writer
+// halfway through.
+const sqliteOptions = "_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+// OpenSQLite opens or creates the database at path and brings its
+// schema up to date. It fails with ErrEncrypted for an encrypted
+// database.
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	return OpenSQLiteWithKey(path, nil)
+}
+
+// OpenSQLiteWithKey is OpenSQLite for a database that may be encrypted.
+// key is only called for an encrypted database.
+func OpenSQLiteWithKey(path string, key KeyFunc) (*SQLiteStore, error) {
+	os.MkdirAll(filepath.Dir(path), 0755)
+
+	store := &SQLiteStore{path: path, sealer: &sealer{}}
+	db := sql.OpenDB(&sealingConnector{dsn: path + "?" + sqliteOptions, sealer: store.sealer})
+	store.db = db
+
+	if err := store.createTables(); err != nil {
+		db.Close()
+		return nil, storeError(err)
+	}
+	if err := store.unlock(context.Background(), key); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// DB returns the underlying database, for tables the application keeps
+// next to the todos.
+func (s *SQLiteStore) DB() *sql.DB {
+	return s.db
+}
+
+// TodoColumns lists the stored columns of a todo, shared by the todos,
+// deleted_todos and archived_todos tables, in the order used by ScanTodo
+// and TodoArgs.
+const TodoColumns = `text, done, position, status, priority, created_at, completed_at, due, projects, contexts, extensions, parent_id, notes, source, source_ref, branch`
+
+// TodoPlaceholders matches TodoColumns.
+const TodoPlaceholders = `?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?`
+
+type RowScanner interface {
+	Scan(dest ...any) error
+}
+
+// ScanTodo reads a row selected as "id, " + TodoColumns, followed by any
+// extra columns, which are scanned into extra.
+func ScanTodo(row RowScanner, extra ...any) (Todo, error) {
+	var todo Todo
+	var createdAt, completedAt, due sql.NullTime
+	var projects, contexts, extensions string
+
+	dest := []any{&todo.ID, &todo.Text, &todo.Done, &todo.Position, &todo.Status, &todo.Priority,
+		&createdAt, &completedAt, &due, &projects, &contexts, &extensions, &todo.ParentID, &todo.Notes, &todo.Source, &todo.SourceRef, &todo.Branch}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return todo, err
+	}
+
+	todo.CreatedAt = timePtr(createdAt)
+	todo.CompletedAt = timePtr(completedAt)
+	todo.Due = timePtr(due)
+	todo.Projects = strings.Fields(projects)
+	todo.Contexts = strings.Fields(contexts)
+	if extensions != "" {
+		if err := json.Unmarshal([]byte(extensions), &todo.Extensions); err != nil {
+			return todo, fmt.Errorf("invalid extensions for todo %d: %v", todo.ID, err)
+		}
+	}
+
+	return todo, nil
+}
+
+// TodoArgs returns the arguments matching TodoColumns.
+func TodoArgs(todo Todo) []any {
+	projects, contexts := ParseTags(todo.Text)
+
+	extensions := ""
+	if len(todo.Extensions) > 0 {
+		data, _ := json.Marshal(todo.Extensions)
+		extensions = string(data)
+	}
+
+	return []any{Secret(todo.Text), todo.Status == StatusDone, todo.Position, todo.Status, todo.Priority,
+		nullTime(todo.CreatedAt), nullTime(todo.CompletedAt), nullTime(todo.Due),
+		Secret(strings.Join(projects, " ")), Secret(strings.Join(contexts, " ")), extensions, todo.ParentID, Secret(todo.Notes), todo.Source, todo.SourceRef, todo.Branch}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+// Execer and Queryer are satisfied by both *sql.DB and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func QueryTodos(ctx context.Context, db Queryer, query string, args ...any) ([]Todo, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var todos []Todo
+	for rows.Next() {
+		todo, err := ScanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+
+	return todos, rows.Err()
+}
+
+// QueryDeletedTodos returns the whole trash, oldest first.
+func QueryDeletedTodos(ctx context.Context, db Queryer) ([]DeletedTodo, error) {
+	query := `SELECT original_id, ` + TodoColumns + `, deleted_at FROM deleted_todos ORDER BY deleted_at ASC, id ASC`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deleted []DeletedTodo
+	for rows.Next() {
+		var deletedAt time.Time
+		todo, err := ScanTodo(rows, &deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, DeletedTodo{Todo: todo, DeletedAt: deletedAt})
+	}
+
+	return deleted, rows.Err()
+}
+
+func (s *SQLiteStore) createTables() error {
+	todosQuery := `
+	CREATE TABLE IF NOT EXISTS todos (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		text TEXT NOT NULL,
+		done BOOLEAN DEFAULT FALSE,
+		position REAL DEFAULT 0
+	);`
+
+	deletedQuery := `
+	CREATE TABLE IF NOT EXISTS deleted_todos (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		original_id INTEGER NOT NULL,
+		text TEXT NOT NULL,
+		done BOOLEAN DEFAULT FALSE,
+		position REAL DEFAULT 0,
+		deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// archived_todos holds todos put away by ArchiveTodos. Unlike the
+	// trash it is not used by UndoLastDelete.
+	archivedQuery := `
+	CREATE TABLE IF NOT EXISTS archived_todos (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		original_id INTEGER NOT NULL,
+		text TEXT NOT NULL,
+		done BOOLEAN DEFAULT FALSE,
+		position REAL DEFAULT 0,
+		archived_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// encryption holds the salt of an encrypted database and a sealed
+	// value to check keys against. It is empty for a plain one.
+	encryptionQuery := `
+	CREATE TABLE IF NOT EXISTS encryption (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		salt BLOB NOT NULL,
+		check_value TEXT NOT NULL
+	);`
+
+	// external_ids maps IDs from other task apps (iCalendar UIDs,
+	// Taskwarrior UUIDs) to todos, so repeated imports update items
+	// instead of duplicating them and exports keep stable IDs.
+	externalIDsQuery := `
+	CREATE TABLE IF NOT EXISTS external_ids (
+		source TEXT NOT NULL,
+		external_id TEXT NOT NULL,
+		todo_id INTEGER NOT NULL,
+		PRIMARY KEY (source, external_id)
+	);`
+
+	// todo_log holds every change of the todos, see log.go. The three
+	// tables above are rebuilt from it.
+	logQuery := `
+	CREATE TABLE IF NOT EXISTS todo_log (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		batch INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		reverts INTEGER NOT NULL DEFAULT 0,
+		at DATETIME NOT NULL,
+		state TEXT NOT NULL,
+		todo_id INTEGER NOT NULL,
+		text TEXT NOT NULL,
+		done BOOLEAN NOT NULL DEFAULT FALSE,
+		position REAL NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'todo',
+		priority TEXT NOT NULL DEFAULT '',
+		created_at DATETIME,
+		completed_at DATETIME,
+		due DATETIME,
+		projects TEXT NOT NULL DEFAULT '',
+		contexts TEXT NOT NULL DEFAULT '',
+		extensions TEXT NOT NULL DEFAULT '',
+		parent_id INTEGER NOT NULL DEFAULT 0,
+		notes TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT '',
+		source_ref TEXT NOT NULL DEFAULT '',
+		branch TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS todo_log_todo ON todo_log (todo_id, seq);
+	CREATE INDEX IF NOT EXISTS todo_log_at ON todo_log (at);
+	CREATE INDEX IF NOT EXISTS todo_log_reverts ON todo_log (reverts);`
+
+	for _, query := range []string{todosQuery, deletedQuery, archivedQuery, encryptionQuery, externalIDsQuery, logQuery} {
+		if _, err := s.db.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return s.migrate()
+}
+
+// migrate brings tables created by older versions up to date. Each step
+// must be safe to run against a database that already has it applied.
+func (s *SQLiteStore) migrate() error {
+	for _, table := range []string{"todos", "deleted_todos", "archived_todos"} {
+		added, err := s.addColumn(table, "status", "TEXT NOT NULL DEFAULT 'todo'")
+		if err != nil {
+			return err
+		}
+		if added {
+			_, err = s.db.Exec(`UPDATE ` + table + ` SET status = 'done' WHERE done`)
+			if err != nil {
+				return err
+			}
+		}
+
+		columns := [][2]string{
+			{"priority", "TEXT NOT NULL DEFAULT ''"},
+			{"created_at", "DATETIME"},
+			{"completed_at", "DATETIME"},
+			{"due", "DATETIME"},
+			{"projects", "TEXT NOT NULL DEFAULT ''"},
+			{"contexts", "TEXT NOT NULL DEFAULT ''"},
+			{"extensions", "TEXT NOT NULL DEFAULT ''"},
+			{"parent_id", "INTEGER NOT NULL DEFAULT 0"},
+			{"notes", "TEXT NOT NULL DEFAULT ''"},
+			{"source", "TEXT NOT NULL DEFAULT ''"},
+			{"source_ref", "TEXT NOT NULL DEFAULT ''"},
+			{"branch", "TEXT NOT NULL DEFAULT ''"},
+		}
+		for _, column := range columns {
+			if _, err := s.addColumn(table, column[0], column[1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.adoptTodos()
+}
+
+// addColumn adds a column unless the table already has it, and reports
+// whether it did.
+func (s *SQLiteStore) addColumn(table, column, definition string) (bool, error) {
+	rows, err := s.db.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	_, err = s.db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err == nil, err
+}
+
+func (s *SQLiteStore) GetTodos(ctx context.Context) ([]Todo, error) {
+	return QueryTodos(ctx, s.db, `SELECT id, `+TodoColumns+` FROM todos ORDER BY position, id`)
+}
+
+func (s *SQLiteStore) GetTodo(ctx context.Context, id int) (*Todo, error) {
+	return getTodo(ctx, s.db, id)
+}
+
+// rowQueryer is a *sql.DB or *sql.Tx.
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func getTodo(ctx context.Context, db rowQueryer, id int) (*Todo, error) {
+	query := `SELECT id, ` + TodoColumns + ` FROM todos WHERE id = ?`
+	row := db.QueryRowContext(ctx, query, id)
+
+	todo, err := ScanTodo(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &todo, nil
+}
+
+func (s *SQLiteStore) AddTodo(ctx context.Context, todo Todo) (int, error) {
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	maxPosition, err := getMaxPosition(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := tx.Insert(ctx, ActionCreate, todo, maxPosition+1)
+	if err != nil {
+		return 0, storeError(err)
+	}
+	return id, storeError(tx.Commit())
+}
+
+// ImportTodos appends todos in one transaction, so a failing import
+// leaves the list untouched. ParentRef is resolved to the ID of the
+// referenced todo of the batch. It returns the new IDs in order.
+func (s *SQLiteStore) ImportTodos(ctx context.Context, todos []Todo) ([]int, error) {
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	maxPosition, err := getMaxPosition(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(todos))
+	for i, todo := range todos {
+		if todo.ParentRef > 0 && todo.ParentRef <= i {
+			todo.ParentID = ids[todo.ParentRef-1]
+		}
+		ids[i], err = tx.Insert(ctx, ActionImport, todo, maxPosition+float64(i+1))
+		if err != nil {
+			return nil, storeError(err)
+		}
+	}
+
+	return ids, storeError(tx.Commit())
+}
+
+func (s *SQLiteStore) UpdateTodo(ctx context.Context, id int, text string) error {
+	return s.changeTodo(ctx, id, ActionEdit, StateListed, func(todo *Todo) error {
+		todo.Text = text
+		return nil
+	})
+}
+
+func (s *SQLiteStore) EditTodo(ctx context.Context, id int, text, status string) error {
+	if status != "" && !ValidStatus(status) {
+		return fmt.Errorf("invalid status %q", status)
+	}
+
+	action := ActionEdit
+	if text == "" {
+		action = ActionStatus
+	}
+	return s.changeTodo(ctx, id, action, StateListed, func(todo *Todo) error {
+		if text != "" {
+			todo.Text = text
+		}
+		if status != "" {
+			setStatus(todo, status)
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) ToggleTodo(ctx context.Context, id int) error {
+	return s.changeTodo(ctx, id, ActionStatus, StateListed, func(todo *Todo) error {
+		if todo.Status == StatusDone {
+			setStatus(todo, StatusTodo)
+		} else {
+			setStatus(todo, StatusDone)
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) SetStatus(ctx context.Context, id int, status string) error {
+	if !ValidStatus(status) {
+		return fmt.Errorf("invalid status %q", status)
+	}
+
+	return s.changeTodo(ctx, id, ActionStatus, StateListed, func(todo *Todo) error {
+		setStatus(todo, status)
+		return nil
+	})
+}
+
+// changeTodo records the change made by change to the listed todo with
+// the given ID, leaving it in state, and reports ErrNotFound when there
+// is no such todo. The precondition of ctx is checked first.
+func (s *SQLiteStore) changeTodo(ctx context.Context, id int, action, state string, change func(*Todo) error) error {
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	todo, err := getTodo(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := precondition(ctx, *todo); err != nil {
+		return err
+	}
+	if err := change(todo); err != nil {
+		return err
+	}
+	if err := tx.Record(ctx, action, state, *todo); err != nil {
+		return storeError(err)
+	}
+
+	return storeError(tx.Commit())
+}
+
+func (s *SQLiteStore) DeleteTodo(ctx context.Context, id int) error {
+	return s.changeTodo(ctx, id, ActionDelete, StateTrashed, func(*Todo) error {
+		return nil
+	})
+}
+
+// ArchiveTodos moves todos to archived_todos in one transaction. IDs of
+// todos that are not in the list are skipped.
+func (s *SQLiteStore) ArchiveTodos(ctx context.Context, ids []int) error {
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		todo, err := getTodo(ctx, tx, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Record(ctx, ActionArchive, StateArchived, *todo); err != nil {
+			return storeError(err)
+		}
+	}
+
+	return storeError(tx.Commit())
+}
+
+func (s *SQLiteStore) UndoLastDelete(ctx context.Context) (*Todo, error) {
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT original_id, ` + TodoColumns + ` FROM deleted_todos ORDER BY deleted_at DESC, id DESC LIMIT 1`
+	todo, err := ScanTodo(tx.QueryRowContext(ctx, query))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNothingToUndo
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	maxPosition, err := getMaxPosition(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	todo.Position = maxPosition + 1
+
+	if err := tx.Record(ctx, ActionRestore, StateListed, todo); err != nil {
+		return nil, storeError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, storeError(err)
+	}
+	return &todo, nil
+}
+
+// GetDeletedTodos returns the whole trash, oldest first.
+func (s *SQLiteStore) GetDeletedTodos(ctx context.Context) ([]DeletedTodo, error) {
+	return QueryDeletedTodos(ctx, s.db)
+}
+
+func getMaxPosition(ctx context.Context, tx *Tx) (float64, error) {
+	var maxPos sql.NullFloat64
+	query := `SELECT MAX(position) FROM todos`
+	err := tx.QueryRowContext(ctx, query).Scan(&maxPos)
+	if err != nil {
+		return 0, err
+	}
+
+	return maxPos.Float64, nil
+}
+
+func (s *SQLiteStore) DataVersion(ctx context.Context) (int64, error) {
+	if s.watchConn == nil {
+		conn, err := s.db.Conn(ctx)
+		if err != nil {
+			return 0, err
+		}
+		s.watchConn = conn
+	}
+
+	var version int64
+	err := s.watchConn.QueryRowContext(ctx, `PRAGMA data_version`).Scan(&version)
+	return version, err
+}
+
+func (s *SQLiteStore) Close() error {
+	if s.watchConn != nil {
+		s.watchConn.Close()
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,79 @@
+package kaj
+
+import "context"
+
+// Store is a todo list. Todos are kept in order of Position; deleted
+// todos go to a trash from which UndoLastDelete restores them.
+//
+// Todos are addressed by ID. Methods given an ID that does not exist
+// return ErrNotFound, UndoLastDelete returns ErrNothingToUndo when the
+// trash is empty, and changes to a store that cannot be written return
+// ErrReadOnly.
+//
+// Changes to a single todo check the precondition set with
+// WithPrecondition in the same transaction as the change.
+type Store interface {
+	// GetTodos returns all todos in list order.
+	GetTodos(ctx context.Context) ([]Todo, error)
+	GetTodo(ctx context.Context, id int) (*Todo, error)
+
+	// AddTodo appends a todo to the end of the list and returns its ID.
+	// The ID and Position of todo are ignored.
+	AddTodo(ctx context.Context, todo Todo) (int, error)
+
+	// UpdateTodo changes the text of a todo, and with it its tags.
+	UpdateTodo(ctx context.Context, id int, text string) error
+
+	// EditTodo changes the text and the status of a todo in one change.
+	// An empty text or status is left as it is.
+	EditTodo(ctx context.Context, id int, text, status string) error
+
+	// SetStatus moves a todo to one of Statuses, recording when it was
+	// completed.
+	SetStatus(ctx context.Context, id int, status string) error
+
+	// ToggleTodo marks a todo done, or moves a done todo back to
+	// StatusTodo.
+	ToggleTodo(ctx context.Context, id int) error
+
+	DeleteTodo(ctx context.Context, id int) error
+
+	// UndoLastDelete restores the most recently deleted todo at the end
+	// of the list. A SQLite store keeps its ID, a MemoryStore gives it a
+	// new one.
+	UndoLastDelete(ctx context.Context) (*Todo, error)
+
+	// MoveTodo moves a todo past its neighbour above (direction -1) or
+	// below (direction 1). With sameStatus, todos of other statuses are
+	// skipped, which is how the board reorders within a column.
+	MoveTodo(ctx context.Context, id int, direction int, sameStatus bool) error
+
+	// MoveTodoTo moves a todo to a 0-based index of the list, counted
+	// without the todo itself. Indexes past either end move it to that
+	// end. Only the moved todo changes.
+	MoveTodoTo(ctx context.Context, id int, index int) error
+
+	// DataVersion returns a value that changes whenever the todos are
+	// changed, including by other processes sharing the store.
+	DataVersion(ctx context.Context) (int64, error)
+
+	Close() error
+}
+
+type preconditionKey struct{}
+
+// WithPrecondition returns a context under which a change to a single todo
+// first passes the todo to check and fails with its error, changing
+// nothing, if check returns one. kaj serve checks If-Match this way, so no
+// other change can come between the check and the change.
+func WithPrecondition(ctx context.Context, check func(Todo) error) context.Context {
+	return context.WithValue(ctx, preconditionKey{}, check)
+}
+
+// precondition runs the check set with WithPrecondition, if any.
+func precondition(ctx context.Context, todo Todo) error {
+	if check, ok := ctx.Value(preconditionKey{}).(func(Todo) error); ok {
+		return check(todo)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package kaj
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testStores returns an empty store of each kind, for the cases every
+// Store must pass.
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	dir := t.TempDir()
+
+	sqlite, err := OpenSQLite(filepath.Join(dir, "sqlite", "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := OpenText(filepath.Join(dir, "text", "todos.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := OpenShared(filepath.Join(dir, "shared"), "ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]Store{
+		"sqlite": sqlite,
+		"memory": NewMemoryStore(),
+		"text":   text,
+		"shared": shared,
+	}
+	t.Cleanup(func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	})
+	return stores
+}
+
+// addTodos adds a todo for each text and returns their IDs.
+func addTodos(t *testing.T, store Store, texts ...string) []int {
+	t.Helper()
+	var ids []int
+	for _, text := range texts {
+		id, err := store.AddTodo(t.Context(), NewTodo(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// texts returns the texts of the todos in list order.
+func texts(t *testing.T, store Store) []string {
+	t.Helper()
+	todos, err := store.GetTodos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, todo := range todos {
+		texts = append(texts, todo.Text)
+	}
+	return texts
+}
+
+func mustGetTodo(t *testing.T, store Store, id int) Todo {
+	t.Helper()
+	todo, err := store.GetTodo(t.Context(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *todo
+}
+
+func TestStoreChanges(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := t.Context()
+			ids := addTodos(t, store, "write tests +kaj", "review")
+
+			if err := store.UpdateTodo(ctx, ids[0], "write more tests +kaj @home"); err != nil {
+				t.Fatal(err)
+			}
+			todo := mustGetTodo(t, store, ids[0])
+			if !slices.Equal(todo.Projects, []string{"kaj"}) || !slices.Equal(todo.Contexts, []string{"home"}) {
+				t.Errorf("tags = %v %v", todo.Projects, todo.Contexts)
+			}
+
+			if err := store.SetStatus(ctx, ids[0], StatusDone); err != nil {
+				t.Fatal(err)
+			}
+			if todo := mustGetTodo(t, store, ids[0]); !todo.Done || todo.CompletedAt == nil {
+				t.Errorf("after SetStatus(done): done %v, completed %v", todo.Done, todo.CompletedAt)
+			}
+			if err := store.ToggleTodo(ctx, ids[0]); err != nil {
+				t.Fatal(err)
+			}
+			if todo := mustGetTodo(t, store, ids[0]); todo.Status != StatusTodo || todo.CompletedAt != nil {
+				t.Errorf("after toggle: status %s, completed %v", todo.Status, todo.CompletedAt)
+			}
+
+			if err := store.EditTodo(ctx, ids[1], "review again", StatusDoing); err != nil {
+				t.Fatal(err)
+			}
+			if todo := mustGetTodo(t, store, ids[1]); todo.Text != "review again" || todo.Status != StatusDoing {
+				t.Errorf("after EditTodo: %q %s", todo.Text, todo.Status)
+			}
+
+			if err := store.SetStatus(ctx, ids[0], "later"); err == nil {
+				t.Error("SetStatus accepted an invalid status")
+			}
+			if _, err := store.GetTodo(ctx, 999); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetTodo(999) = %v, want ErrNotFound", err)
+			}
+			if err := store.UpdateTodo(ctx, 999, "x"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("UpdateTodo(999) = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestStoreDeleteAndUndo(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := t.Context()
+			ids := addTodos(t, store, "a", "b", "c")
+
+			if err := store.DeleteTodo(ctx, ids[1]); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.GetTodo(ctx, ids[1]); !errors.Is(err, ErrNotFound) {
+				t.Errorf("deleted todo: %v", err)
+			}
+
+			todo, err := store.UndoLastDelete(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if todo.Text != "b" || todo.ID != ids[1] {
+				t.Errorf("restored %d %q, want %d %q", todo.ID, todo.Text, ids[1], "b")
+			}
+			if _, err := store.GetTodo(ctx, ids[1]); err != nil {
+				t.Errorf("restored todo by its ID: %v", err)
+			}
+			if got := texts(t, store); !slices.Equal(got, []string{"a", "c", "b"}) {
+				t.Errorf("after undo: %v", got)
+			}
+			if _, err := store.UndoLastDelete(ctx); !errors.Is(err, ErrNothingToUndo) {
+				t.Errorf("empty trash: %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreOrder(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := t.Context()
+			ids := addTodos(t, store, "a", "b", "c", "d")
+
+			if err := store.MoveTodo(ctx, ids[3], -1, false); err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(t, store); !slices.Equal(got, []string{"a", "b", "d", "c"}) {
+				t.Errorf("after move up: %v", got)
+			}
+			if err := store.MoveTodo(ctx, ids[0], -1, false); err != nil {
+				t.Errorf("moving the first todo up: %v", err)
+			}
+
+			// Only the moved todo gets a new position.
+			before := mustGetTodo(t, store, ids[1]).Position
+			if err := store.MoveTodoTo(ctx, ids[0], 99); err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(t, store); !slices.Equal(got, []string{"b", "d", "c", "a"}) {
+				t.Errorf("after move to the end: %v", got)
+			}
+			if after := mustGetTodo(t, store, ids[1]).Position; after != before {
+				t.Errorf("position of b changed from %v to %v", before, after)
+			}
+
+			if err := store.SetStatus(ctx, ids[2], StatusDone); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.MoveTodo(ctx, ids[0], -1, true); err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(t, store); !slices.Equal(got, []string{"b", "a", "d", "c"}) {
+				t.Errorf("after move up within the status: %v", got)
+			}
+		})
+	}
+}
+
+// TestStoreOrderPrecision moves todos into the same gap until the
+// positions run out of precision and are renumbered.
+func TestStoreOrderPrecision(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ids := addTodos(t, store, "a", "b", "c")
+			want := []string{"a", "b", "c"}
+
+			for i := range 80 {
+				id, text := ids[2], "c"
+				if i%2 == 1 {
+					id, text = ids[1], "b"
+				}
+				if err := store.MoveTodoTo(t.Context(), id, 1); err != nil {
+					t.Fatal(err)
+				}
+				want = slices.DeleteFunc(want, func(s string) bool { return s == text })
+				want = slices.Insert(want, 1, text)
+			}
+
+			if got := texts(t, store); !slices.Equal(got, want) {
+				t.Errorf("order = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStorePrecondition(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ids := addTodos(t, store, "a", "b")
+
+			errStale := errors.New("stale")
+			var checked Todo
+			ctx := WithPrecondition(t.Context(), func(todo Todo) error {
+				checked = todo
+				return errStale
+			})
+
+			changes := map[string]error{
+				"UpdateTodo": store.UpdateTodo(ctx, ids[0], "changed"),
+				"EditTodo":   store.EditTodo(ctx, ids[0], "changed", StatusDone),
+				"ToggleTodo": store.ToggleTodo(ctx, ids[0]),
+				"DeleteTodo": store.DeleteTodo(ctx, ids[0]),
+				"MoveTodo":   store.MoveTodo(ctx, ids[0], 1, false),
+				"MoveTodoTo": store.MoveTodoTo(ctx, ids[0], 1),
+			}
+			for change, err := range changes {
+				if !errors.Is(err, errStale) {
+					t.Errorf("%s = %v, want the precondition's error", change, err)
+				}
+			}
+			if checked.ID != ids[0] || checked.Text != "a" {
+				t.Errorf("precondition got %+v", checked)
+			}
+			if got := texts(t, store); !slices.Equal(got, []string{"a", "b"}) {
+				t.Errorf("todos changed: %v", got)
+			}
+		})
+	}
+}
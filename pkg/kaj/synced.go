@@ -0,0 +1,179 @@
+package kaj
+
+import (
+	"context"
+	"time"
+)
+
+// syncedStore is a SQLiteStore used as the cache of todos kept in files,
+// such as a text file or shared operation logs. sync brings the cache
+// and the files in line; it runs before every read and around every
+// change.
+type syncedStore struct {
+	*SQLiteStore
+	sync func(ctx context.Context) error
+}
+
+// The Store methods sync before they read and after they change the
+// cache.
+
+func (s *syncedStore) GetTodos(ctx context.Context) ([]Todo, error) {
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	return s.SQLiteStore.GetTodos(ctx)
+}
+
+func (s *syncedStore) GetTodo(ctx context.Context, id int) (*Todo, error) {
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	return s.SQLiteStore.GetTodo(ctx, id)
+}
+
+func (s *syncedStore) AddTodo(ctx context.Context, todo Todo) (int, error) {
+	if err := s.sync(ctx); err != nil {
+		return 0, err
+	}
+	id, err := s.SQLiteStore.AddTodo(ctx, todo)
+	if err != nil {
+		return 0, err
+	}
+	return id, s.sync(ctx)
+}
+
+func (s *syncedStore) ImportTodos(ctx context.Context, todos []Todo) ([]int, error) {
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	ids, err := s.SQLiteStore.ImportTodos(ctx, todos)
+	if err != nil {
+		return nil, err
+	}
+	return ids, s.sync(ctx)
+}
+
+func (s *syncedStore) UndoLastDelete(ctx context.Context) (*Todo, error) {
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	todo, err := s.SQLiteStore.UndoLastDelete(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return todo, s.sync(ctx)
+}
+
+// change runs a change to the cache between two syncs.
+func (s *syncedStore) change(ctx context.Context, apply func() error) error {
+	if err := s.sync(ctx); err != nil {
+		return err
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	return s.sync(ctx)
+}
+
+func (s *syncedStore) UpdateTodo(ctx context.Context, id int, text string) error {
+	return s.change(ctx, func() error { return s.SQLiteStore.UpdateTodo(ctx, id, text) })
+}
+
+func (s *syncedStore) EditTodo(ctx context.Context, id int, text, status string) error {
+	return s.change(ctx, func() error { return s.SQLiteStore.EditTodo(ctx, id, text, status) })
+}
+
+func (s *syncedStore) SetStatus(ctx context.Context, id int, status string) error {
+	return s.change(ctx, func() error { return s.SQLiteStore.SetStatus(ctx, id, status) })
+}
+
+func (s *syncedStore) ToggleTodo(ctx context.Context, id int) error {
+	return s.change(ctx, func() error { return s.SQLiteStore.ToggleTodo(ctx, id) })
+}
+
+func (s *syncedStore) DeleteTodo(ctx context.Context, id int) error {
+	return s.change(ctx, func() error { return s.SQLiteStore.DeleteTodo(ctx, id) })
+}
+
+func (s *syncedStore) ArchiveTodos(ctx context.Context, ids []int) error {
+	return s.change(ctx, func() error { return s.SQLiteStore.ArchiveTodos(ctx, ids) })
+}
+
+func (s *syncedStore) MoveTodo(ctx context.Context, id int, direction int, sameStatus bool) error {
+	return s.change(ctx, func() error { return s.SQLiteStore.MoveTodo(ctx, id, direction, sameStatus) })
+}
+
+func (s *syncedStore) MoveTodoTo(ctx context.Context, id int, index int) error {
+	return s.change(ctx, func() error { return s.SQLiteStore.MoveTodoTo(ctx, id, index) })
+}
+
+func (s *syncedStore) SetSourceRef(ctx context.Context, id int, ref string) error {
+	return s.change(ctx, func() error { return s.SQLiteStore.SetSourceRef(ctx, id, ref) })
+}
+
+func (s *syncedStore) ImportExternal(ctx context.Context, source string, items []ExternalTodo) (added, updated int, err error) {
+	err = s.change(ctx, func() (err error) {
+		added, updated, err = s.SQLiteStore.ImportExternal(ctx, source, items)
+		return err
+	})
+	return added, updated, err
+}
+
+func (s *syncedStore) RestoreTodos(ctx context.Context, todos []Todo, trash []DeletedTodo, mode string, dryRun bool) (*RestoreResult, error) {
+	var result *RestoreResult
+	err := s.change(ctx, func() (err error) {
+		result, err = s.SQLiteStore.RestoreTodos(ctx, todos, trash, mode, dryRun)
+		return err
+	})
+	return result, err
+}
+
+func (s *syncedStore) Undo(ctx context.Context) ([]Event, error) {
+	var events []Event
+	err := s.change(ctx, func() (err error) {
+		events, err = s.SQLiteStore.Undo(ctx)
+		return err
+	})
+	return events, err
+}
+
+func (s *syncedStore) Redo(ctx context.Context) ([]Event, error) {
+	var events []Event
+	err := s.change(ctx, func() (err error) {
+		events, err = s.SQLiteStore.Redo(ctx)
+		return err
+	})
+	return events, err
+}
+
+func (s *syncedStore) Events(ctx context.Context) ([]Event, error) {
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	return s.SQLiteStore.Events(ctx)
+}
+
+func (s *syncedStore) TodosAt(ctx context.Context, at time.Time) ([]Todo, error) {
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	return s.SQLiteStore.TodosAt(ctx, at)
+}
+
+// DataVersion also changes when the files were edited.
+func (s *syncedStore) DataVersion(ctx context.Context) (int64, error) {
+	if err := s.sync(ctx); err != nil {
+		return 0, err
+	}
+	return s.SQLiteStore.DataVersion(ctx)
+}
+
+// Close writes changes made through DB() to the files and closes the
+// cache.
+func (s *syncedStore) Close() error {
+	err := s.sync(context.Background())
+	if closeErr := s.SQLiteStore.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
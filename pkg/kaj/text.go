@@ -0,0 +1,412 @@
+package kaj
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The text format is a todo.txt file, one todo per line in list order,
+// with the kaj fields todo.txt has no syntax for as extensions and the
+// notes as indented lines below their todo:
+//
+//	(A) 2024-01-01 call mom +family due:2024-01-05 id:12
+//	x 2024-01-03 2024-01-01 release v2 branch:release id:13
+//	    tag the commit
+//	    and write the changelog
+//
+// Values of these fields are percent-encoded where todo.txt could not
+// read them back: "%", whitespace and a leading "/", as in
+// ref:%2Fsrc/my%20app/main.go:12.
+//
+// IDs are kept across edits, so a line moved, reworded or completed
+// shows up as a one-line change in a diff. A line added by hand without
+// an id gets one the next time kaj reads the file.
+
+// cacheName is the SQLite cache kept next to a text file or shared logs.
+// It holds the state that is not shared: the trash, the archive and the
+// tables of kaj's commands.
+const cacheName = "cache.db"
+
+// textFields are the extensions that carry Todo fields in the text
+// format.
+var textFields = []string{"id", "parent", "branch", "source", "ref"}
+
+// encodeTextValue percent-encodes the characters of a field value that
+// end or reject a todo.txt extension.
+func encodeTextValue(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		if r != '%' && !unicode.IsSpace(r) && (i > 0 || r != '/') {
+			b.WriteRune(r)
+			continue
+		}
+		for _, c := range []byte(string(r)) {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// decodeTextValue reverses encodeTextValue. A value with a stray "%",
+// as written by hand, is taken as it is.
+func decodeTextValue(value string) string {
+	decoded, err := url.PathUnescape(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// TextStore is a Store kept in a plain text file that can be committed
+// and reviewed, normally .todos/todos.txt of a project.
+//
+// The todos are worked on in a SQLite cache and synced with the file
+// before and after every change: edits to the file, by hand or by git,
+// are loaded into the cache, and changes in the cache are written to the
+// file. Changes made through DB() are written on the next sync or on
+// Close.
+type TextStore struct {
+	syncedStore
+	path string
+}
+
+var _ Store = (*TextStore)(nil)
+
+// OpenText opens the text file at path, creating it when it does not
+// exist, together with its cache.
+func OpenText(path string) (*TextStore, error) {
+	cache, err := OpenSQLite(filepath.Join(filepath.Dir(path), cacheName))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = cache.db.Exec(`CREATE TABLE IF NOT EXISTS text_sync (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		hash TEXT NOT NULL
+	)`)
+	if err != nil {
+		cache.Close()
+		return nil, storeError(err)
+	}
+
+	store := &TextStore{path: path}
+	store.syncedStore = syncedStore{SQLiteStore: cache, sync: store.Sync}
+	if err := store.Sync(context.Background()); err != nil {
+		cache.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Path returns the path of the text file.
+func (s *TextStore) Path() string {
+	return s.path
+}
+
+// Sync brings the cache and the text file in line. A file that changed
+// since the last sync replaces the todos in the cache; otherwise the
+// file is rewritten when the todos in the cache changed.
+func (s *TextStore) Sync(ctx context.Context) error {
+	tx, err := Begin(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var synced string
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM text_sync WHERE id = 1`).Scan(&synced)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if hashText(data) != synced {
+		todos, err := ParseText(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.path, err)
+		}
+		if err := loadText(ctx, tx, todos); err != nil {
+			return storeError(err)
+		}
+	}
+
+	todos, err := QueryTodos(ctx, tx, `SELECT id, `+TodoColumns+` FROM todos ORDER BY position, id`)
+	if err != nil {
+		return err
+	}
+	content := FormatText(todos)
+	if !bytes.Equal(content, data) {
+		if err := writeFileAtomic(s.path, content); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT OR REPLACE INTO text_sync (id, hash) VALUES (1, ?)`, hashText(content))
+	if err != nil {
+		return storeError(err)
+	}
+	return storeError(tx.Commit())
+}
+
+// loadText replaces the todos in the cache with the todos of the file,
+// keeping their IDs, and records the differences as sync events. Todos
+// without an ID, or with one used by an earlier line, get a new one.
+func loadText(ctx context.Context, tx *Tx, todos []Todo) error {
+	existing, err := QueryTodos(ctx, tx, `SELECT id, `+TodoColumns+` FROM todos`)
+	if err != nil {
+		return err
+	}
+
+	cached := map[int]Todo{}
+	for _, todo := range existing {
+		cached[todo.ID] = todo
+	}
+
+	// An unchanged line keeps the todo as it is in the cache, with the
+	// times the text format only has the dates of.
+	for i, todo := range todos {
+		if old, ok := cached[todo.ID]; ok && bytes.Equal(FormatText([]Todo{old}), FormatText([]Todo{todo})) {
+			todos[i] = old
+		}
+	}
+	positions := textPositions(todos, cached)
+
+	seen := map[int]bool{}
+	var added []int
+	for i, todo := range todos {
+		todo.Position = positions[i]
+		if todo.ID == 0 || seen[todo.ID] {
+			added = append(added, i)
+			continue
+		}
+		seen[todo.ID] = true
+
+		if err := tx.Record(ctx, ActionSync, StateListed, todo); err != nil {
+			return err
+		}
+	}
+
+	for _, todo := range existing {
+		if !seen[todo.ID] {
+			if err := tx.Record(ctx, ActionSync, StateGone, todo); err != nil {
+				return err
+			}
+		}
+	}
+	for _, i := range added {
+		if _, err := tx.Insert(ctx, ActionSync, todos[i], positions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// textPositions returns the positions of the todos of a text file in
+// line order. The longest run of lines still in the order of the cache
+// keeps the positions they have there, and moved and added lines get
+// positions between their neighbours, so only they change. When no
+// float64 is left between two neighbours, the todos are numbered 1, 2,
+// 3 and so on.
+func textPositions(todos []Todo, cached map[int]Todo) []float64 {
+	// Indexes of the todos that have a cached position, at most once per
+	// ID.
+	var candidates []int
+	seen := map[int]bool{}
+	for i, todo := range todos {
+		if _, ok := cached[todo.ID]; ok && !seen[todo.ID] {
+			candidates = append(candidates, i)
+		}
+		seen[todo.ID] = true
+	}
+	position := func(c int) float64 { return cached[todos[candidates[c]].ID].Position }
+
+	// Longest strictly increasing run of cached positions: tails[k] is the
+	// candidate ending the best run of length k+1 found so far.
+	var tails []int
+	previous := make([]int, len(candidates))
+	for c := range candidates {
+		k := sort.Search(len(tails), func(k int) bool { return position(tails[k]) >= position(c) })
+		previous[c] = -1
+		if k > 0 {
+			previous[c] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, c)
+		} else {
+			tails[k] = c
+		}
+	}
+
+	positions := make([]float64, len(todos))
+	kept := make([]bool, len(todos))
+	if len(tails) > 0 {
+		for c := tails[len(tails)-1]; c >= 0; c = previous[c] {
+			positions[candidates[c]] = position(c)
+			kept[candidates[c]] = true
+		}
+	}
+
+	for i := range todos {
+		if kept[i] {
+			continue
+		}
+		var before, after *float64
+		if i > 0 {
+			before = &positions[i-1]
+		}
+		for j := i + 1; j < len(todos); j++ {
+			if kept[j] {
+				after = &positions[j]
+				break
+			}
+		}
+		p, ok := between(before, after)
+		if !ok {
+			for j := range positions {
+				positions[j] = float64(j + 1)
+			}
+			return positions
+		}
+		positions[i] = p
+	}
+	return positions
+}
+
+// ParseText reads todos in the text format.
+func ParseText(data []byte) ([]Todo, error) {
+	var todos []Todo
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(todos) == 0 {
+				return nil, fmt.Errorf("line %d: notes without a todo", lineNumber)
+			}
+			todo := &todos[len(todos)-1]
+			note := strings.TrimPrefix(strings.TrimPrefix(line, "\t"), "    ")
+			if todo.Notes != "" {
+				todo.Notes += "\n"
+			}
+			todo.Notes += note
+			continue
+		}
+
+		todo, err := ParseTodoTxtLine(strings.TrimSpace(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+
+		for _, key := range textFields {
+			value, ok := todo.Extensions[key]
+			if !ok {
+				continue
+			}
+			delete(todo.Extensions, key)
+
+			switch key {
+			case "id":
+				todo.ID, _ = strconv.Atoi(value)
+			case "parent":
+				todo.ParentID, _ = strconv.Atoi(value)
+			case "branch":
+				todo.Branch = decodeTextValue(value)
+			case "source":
+				todo.Source = decodeTextValue(value)
+			case "ref":
+				todo.SourceRef = decodeTextValue(value)
+			}
+		}
+		if len(todo.Extensions) == 0 {
+			todo.Extensions = nil
+		}
+
+		todos = append(todos, todo)
+	}
+
+	return todos, scanner.Err()
+}
+
+// FormatText writes todos in the text format.
+func FormatText(todos []Todo) []byte {
+	var buf bytes.Buffer
+
+	for _, todo := range todos {
+		extensions := map[string]string{}
+		for key, value := range todo.Extensions {
+			extensions[key] = value
+		}
+		extensions["id"] = strconv.Itoa(todo.ID)
+		if todo.ParentID != 0 {
+			extensions["parent"] = strconv.Itoa(todo.ParentID)
+		}
+		for key, value := range map[string]string{"branch": todo.Branch, "source": todo.Source, "ref": todo.SourceRef} {
+			if value != "" {
+				extensions[key] = encodeTextValue(value)
+			}
+		}
+		todo.Extensions = extensions
+
+		buf.WriteString(FormatTodoTxtLine(todo))
+		buf.WriteByte('\n')
+
+		if todo.Notes != "" {
+			for _, note := range strings.Split(todo.Notes, "\n") {
+				buf.WriteString("    " + note + "\n")
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func hashText(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic replaces a file through a rename, so readers never see
+// it half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
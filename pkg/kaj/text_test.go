@@ -0,0 +1,120 @@
+package kaj
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestTextHandEdits edits the text file by hand and expects a sync event
+// for each changed line only.
+func TestTextHandEdits(t *testing.T) {
+	ctx := t.Context()
+	store, err := OpenText(filepath.Join(t.TempDir(), "todos.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	addTodos(t, store, "a", "b", "c", "d")
+	before, err := store.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the last line to the top, reword b and add a line.
+	data, err := os.ReadFile(store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	lines = append([][]byte{lines[3]}, lines[:3]...)
+	lines[2] = bytes.Replace(lines[2], []byte(" b "), []byte(" b reworded "), 1)
+	lines = append(lines, []byte("e\n"))
+	if err := os.WriteFile(store.Path(), bytes.Join(lines, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := texts(t, store); !slices.Equal(got, []string{"d", "a", "b reworded", "c", "e"}) {
+		t.Fatalf("todos = %v", got)
+	}
+	after, err := store.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changed []string
+	for _, event := range after[len(before):] {
+		changed = append(changed, event.Todo.Text)
+	}
+	slices.Sort(changed)
+	if !slices.Equal(changed, []string{"b reworded", "d", "e"}) {
+		t.Errorf("sync events for %v, want the moved, reworded and added todos", changed)
+	}
+}
+
+// TestTextRoundTrip writes todos with every field of textFields, and
+// text that looks like them, and expects them to read back unchanged.
+func TestTextRoundTrip(t *testing.T) {
+	todos := []Todo{
+		{ID: 4, Text: "ask bob re:budget today", Status: StatusTodo, Position: 1},
+		{ID: 5, Text: "id:9 parent:2 ref:x are just words", Status: StatusTodo, Position: 2, ParentID: 4},
+		{ID: 6, Text: "fix the parser", Status: StatusDoing, Position: 3, Branch: "feature/new parser", Source: "scan tool",
+			SourceRef: "/home/ada/my app/a.go:1", Notes: "first note\nsecond"},
+		{ID: 7, Text: "percent", Status: StatusTodo, Position: 4, Branch: "50%", SourceRef: "%2F", Extensions: map[string]string{"rec": "1w"}},
+	}
+
+	got, err := ParseText(FormatText(todos))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(todos) {
+		t.Fatalf("read %d todos, want %d", len(got), len(todos))
+	}
+	for i, want := range todos {
+		g := got[i]
+		if g.ID != want.ID || g.Text != want.Text || g.Status != want.Status || g.ParentID != want.ParentID ||
+			g.Branch != want.Branch || g.Source != want.Source || g.SourceRef != want.SourceRef || g.Notes != want.Notes ||
+			!maps.Equal(g.Extensions, want.Extensions) {
+			t.Errorf("todo %d read back as %+v, want %+v", i, g, want)
+		}
+	}
+}
+
+// TestTextKeepsFieldsOnHandEdits adds todos through the store, edits
+// another line by hand, and expects the todos to be read back as they
+// were.
+func TestTextKeepsFieldsOnHandEdits(t *testing.T) {
+	ctx := t.Context()
+	store, err := OpenText(filepath.Join(t.TempDir(), "todos.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ids := addTodos(t, store, "ask bob re:budget today", "id:1 is not an id", "other")
+	if err := store.SetSourceRef(ctx, ids[0], "/abs/path/a.go:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("other"), []byte("other edited"), 1)
+	if err := os.WriteFile(store.Path(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := texts(t, store); !slices.Equal(got, []string{"ask bob re:budget today", "id:1 is not an id", "other edited"}) {
+		t.Errorf("todos = %v", got)
+	}
+	if todo := mustGetTodo(t, store, ids[0]); todo.SourceRef != "/abs/path/a.go:1" {
+		t.Errorf("ref = %q", todo.SourceRef)
+	}
+	if todo := mustGetTodo(t, store, ids[1]); todo.ID != ids[1] {
+		t.Errorf("second todo has ID %d, want %d", todo.ID, ids[1])
+	}
+}
@@ -0,0 +1,106 @@
+// Package kaj holds the todo model and storage of the kaj todo manager,
+// so that other tools can read and change kaj's lists without going
+// through its command line. Storage backends implement Store.
+package kaj
+
+import (
+	"strings"
+	"time"
+)
+
+// Todo statuses, in board column order. Done mirrors Status == StatusDone.
+const (
+	StatusTodo  = "todo"
+	StatusDoing = "doing"
+	StatusDone  = "done"
+)
+
+var Statuses = []string{StatusTodo, StatusDoing, StatusDone}
+
+func ValidStatus(status string) bool {
+	for _, s := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Todo is a single item. Projects and Contexts are derived from the
+// +project and @context words in Text; Extensions keeps key:value pairs
+// from imported items that kaj has no field for.
+type Todo struct {
+	ID          int               `json:"id"`
+	Text        string            `json:"text"`
+	Done        bool              `json:"done"`
+	Position    float64           `json:"position"`
+	Status      string            `json:"status"`
+	Priority    string            `json:"priority,omitempty"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+	Due         *time.Time        `json:"due,omitempty"`
+	Projects    []string          `json:"projects,omitempty"`
+	Contexts    []string          `json:"contexts,omitempty"`
+	Extensions  map[string]string `json:"extensions,omitempty"`
+	ParentID    int               `json:"parent_id,omitempty"`
+	Notes       string            `json:"notes,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	SourceRef   string            `json:"source_ref,omitempty"`
+	Branch      string            `json:"branch,omitempty"`
+
+	// ParentRef is the 1-based index of the parent within a batch of
+	// todos being imported, for importers that nest items before they
+	// have IDs. It is not stored.
+	ParentRef int `json:"-"`
+}
+
+// NewTodo returns an open todo created now.
+func NewTodo(text string) Todo {
+	now := time.Now()
+	return Todo{Text: text, Status: StatusTodo, CreatedAt: &now}
+}
+
+// DeletedTodo is a todo in the trash. ID holds the todo's original ID.
+type DeletedTodo struct {
+	Todo
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// setStatus moves a todo to status, recording when it was completed.
+func setStatus(todo *Todo, status string) {
+	switch {
+	case status != StatusDone:
+		todo.CompletedAt = nil
+	case todo.Status != StatusDone:
+		now := time.Now()
+		todo.CompletedAt = &now
+	}
+	todo.Status = status
+	todo.Done = status == StatusDone
+}
+
+// ParseTags returns the +project and @context words of a todo text.
+func ParseTags(text string) (projects, contexts []string) {
+	for _, word := range strings.Fields(text) {
+		switch {
+		case len(word) > 1 && word[0] == '+':
+			projects = append(projects, word[1:])
+		case len(word) > 1 && word[0] == '@':
+			contexts = append(contexts, word[1:])
+		}
+	}
+	return projects, contexts
+}
+
+// normalize fills in the status of a todo that only says whether it is
+// done, and keeps Done and the tags in line with Status and Text.
+func normalize(todo *Todo) {
+	if todo.Status == "" {
+		todo.Status = StatusTodo
+		if todo.Done {
+			todo.Status = StatusDone
+		}
+	}
+	todo.Done = todo.Status == StatusDone
+	todo.Projects, todo.Contexts = ParseTags(todo.Text)
+}
@@ -0,0 +1,183 @@
+package kaj
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// todo.txt format, see https://github.com/todotxt/todo.txt.
+//
+//	x (A) 2024-01-02 2024-01-01 call mom +family @phone due:2024-01-05
+//
+// Projects and contexts stay part of the todo text. Priority, dates and
+// key:value extensions are stored in their own fields. A few extensions
+// carry kaj fields that todo.txt has no syntax for: "due" maps to
+// Todo.Due, "pri" keeps the priority of completed items, and
+// "status:doing" marks items in progress.
+//
+// A word of the text that would be read as an extension, or as the done
+// mark, priority or a date when it comes first, is written with a
+// leading backslash, which reading removes. Words that already start
+// with backslashes get one more, so they read back unchanged too.
+
+const todoTxtDate = "2006-01-02"
+
+var (
+	todoTxtPriority  = regexp.MustCompile(`^\(([A-Z])\)$`)
+	todoTxtExtension = regexp.MustCompile(`^([A-Za-z][^\s:]*):([^\s/]\S*)$`)
+)
+
+func parseTodoTxtDate(word string) (*time.Time, bool) {
+	t, err := time.ParseInLocation(todoTxtDate, word, time.Local)
+	if err != nil {
+		return nil, false
+	}
+	return &t, true
+}
+
+// todoTxtSyntax reports whether a word of the text, without its leading
+// backslashes, would be read as something else, and so is escaped.
+func todoTxtSyntax(word string, first bool) bool {
+	word = strings.TrimLeft(word, `\`)
+	if todoTxtExtension.MatchString(word) {
+		return true
+	}
+	if !first {
+		return false
+	}
+	_, date := parseTodoTxtDate(word)
+	return word == "x" || todoTxtPriority.MatchString(word) || date
+}
+
+// ParseTodoTxtLine parses one non-empty todo.txt line.
+func ParseTodoTxtLine(line string) (Todo, error) {
+	todo := Todo{Status: StatusTodo}
+	words := strings.Fields(line)
+
+	if len(words) > 0 && words[0] == "x" {
+		todo.Status = StatusDone
+		todo.Done = true
+		words = words[1:]
+	}
+
+	if len(words) > 0 {
+		if m := todoTxtPriority.FindStringSubmatch(words[0]); m != nil {
+			todo.Priority = m[1]
+			words = words[1:]
+		}
+	}
+
+	// A completed item may carry a completion date followed by a
+	// creation date; an open item only a creation date.
+	if len(words) > 0 {
+		if date, ok := parseTodoTxtDate(words[0]); ok {
+			words = words[1:]
+			if todo.Done {
+				todo.CompletedAt = date
+				if len(words) > 0 {
+					if created, ok := parseTodoTxtDate(words[0]); ok {
+						todo.CreatedAt = created
+						words = words[1:]
+					}
+				}
+			} else {
+				todo.CreatedAt = date
+			}
+		}
+	}
+
+	var text []string
+	for _, word := range words {
+		if strings.HasPrefix(word, `\`) && todoTxtSyntax(word, len(text) == 0) {
+			text = append(text, word[1:])
+			continue
+		}
+		m := todoTxtExtension.FindStringSubmatch(word)
+		if m == nil {
+			text = append(text, word)
+			continue
+		}
+
+		key, value := m[1], m[2]
+		switch {
+		case key == "due":
+			due, ok := parseTodoTxtDate(value)
+			if !ok {
+				return todo, fmt.Errorf("invalid due date %q", value)
+			}
+			todo.Due = due
+		case key == "pri" && todo.Priority == "" && len(value) == 1:
+			todo.Priority = value
+		case key == "status" && value == StatusDoing && !todo.Done:
+			todo.Status = StatusDoing
+		default:
+			if todo.Extensions == nil {
+				todo.Extensions = map[string]string{}
+			}
+			todo.Extensions[key] = value
+		}
+	}
+
+	if len(text) == 0 {
+		return todo, fmt.Errorf("missing description")
+	}
+	todo.Text = strings.Join(text, " ")
+	todo.Projects, todo.Contexts = ParseTags(todo.Text)
+
+	return todo, nil
+}
+
+// FormatTodoTxtLine is the inverse of ParseTodoTxtLine.
+func FormatTodoTxtLine(todo Todo) string {
+	var words []string
+
+	if todo.Done {
+		words = append(words, "x")
+	} else if todo.Priority != "" {
+		words = append(words, "("+todo.Priority+")")
+	}
+
+	if todo.Done && (todo.CompletedAt != nil || todo.CreatedAt != nil) {
+		// The creation date is only recognized after a completion
+		// date, so fall back to it when the completion date is unknown.
+		completed := todo.CompletedAt
+		if completed == nil {
+			completed = todo.CreatedAt
+		}
+		words = append(words, completed.Format(todoTxtDate))
+	}
+	if todo.CreatedAt != nil {
+		words = append(words, todo.CreatedAt.Format(todoTxtDate))
+	}
+
+	for i, word := range strings.Fields(todo.Text) {
+		if todoTxtSyntax(word, i == 0) {
+			word = `\` + word
+		}
+		words = append(words, word)
+	}
+
+	if todo.Done && todo.Priority != "" {
+		words = append(words, "pri:"+todo.Priority)
+	}
+	if todo.Status == StatusDoing {
+		words = append(words, "status:"+StatusDoing)
+	}
+	if todo.Due != nil {
+		words = append(words, "due:"+todo.Due.Format(todoTxtDate))
+	}
+
+	keys := make([]string, 0, len(todo.Extensions))
+	for key := range todo.Extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		words = append(words, key+":"+todo.Extensions[key])
+	}
+
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,94 @@
+package kaj
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func date(s string) *time.Time {
+	t, err := time.ParseInLocation(todoTxtDate, s, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+func TestParseTodoTxtLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want Todo
+	}{
+		{"call mom", Todo{Text: "call mom", Status: StatusTodo}},
+		{"(A) 2024-01-01 call mom +family @phone", Todo{Text: "call mom +family @phone", Status: StatusTodo, Priority: "A", CreatedAt: date("2024-01-01")}},
+		{"x 2024-01-03 2024-01-01 call mom pri:B", Todo{Text: "call mom", Status: StatusDone, Priority: "B", CompletedAt: date("2024-01-03"), CreatedAt: date("2024-01-01")}},
+		{"x 2024-01-03 call mom", Todo{Text: "call mom", Status: StatusDone, CompletedAt: date("2024-01-03")}},
+		{"write report due:2024-02-01 status:doing", Todo{Text: "write report", Status: StatusDoing, Due: date("2024-02-01")}},
+		{"ask bob re:budget today", Todo{Text: "ask bob today", Status: StatusTodo, Extensions: map[string]string{"re": "budget"}}},
+		{`ask bob \re:budget today`, Todo{Text: "ask bob re:budget today", Status: StatusTodo}},
+		{"read https://example.com/a:b", Todo{Text: "read https://example.com/a:b", Status: StatusTodo}},
+		{`\x marks the spot`, Todo{Text: "x marks the spot", Status: StatusTodo}},
+		{`\\id:3`, Todo{Text: `\id:3`, Status: StatusTodo}},
+		{`\escaped? no`, Todo{Text: `\escaped? no`, Status: StatusTodo}},
+	}
+	for _, test := range tests {
+		got, err := ParseTodoTxtLine(test.line)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+		if !sameTodoTxt(got, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.line, got, test.want)
+		}
+	}
+
+	for _, line := range []string{"x 2024-01-03", "call mom due:soon"} {
+		if _, err := ParseTodoTxtLine(line); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
+
+// TestTodoTxtRoundTrip formats todos whose text looks like todo.txt
+// syntax, and expects them to read back unchanged.
+func TestTodoTxtRoundTrip(t *testing.T) {
+	todos := []Todo{
+		{Text: "call mom +family @phone", Status: StatusTodo, Priority: "A", CreatedAt: date("2024-01-01"), Due: date("2024-01-05")},
+		{Text: "call mom", Status: StatusDone, Priority: "B", CompletedAt: date("2024-01-03"), CreatedAt: date("2024-01-01")},
+		{Text: "write report", Status: StatusDoing, Extensions: map[string]string{"t": "2024-02-01", "rec": "1w"}},
+		{Text: "ask bob re:budget today", Status: StatusTodo},
+		{Text: "id:3 and parent:4 are words", Status: StatusTodo},
+		{Text: "ends with due:tomorrow", Status: StatusTodo},
+		{Text: "x marks the spot", Status: StatusTodo},
+		{Text: "(A) is a grade", Status: StatusTodo, Priority: "B"},
+		{Text: "2024-01-01 retro", Status: StatusDone, CompletedAt: date("2024-01-03")},
+		{Text: `\re:x and \\x stay`, Status: StatusTodo},
+		{Text: "read https://example.com/a:b", Status: StatusTodo},
+	}
+	for _, todo := range todos {
+		todo.Done = todo.Status == StatusDone
+		line := FormatTodoTxtLine(todo)
+		got, err := ParseTodoTxtLine(line)
+		if err != nil {
+			t.Errorf("%q: %v", line, err)
+			continue
+		}
+		if !sameTodoTxt(got, todo) {
+			t.Errorf("%q read back as %+v, want %+v", line, got, todo)
+		}
+	}
+
+	if line := FormatTodoTxtLine(Todo{Text: "ask bob re:budget today"}); line != `ask bob \re:budget today` {
+		t.Errorf("formatted %q", line)
+	}
+}
+
+// sameTodoTxt compares the fields todo.txt keeps.
+func sameTodoTxt(a, b Todo) bool {
+	sameTime := func(a, b *time.Time) bool {
+		return a == nil && b == nil || a != nil && b != nil && a.Equal(*b)
+	}
+	return a.Text == b.Text && a.Status == b.Status && a.Priority == b.Priority &&
+		sameTime(a.CreatedAt, b.CreatedAt) && sameTime(a.CompletedAt, b.CompletedAt) && sameTime(a.Due, b.Due) &&
+		maps.Equal(a.Extensions, b.Extensions)
+}
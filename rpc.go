@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// kaj rpc --stdio speaks newline-delimited JSON-RPC 2.0 on stdin and
+// stdout. The methods mirror the Database API (GetTodos, AddTodo, ...);
+// the same server also answers the Model Context Protocol handshake and
+// exposes those operations as MCP tools. Whenever the database changes,
+// from this server or any other kaj process, a "todos/changed"
+// notification is sent.
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcTodoNotFound   = -32001
+	rpcNothingToUndo  = -32002
+	rpcReadOnly       = -32003
+)
+
+// mcpProtocolVersion is answered to clients that do not ask for one.
+const mcpProtocolVersion = "2025-06-18"
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// MarshalJSON writes either the result, even when it is null, or the
+// error, as JSON-RPC 2.0 requires exactly one of them.
+func (r rpcResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+			Error   *rpcError       `json:"error"`
+		}{r.JSONRPC, r.ID, r.Error})
+	}
+	type response rpcResponse
+	return json.Marshal(response(r))
+}
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcServer struct {
+	db kaj.Store
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// send writes one message per line. Responses and notifications come
+// from different goroutines, hence the lock.
+func (s *rpcServer) send(msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.Write(append(data, '\n'))
+}
+
+// serveRPC answers requests from in until it is closed.
+func serveRPC(ctx context.Context, db kaj.Store, in io.Reader, out io.Writer) error {
+	s := &rpcServer{db: db, out: out}
+
+	version, err := db.DataVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.watchChanges(ctx, version, done)
+
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if reply := s.handleMessage(ctx, line); reply != nil {
+				s.send(reply)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// watchChanges polls the database like the TUI does and notifies the
+// client of every change.
+func (s *rpcServer) watchChanges(ctx context.Context, last int64, done chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			version, err := s.db.DataVersion(ctx)
+			if err != nil || version == last {
+				continue
+			}
+			last = version
+			s.send(rpcNotification{JSONRPC: "2.0", Method: "todos/changed"})
+		}
+	}
+}
+
+// handleMessage handles a single request or a batch and returns what to
+// reply, or nil when there is nothing to reply (notifications only).
+func (s *rpcServer) handleMessage(ctx context.Context, data []byte) any {
+	if data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, "parse error"}}
+		}
+		if len(batch) == 0 {
+			return rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcInvalidRequest, "empty batch"}}
+		}
+
+		var replies []rpcResponse
+		for _, item := range batch {
+			if reply := s.handleRequest(ctx, item); reply != nil {
+				replies = append(replies, *reply)
+			}
+		}
+		if len(replies) == 0 {
+			return nil
+		}
+		return replies
+	}
+
+	if reply := s.handleRequest(ctx, data); reply != nil {
+		return *reply
+	}
+	return nil
+}
+
+func (s *rpcServer) handleRequest(ctx context.Context, data []byte) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, "parse error"}}
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		id := req.ID
+		if id == nil {
+			id = json.RawMessage("null")
+		}
+		return &rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{rpcInvalidRequest, "invalid request"}}
+	}
+
+	result, err := s.call(ctx, req.Method, req.Params)
+
+	// Requests without an ID are notifications and get no reply.
+	if req.ID == nil {
+		return nil
+	}
+
+	reply := &rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+	if err != nil {
+		reply.Result = nil
+		reply.Error = toRPCError(err)
+	}
+	return reply
+}
+
+// toRPCError picks the error code for an error returned by a method.
+func toRPCError(err error) *rpcError {
+	var rpcErr *rpcError
+	switch {
+	case errors.As(err, &rpcErr):
+		return rpcErr
+	case errors.Is(err, kaj.ErrNotFound):
+		return &rpcError{rpcTodoNotFound, err.Error()}
+	case errors.Is(err, kaj.ErrNothingToUndo), errors.Is(err, kaj.ErrNothingToRedo):
+		return &rpcError{rpcNothingToUndo, err.Error()}
+	case errors.Is(err, kaj.ErrReadOnly):
+		return &rpcError{rpcReadOnly, err.Error()}
+	}
+	return &rpcError{rpcInternalError, err.Error()}
+}
+
+func (s *rpcServer) call(ctx context.Context, method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "initialize":
+		return s.initialize(params)
+	case "notifications/initialized", "notifications/cancelled":
+		return nil, nil
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return map[string]any{"tools": mcpTools}, nil
+	case "tools/call":
+		return s.callTool(ctx, params)
+	}
+
+	if op, ok := rpcMethods[method]; ok {
+		return op(ctx, s.db, params)
+	}
+	return nil, &rpcError{rpcMethodNotFound, "method not found: " + method}
+}
+
+func (s *rpcServer) initialize(params json.RawMessage) (any, error) {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if len(params) > 0 {
+		json.Unmarshal(params, &p)
+	}
+	if p.ProtocolVersion == "" {
+		p.ProtocolVersion = mcpProtocolVersion
+	}
+
+	return map[string]any{
+		"protocolVersion": p.ProtocolVersion,
+		"capabilities":    map[string]any{"tools": map[string]any{}},
+		"serverInfo":      map[string]any{"name": "kaj", "version": Version},
+	}, nil
+}
+
+// rpcOp implements one method on decoded params.
+type rpcOp func(ctx context.Context, db kaj.Store, params json.RawMessage) (any, error)
+
+type rpcIDParams struct {
+	ID int `json:"id"`
+}
+
+type rpcListParams struct {
+	Status  string `json:"status"`
+	Project string `json:"project"`
+	Context string `json:"context"`
+	Branch  string `json:"branch"`
+	Query   string `json:"query"`
+}
+
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{rpcInvalidParams, "invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+// existingTodo returns the todo with the id in params.
+func existingTodo(ctx context.Context, db kaj.Store, params json.RawMessage) (*Todo, error) {
+	var p rpcIDParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.ID == 0 {
+		return nil, &rpcError{rpcInvalidParams, "id is required"}
+	}
+
+	todo, err := db.GetTodo(ctx, p.ID)
+	if errors.Is(err, kaj.ErrNotFound) {
+		return nil, &rpcError{rpcTodoNotFound, fmt.Sprintf("todo %d not found", p.ID)}
+	}
+	return todo, err
+}
+
+// changeTodo runs a change on an existing todo and returns it afterwards.
+func changeTodo(change func(ctx context.Context, db kaj.Store, todo *Todo) error) rpcOp {
+	return func(ctx context.Context, db kaj.Store, params json.RawMessage) (any, error) {
+		todo, err := existingTodo(ctx, db, params)
+		if err != nil {
+			return nil, err
+		}
+		if err := change(ctx, db, todo); err != nil {
+			return nil, err
+		}
+		return db.GetTodo(ctx, todo.ID)
+	}
+}
+
+var rpcMethods = map[string]rpcOp{
+	"GetTodos": func(ctx context.Context, db kaj.Store, params json.RawMessage) (any, error) {
+		var p rpcListParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+
+		todos, err := db.GetTodos(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		filtered := []Todo{}
+		for _, todo := range todos {
+			if p.matches(todo) {
+				filtered = append(filtered, todo)
+			}
+		}
+		return filtered, nil
+	},
+
+	"AddTodo": func(ctx context.Context, db kaj.Store, params json.RawMessage) (any, error) {
+		var p struct {
+			Text   string `json:"text"`
+			Branch string `json:"branch"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(p.Text) == "" {
+			return nil, &rpcError{rpcInvalidParams, "text is required"}
+		}
+
+		todo := kaj.NewTodo(strings.TrimSpace(p.Text))
+		todo.Branch = p.Branch
+		id, err := db.AddTodo(ctx, todo)
+		if err != nil {
+			return nil, err
+		}
+		return db.GetTodo(ctx, id)
+	},
+
+	"UpdateTodo": func(ctx context.Context, db kaj.Store, params json.RawMessage) (any, error) {
+		var p struct {
+			Text   string `json:"text"`
+			Status string `json:"status"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Status != "" && !kaj.ValidStatus(p.Status) {
+			return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("invalid status %q", p.Status)}
+		}
+
+		// Text and status change together, as one change to undo.
+		return changeTodo(func(ctx context.Context, db kaj.Store, todo *Todo) error {
+			text, status := strings.TrimSpace(p.Text), p.Status
+			if text == todo.Text {
+				text = ""
+			}
+			if status == todo.Status {
+				status = ""
+			}
+			if text == "" && status == "" {
+				return nil
+			}
+			return db.EditTodo(ctx, todo.ID, text, status)
+		})(ctx, db, params)
+	},
+
+	"ToggleTodo": changeTodo(func(ctx context.Context, db kaj.Store, todo *Todo) error {
+		return db.ToggleTodo(ctx, todo.ID)
+	}),
+
+	"MoveTodoUp": changeTodo(func(ctx context.Context, db kaj.Store, todo *Todo) error {
+		return db.MoveTodo(ctx, todo.ID, -1, false)
+	}),
+
+	"MoveTodoDown": changeTodo(func(ctx context.Context, db kaj.Store, todo *Todo) error {
+		return db.MoveTodo(ctx, todo.ID, 1, false)
+	}),
+
+	"DeleteTodo": func(ctx context.Context, db kaj.Store, params json.RawMessage) (any, error) {
+		todo, err := existingTodo(ctx, db, params)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.DeleteTodo(ctx, todo.ID); err != nil {
+			return nil, err
+		}
+		return todo, nil
+	},
+
+	"Undo": func(ctx context.Context, db kaj.Store, params json.RawMessage) (any, error) {
+		events, err := undoChange(ctx, db, false)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"undid": describeBatch(events)}, nil
+	},
+
+	"Redo": func(ctx context.Context, db kaj.Store, params json.RawMessage) (any, error) {
+		events, err := undoChange(ctx, db, true)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"redid": describeBatch(events)}, nil
+	},
+}
+
+func (p rpcListParams) matches(todo Todo) bool {
+	contains := func(list []string, s string) bool {
+		for _, item := range list {
+			if item == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case p.Status != "" && todo.Status != p.Status:
+	case p.Project != "" && !contains(todo.Projects, p.Project):
+	case p.Context != "" && !contains(todo.Contexts, p.Context):
+	case p.Branch != "" && todo.Branch != p.Branch:
+	case p.Query != "" && !strings.Contains(strings.ToLower(todo.Text), strings.ToLower(p.Query)):
+	default:
+		return true
+	}
+	return false
+}
+
+// mcpTool describes one MCP tool and the method it calls.
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+
+	method string
+}
+
+func mcpSchema(properties map[string]any, required ...string) map[string]any {
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var (
+	mcpIDProperty     = map[string]any{"type": "integer", "description": "Todo ID"}
+	mcpStatusProperty = map[string]any{"type": "string", "enum": Statuses}
+)
+
+var mcpTools = []mcpTool{
+	{
+		Name:        "list_todos",
+		Description: "List todos in order, optionally filtered by status, +project, @context, git branch or text.",
+		InputSchema: mcpSchema(map[string]any{
+			"status":  mcpStatusProperty,
+			"project": map[string]any{"type": "string"},
+			"context": map[string]any{"type": "string"},
+			"branch":  map[string]any{"type": "string"},
+			"query":   map[string]any{"type": "string", "description": "Case-insensitive text search"},
+		}),
+		method: "GetTodos",
+	},
+	{
+		Name:        "add_todo",
+		Description: "Add a todo at the end of the list. Words like +project and @context in the text are tags.",
+		InputSchema: mcpSchema(map[string]any{
+			"text":   map[string]any{"type": "string"},
+			"branch": map[string]any{"type": "string", "description": "Git branch to tie the todo to"},
+		}, "text"),
+		method: "AddTodo",
+	},
+	{
+		Name:        "update_todo",
+		Description: "Change the text and/or status of a todo.",
+		InputSchema: mcpSchema(map[string]any{
+			"id":     mcpIDProperty,
+			"text":   map[string]any{"type": "string"},
+			"status": mcpStatusProperty,
+		}, "id"),
+		method: "UpdateTodo",
+	},
+	{
+		Name:        "toggle_todo",
+		Description: "Mark a todo done, or open again if it is done.",
+		InputSchema: mcpSchema(map[string]any{"id": mcpIDProperty}, "id"),
+		method:      "ToggleTodo",
+	},
+	{
+		Name:        "delete_todo",
+		Description: "Move a todo to the trash. undo restores it.",
+		InputSchema: mcpSchema(map[string]any{"id": mcpIDProperty}, "id"),
+		method:      "DeleteTodo",
+	},
+	{
+		Name:        "move_todo_up",
+		Description: "Move a todo one place up in the list.",
+		InputSchema: mcpSchema(map[string]any{"id": mcpIDProperty}, "id"),
+		method:      "MoveTodoUp",
+	},
+	{
+		Name:        "move_todo_down",
+		Description: "Move a todo one place down in the list.",
+		InputSchema: mcpSchema(map[string]any{"id": mcpIDProperty}, "id"),
+		method:      "MoveTodoDown",
+	},
+	{
+		Name:        "undo",
+		Description: "Undo the last change to the todos, such as an add, edit, delete or move.",
+		InputSchema: mcpSchema(map[string]any{}),
+		method:      "Undo",
+	},
+	{
+		Name:        "redo",
+		Description: "Make the last undone change again.",
+		InputSchema: mcpSchema(map[string]any{}),
+		method:      "Redo",
+	},
+}
+
+// callTool runs an MCP tool. Failures of the tool itself are reported in
+// the result with isError, as MCP asks, not as JSON-RPC errors.
+func (s *rpcServer) callTool(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	for _, tool := range mcpTools {
+		if tool.Name != p.Name {
+			continue
+		}
+
+		result, err := rpcMethods[tool.method](ctx, s.db, p.Arguments)
+		if err != nil {
+			return mcpText(err.Error(), true), nil
+		}
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return mcpText(string(data), false), nil
+	}
+
+	return nil, &rpcError{rpcInvalidParams, "unknown tool: " + p.Name}
+}
+
+func mcpText(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]any{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// rpc sends lines to a server on db and returns its replies, one per
+// line.
+func rpc(t *testing.T, db kaj.Store, lines ...string) []json.RawMessage {
+	t.Helper()
+	var out bytes.Buffer
+	if err := serveRPC(t.Context(), db, strings.NewReader(strings.Join(lines, "\n")+"\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	var replies []json.RawMessage
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line != "" {
+			replies = append(replies, json.RawMessage(line))
+		}
+	}
+	return replies
+}
+
+// rpcReply is a response, with the fields that are present.
+type rpcReply struct {
+	Result *json.RawMessage
+	Error  *rpcError
+}
+
+func decodeReply(t *testing.T, data json.RawMessage) rpcReply {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+
+	var reply rpcReply
+	if result, ok := fields["result"]; ok {
+		reply.Result = &result
+	}
+	if raw, ok := fields["error"]; ok {
+		reply.Error = &rpcError{}
+		if err := json.Unmarshal(raw, reply.Error); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+	}
+	if (reply.Result == nil) == (reply.Error == nil) {
+		t.Errorf("%s: want exactly one of result and error", data)
+	}
+	return reply
+}
+
+func TestRPCProtocol(t *testing.T) {
+	store := kaj.NewMemoryStore()
+	if _, err := store.AddTodo(t.Context(), kaj.NewTodo("one")); err != nil {
+		t.Fatal(err)
+	}
+
+	replies := rpc(t, store,
+		`{"jsonrpc": "2.0", "method": "ping"}`,
+		`not json`,
+		`{"jsonrpc": "2.0", "id": 1, "method": "Jump"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "ToggleTodo", "params": {}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "ToggleTodo", "params": {"id": 99}}`,
+		`{"jsonrpc": "2.0", "id": 4, "method": "notifications/initialized"}`,
+		`{"id": 5, "method": "ping"}`,
+	)
+	wantCodes := []int{rpcParseError, rpcMethodNotFound, rpcInvalidParams, rpcTodoNotFound, 0, rpcInvalidRequest}
+	if len(replies) != len(wantCodes) {
+		t.Fatalf("%d replies, want %d (none for the notification): %s", len(replies), len(wantCodes), replies)
+	}
+	for i, data := range replies {
+		reply := decodeReply(t, data)
+		code := 0
+		if reply.Error != nil {
+			code = reply.Error.Code
+		}
+		if code != wantCodes[i] {
+			t.Errorf("reply %s: code %d, want %d", data, code, wantCodes[i])
+		}
+	}
+	if reply := decodeReply(t, replies[4]); reply.Result == nil || string(*reply.Result) != "null" {
+		t.Errorf("null result: %s", replies[4])
+	}
+
+	// A batch gets one array with a reply per request, and none for
+	// notifications; a batch of notifications gets no reply at all.
+	replies = rpc(t, store,
+		`[{"jsonrpc": "2.0", "id": 1, "method": "GetTodos"}, {"jsonrpc": "2.0", "method": "ToggleTodo", "params": {"id": 1}}]`,
+		`[{"jsonrpc": "2.0", "method": "ping"}]`,
+		`[]`,
+	)
+	if len(replies) != 2 {
+		t.Fatalf("batch replies: %s", replies)
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(replies[0], &batch); err != nil || len(batch) != 1 {
+		t.Errorf("batch reply %s: %v", replies[0], err)
+	}
+	if reply := decodeReply(t, replies[1]); reply.Error == nil || reply.Error.Code != rpcInvalidRequest {
+		t.Errorf("empty batch: %s", replies[1])
+	}
+	if todo, _ := store.GetTodo(t.Context(), 1); todo.Status != StatusDone {
+		t.Errorf("notification in a batch was not run: %s", todo.Status)
+	}
+}
+
+func TestRPCToolCall(t *testing.T) {
+	store := kaj.NewMemoryStore()
+	replies := rpc(t, store,
+		`{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "add_todo", "arguments": {"text": "one"}}}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "tools/call", "params": {"name": "delete_todo", "arguments": {"id": 99}}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "tools/call", "params": {"name": "jump"}}`,
+	)
+	if len(replies) != 3 {
+		t.Fatalf("replies: %s", replies)
+	}
+
+	for i, wantError := range []bool{false, true} {
+		reply := decodeReply(t, replies[i])
+		var result struct {
+			IsError bool `json:"isError"`
+		}
+		if reply.Result == nil || json.Unmarshal(*reply.Result, &result) != nil || result.IsError != wantError {
+			t.Errorf("tool result %s: want isError %v", replies[i], wantError)
+		}
+	}
+	if reply := decodeReply(t, replies[2]); reply.Error == nil || reply.Error.Code != rpcInvalidParams {
+		t.Errorf("unknown tool: %s", replies[2])
+	}
+}
+
+// TestRPCUpdateTodo changes text and status in one call, and expects one
+// undo to revert both.
+func TestRPCUpdateTodo(t *testing.T) {
+	store, err := kaj.OpenSQLite(filepath.Join(t.TempDir(), "todos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	id, err := store.AddTodo(t.Context(), kaj.NewTodo("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replies := rpc(t, store,
+		`{"jsonrpc": "2.0", "id": 1, "method": "UpdateTodo", "params": {"id": 1, "text": "two", "status": "doing"}}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "UpdateTodo", "params": {"id": 1, "text": "three", "status": "later"}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "Undo"}`,
+	)
+	if reply := decodeReply(t, replies[1]); reply.Error == nil || reply.Error.Code != rpcInvalidParams {
+		t.Errorf("invalid status: %s", replies[1])
+	}
+	if reply := decodeReply(t, replies[2]); reply.Error != nil {
+		t.Fatalf("undo: %s", replies[2])
+	}
+	todo, err := store.GetTodo(t.Context(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.Text != "one" || todo.Status != StatusTodo {
+		t.Errorf("after one undo: %q %s, want both changes undone", todo.Text, todo.Status)
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// kaj scan turns TODO, FIXME and HACK comments into todos. Each todo
+// remembers the file:line it came from in SourceRef and is marked with
+// Source "scan". A rescan matches comments to existing todos by file and
+// text, so moved comments only update the line number and comments that
+// disappeared resolve their todo.
+
+const scanSource = "scan"
+
+// maxScanFileSize skips generated or vendored blobs.
+const maxScanFileSize = 1 << 20
+
+var commentStyles = map[string][]string{
+	"c":    {"//", "/*", "*"},
+	"hash": {"#"},
+	"dash": {"--"},
+	"semi": {";"},
+	"html": {"<!--"},
+}
+
+var scanExtensions = map[string]string{
+	".go": "c", ".c": "c", ".h": "c", ".cc": "c", ".cpp": "c", ".hpp": "c",
+	".cs": "c", ".java": "c", ".kt": "c", ".scala": "c", ".swift": "c",
+	".rs": "c", ".js": "c", ".jsx": "c", ".ts": "c", ".tsx": "c", ".mjs": "c",
+	".php": "c", ".css": "c", ".scss": "c", ".less": "c", ".dart": "c", ".zig": "c",
+	".py": "hash", ".rb": "hash", ".sh": "hash", ".bash": "hash", ".zsh": "hash",
+	".pl": "hash", ".r": "hash", ".ex": "hash", ".exs": "hash", ".nim": "hash",
+	".yaml": "hash", ".yml": "hash", ".toml": "hash", ".tf": "hash", ".ps1": "hash",
+	".sql": "dash", ".lua": "dash", ".hs": "dash", ".elm": "dash",
+	".lisp": "semi", ".el": "semi", ".clj": "semi", ".scm": "semi", ".ini": "semi",
+	".html": "html", ".xml": "html", ".vue": "html", ".svelte": "html",
+}
+
+var scanFileNames = map[string]string{
+	"Makefile": "hash", "Dockerfile": "hash", "Rakefile": "hash", "Gemfile": "hash",
+}
+
+var commentPatterns = map[string]*regexp.Regexp{}
+
+func init() {
+	for style, markers := range commentStyles {
+		quoted := make([]string, len(markers))
+		for i, marker := range markers {
+			quoted[i] = regexp.QuoteMeta(marker)
+		}
+		commentPatterns[style] = regexp.MustCompile(
+			`(?:^|\s)(?:` + strings.Join(quoted, "|") + `)\s*(TODO|FIXME|HACK)\b(?:\([^)]*\))?:?\s*(.*)$`)
+	}
+}
+
+// scanComment is one TODO-style comment found in a file.
+type scanComment struct {
+	file string
+	line int
+	text string
+}
+
+func commentStyle(file string) string {
+	if style, ok := scanFileNames[filepath.Base(file)]; ok {
+		return style
+	}
+	return scanExtensions[strings.ToLower(filepath.Ext(file))]
+}
+
+func scanFile(file string) ([]scanComment, error) {
+	style := commentStyle(file)
+	if style == "" {
+		return nil, nil
+	}
+
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() || info.Size() > maxScanFileSize {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(content, 0) >= 0 {
+		return nil, nil
+	}
+
+	var comments []scanComment
+	pattern := commentPatterns[style]
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), maxScanFileSize)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		m := pattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+
+		text := strings.TrimSpace(m[2])
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(text, "*/"), "-->"))
+		if text == "" {
+			continue
+		}
+
+		comments = append(comments, scanComment{
+			file: filepath.ToSlash(file),
+			line: lineNumber,
+			text: m[1] + ": " + strings.Join(strings.Fields(text), " "),
+		})
+	}
+
+	return comments, scanner.Err()
+}
+
+// listScanFiles returns the files below paths that git does not ignore.
+// Inside a git work tree git itself answers that; elsewhere the
+// .gitignore files are read with gitignoreMatcher.
+func listScanFiles(paths []string) ([]string, error) {
+	args := append([]string{"ls-files", "--cached", "--others", "--exclude-standard", "-z", "--"}, paths...)
+	if out, err := exec.Command("git", args...).Output(); err == nil {
+		var files []string
+		for _, file := range strings.Split(string(out), "\x00") {
+			if file != "" {
+				files = append(files, file)
+			}
+		}
+		return files, nil
+	}
+
+	var files []string
+	for _, root := range paths {
+		matcher := newGitignoreMatcher()
+		err := filepath.WalkDir(root, func(p string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel := filepath.ToSlash(p)
+			if entry.IsDir() {
+				if entry.Name() == ".git" || entry.Name() == ".todos" || matcher.ignored(rel, true) {
+					if p == root {
+						return nil
+					}
+					return filepath.SkipDir
+				}
+				matcher.load(p)
+				return nil
+			}
+
+			if !matcher.ignored(rel, false) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+type gitignoreRule struct {
+	base    string
+	pattern string
+	negate  bool
+	dirOnly bool
+	rooted  bool
+}
+
+// gitignoreMatcher implements the common subset of .gitignore syntax:
+// globs, "!" negation, trailing "/" for directories, leading "/" and
+// "**" wildcards.
+type gitignoreMatcher struct {
+	rules []gitignoreRule
+}
+
+func newGitignoreMatcher() *gitignoreMatcher {
+	return &gitignoreMatcher{}
+}
+
+func (g *gitignoreMatcher) load(dir string) {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	base := filepath.ToSlash(filepath.Clean(dir))
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := gitignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.rooted = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		rule.pattern = line
+		g.rules = append(g.rules, rule)
+	}
+}
+
+func (g *gitignoreMatcher) ignored(p string, isDir bool) bool {
+	ignored := false
+	for _, rule := range g.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		// Rules of a .gitignore only apply below its directory.
+		rel := strings.TrimPrefix(p, "./")
+		if rule.base != "." {
+			if !strings.HasPrefix(p, rule.base+"/") {
+				continue
+			}
+			rel = p[len(rule.base)+1:]
+		}
+
+		var matched bool
+		if rule.rooted {
+			matched = globMatch(rule.pattern, rel)
+		} else {
+			matched, _ = path.Match(rule.pattern, path.Base(rel))
+		}
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// globMatch is path.Match with support for "**" matching any number of
+// path segments.
+func globMatch(pattern, name string) bool {
+	if !strings.Contains(pattern, "**") {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}
+
+	prefix, rest, _ := strings.Cut(pattern, "**")
+	prefix = strings.TrimSuffix(prefix, "/")
+	rest = strings.TrimPrefix(rest, "/")
+
+	segments := strings.Split(name, "/")
+	for i := 0; i <= len(segments); i++ {
+		head := strings.Join(segments[:i], "/")
+		if prefix != "" {
+			if matched, _ := path.Match(prefix, head); !matched {
+				continue
+			}
+		}
+		for j := i; j <= len(segments); j++ {
+			tail := strings.Join(segments[j:], "/")
+			if rest == "" || globMatch(rest, tail) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type scanReport struct {
+	Files    int
+	Found    int
+	Added    int
+	Moved    int
+	Resolved int
+}
+
+// scanTodos scans paths and upserts the comments found as todos.
+func scanTodos(ctx context.Context, db *Database, paths []string) (*scanReport, error) {
+	files, err := listScanFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &scanReport{Files: len(files)}
+	var comments []scanComment
+	for _, file := range files {
+		found, err := scanFile(file)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, found...)
+	}
+	report.Found = len(comments)
+
+	todos, err := db.GetTodos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Existing scan todos by file and text. A text can occur several
+	// times in one file, so each key holds a queue of todos, open ones
+	// first. Done todos stay in the queue so that a todo completed by
+	// hand is not added again while its comment is still there.
+	sort.SliceStable(todos, func(i, j int) bool {
+		return !todos[i].Done && todos[j].Done
+	})
+	existing := map[string][]Todo{}
+	for _, todo := range todos {
+		if todo.Source != scanSource {
+			continue
+		}
+		file, _ := splitSourceRef(todo.SourceRef)
+		key := file + "\x00" + todo.Text
+		existing[key] = append(existing[key], todo)
+	}
+
+	for _, comment := range comments {
+		ref := fmt.Sprintf("%s:%d", comment.file, comment.line)
+		key := comment.file + "\x00" + comment.text
+
+		if queue := existing[key]; len(queue) > 0 {
+			todo := queue[0]
+			existing[key] = queue[1:]
+			if todo.SourceRef != ref {
+				if err := db.SetSourceRef(ctx, todo.ID, ref); err != nil {
+					return nil, err
+				}
+				report.Moved++
+			}
+			continue
+		}
+
+		_, err := db.AddTodo(ctx, Todo{Text: comment.text, Status: StatusTodo, Source: scanSource, SourceRef: ref})
+		if err != nil {
+			return nil, err
+		}
+		report.Added++
+	}
+
+	// Whatever is left was not found again. Only resolve todos of files
+	// inside the scanned paths, so scanning a subdirectory leaves the
+	// rest alone.
+	for _, queue := range existing {
+		for _, todo := range queue {
+			file, _ := splitSourceRef(todo.SourceRef)
+			if todo.Done || !withinPaths(file, paths) {
+				continue
+			}
+			if err := db.SetStatus(ctx, todo.ID, StatusDone); err != nil {
+				return nil, err
+			}
+			report.Resolved++
+		}
+	}
+
+	return report, nil
+}
+
+func splitSourceRef(ref string) (string, int) {
+	i := strings.LastIndex(ref, ":")
+	if i < 0 {
+		return ref, 0
+	}
+	line, err := strconv.Atoi(ref[i+1:])
+	if err != nil {
+		return ref, 0
+	}
+	return ref[:i], line
+}
+
+func withinPaths(file string, paths []string) bool {
+	for _, p := range paths {
+		p = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(p)), "/")
+		if p == "." || file == p || strings.HasPrefix(file, p+"/") {
+			return true
+		}
+	}
+	return false
+}
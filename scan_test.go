@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeFiles creates files below dir, with their directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScanTodos(t *testing.T) {
+	ctx := t.Context()
+	db := testDatabase(t)
+	t.Chdir(t.TempDir())
+
+	scan := func(content string) *scanReport {
+		t.Helper()
+		writeFiles(t, ".", map[string]string{"a.go": content})
+		report, err := scanTodos(ctx, db, []string{"."})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	todo := func(text string) Todo {
+		t.Helper()
+		todos, err := db.GetTodos(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, todo := range todos {
+			if todo.Text == text {
+				return todo
+			}
+		}
+		t.Fatalf("no todo %q in %v", text, todos)
+		return Todo{}
+	}
+
+	report := scan("package a\n// TODO: first\nfunc f() {} // FIXME(ada): second */\n")
+	if report.Added != 2 {
+		t.Fatalf("first scan: %+v", report)
+	}
+	if first := todo("TODO: first"); first.SourceRef != "a.go:2" || first.Source != scanSource {
+		t.Errorf("first todo from %q %q", first.Source, first.SourceRef)
+	}
+
+	// The comment moved down and the other one was removed.
+	report = scan("package a\n\nimport \"fmt\"\n\n// TODO: first\n")
+	if report.Added != 0 || report.Moved != 1 || report.Resolved != 1 {
+		t.Errorf("rescan: %+v", report)
+	}
+	if first := todo("TODO: first"); first.SourceRef != "a.go:5" || first.Done {
+		t.Errorf("moved todo: %q done %v", first.SourceRef, first.Done)
+	}
+	if second := todo("FIXME: second"); !second.Done {
+		t.Error("todo of the removed comment is still open")
+	}
+
+	// A todo completed by hand stays completed while its comment is
+	// still there.
+	if err := db.SetStatus(ctx, todo("TODO: first").ID, StatusDone); err != nil {
+		t.Fatal(err)
+	}
+	report = scan("package a\n\nimport \"fmt\"\n\n// TODO: first\n")
+	if report.Added != 0 || report.Resolved != 0 || !todo("TODO: first").Done {
+		t.Errorf("rescan after completing by hand: %+v", report)
+	}
+}
+
+func TestGitignoreMatcher(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":     "# build output\n*.log\n!keep.log\nbuild/\ndocs/**/*.tmp\n/only-root.txt\n",
+		"sub/.gitignore": "*.gen\n",
+	})
+
+	matcher := newGitignoreMatcher()
+	matcher.load(dir)
+	matcher.load(filepath.Join(dir, "sub"))
+	base := filepath.ToSlash(dir)
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"app.log", false, true},
+		{"sub/app.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"docs/a/b/c.tmp", false, true},
+		{"docs/c.tmp", false, true},
+		{"other/c.tmp", false, false},
+		{"only-root.txt", false, true},
+		{"sub/only-root.txt", false, false},
+		{"sub/x.gen", false, true},
+		{"x.gen", false, false},
+		{"subway/x.gen", false, false},
+	}
+	for _, test := range tests {
+		if got := matcher.ignored(base+"/"+test.path, test.isDir); got != test.ignored {
+			t.Errorf("ignored(%q, dir %v) = %v, want %v", test.path, test.isDir, got, test.ignored)
+		}
+	}
+}
+
+// TestListScanFiles lists the files of a directory outside a git work
+// tree, where kaj reads the .gitignore files itself.
+func TestListScanFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, ".", map[string]string{
+		".gitignore":      "build/\n",
+		"a.go":            "",
+		"build/out.go":    "",
+		"sub/.gitignore":  "*.gen\n",
+		"sub/b.go":        "",
+		"sub/b.gen":       "",
+		"z.gen":           "",
+		".todos/todos.db": "",
+	})
+
+	files, err := listScanFiles([]string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	if want := []string{".gitignore", "a.go", "sub/.gitignore", "sub/b.go", "z.gen"}; !slices.Equal(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// kaj serve exposes the database as a small JSON REST API:
+//
+//	GET    /todos               list, filtered by ?status= &project= &context= &branch= &q=
+//	POST   /todos               add {"text": "...", "branch": "..."}
+//	GET    /todos/{id}          one todo
+//	PATCH  /todos/{id}          update {"text": "...", "status": "..."}
+//	DELETE /todos/{id}          move to the trash
+//	POST   /todos/{id}/toggle   toggle done
+//	POST   /todos/{id}/move     reorder {"direction": "up" | "down"} or {"index": 0}
+//	POST   /undo                undo the last change, like kaj undo
+//	POST   /redo                redo the last undo
+//
+// Every todo response carries an ETag. Requests that change a todo may
+// send it back in If-Match and fail with 412 Precondition Failed when the
+// todo was changed in the meantime.
+//
+// POST and PATCH requests must be sent as application/json, which a web
+// page can only do cross-origin after a CORS preflight that is never
+// granted. Without a token, requests must also name a loopback host or the
+// listen address in Host and Origin, so a page served from a domain that
+// resolves to 127.0.0.1 (DNS rebinding) cannot reach the API either.
+
+type apiServer struct {
+	db    kaj.Store
+	addr  string
+	token string
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func newAPIHandler(db kaj.Store, addr, token string) http.Handler {
+	s := &apiServer{db: db, addr: addr, token: token}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /todos", s.listTodos)
+	mux.HandleFunc("POST /todos", s.addTodo)
+	mux.HandleFunc("GET /todos/{id}", s.getTodo)
+	mux.HandleFunc("PATCH /todos/{id}", s.updateTodo)
+	mux.HandleFunc("DELETE /todos/{id}", s.deleteTodo)
+	mux.HandleFunc("POST /todos/{id}/toggle", s.toggleTodo)
+	mux.HandleFunc("POST /todos/{id}/move", s.moveTodo)
+	mux.HandleFunc("POST /undo", s.undo(false))
+	mux.HandleFunc("POST /redo", s.undo(true))
+
+	return s.checkOrigin(s.authenticate(requireJSON(mux)))
+}
+
+// checkOrigin rejects requests whose Host or Origin names another host
+// when no token is configured.
+func (s *apiServer) checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			if !s.allowedHost(r.Host) {
+				writeAPIError(w, http.StatusForbidden, fmt.Sprintf("host %q is not allowed without --token", r.Host))
+				return
+			}
+			if origin := r.Header.Get("Origin"); origin != "" {
+				u, err := url.Parse(origin)
+				if err != nil || !s.allowedHost(u.Host) {
+					writeAPIError(w, http.StatusForbidden, fmt.Sprintf("origin %q is not allowed without --token", origin))
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost reports whether host, with or without a port, is localhost,
+// a loopback address or the host of the listen address.
+func (s *apiServer) allowedHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if listen, _, err := net.SplitHostPort(s.addr); err == nil && listen != "" && strings.EqualFold(host, listen) {
+		return true
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requireJSON rejects POST and PATCH requests that are not sent as
+// application/json, even those without a body.
+func requireJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost || r.Method == http.MethodPatch {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeAPIError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate requires "Authorization: Bearer <token>" when a token is
+// configured.
+func (s *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="kaj"`)
+				writeAPIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// modifiedError fails a change whose If-Match does not match the todo.
+type modifiedError struct {
+	todo Todo
+}
+
+func (e *modifiedError) Error() string {
+	return fmt.Sprintf("todo %d was modified", e.todo.ID)
+}
+
+// writeStoreError writes an error returned by the store with a status
+// that matches its kind.
+func writeStoreError(w http.ResponseWriter, err error) {
+	var modified *modifiedError
+	if errors.As(err, &modified) {
+		w.Header().Set("ETag", etag(&modified.todo))
+		writeAPIError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, kaj.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, kaj.ErrNothingToUndo), errors.Is(err, kaj.ErrNothingToRedo):
+		status = http.StatusConflict
+	case errors.Is(err, kaj.ErrReadOnly):
+		status = http.StatusForbidden
+	}
+	writeAPIError(w, status, err.Error())
+}
+
+// etag is a hash of the JSON form of v, so it changes whenever any field
+// of a todo does.
+func etag(v any) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// writeTodo writes a todo with its ETag.
+func writeTodo(w http.ResponseWriter, status int, todo *Todo) {
+	w.Header().Set("ETag", etag(todo))
+	writeJSON(w, status, todo)
+}
+
+// todoID parses the ID in the path. It writes the error response itself
+// and returns false on failure.
+func todoID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid id %q", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+// changeContext returns the context for a change to a todo. It makes the
+// store check If-Match in the same transaction as the change.
+func changeContext(r *http.Request) context.Context {
+	match := r.Header.Get("If-Match")
+	if match == "" || match == "*" {
+		return r.Context()
+	}
+	return kaj.WithPrecondition(r.Context(), func(todo Todo) error {
+		if etag(&todo) != match {
+			return &modifiedError{todo: todo}
+		}
+		return nil
+	})
+}
+
+// reply re-reads a todo after a change and writes it.
+func (s *apiServer) reply(w http.ResponseWriter, r *http.Request, status int, id int) {
+	todo, err := s.db.GetTodo(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTodo(w, status, todo)
+}
+
+func (s *apiServer) listTodos(w http.ResponseWriter, r *http.Request) {
+	todos, err := s.db.GetTodos(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	project := query.Get("project")
+	tagContext := query.Get("context")
+	branch := query.Get("branch")
+	text := strings.ToLower(query.Get("q"))
+
+	filtered := []Todo{}
+	for _, todo := range todos {
+		switch {
+		case status != "" && todo.Status != status:
+		case project != "" && !slices.Contains(todo.Projects, project):
+		case tagContext != "" && !slices.Contains(todo.Contexts, tagContext):
+		case branch != "" && todo.Branch != branch:
+		case text != "" && !strings.Contains(strings.ToLower(todo.Text), text):
+		default:
+			filtered = append(filtered, todo)
+		}
+	}
+
+	tag := etag(filtered)
+	w.Header().Set("ETag", tag)
+	if r.Header.Get("If-None-Match") == tag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, filtered)
+}
+
+func (s *apiServer) getTodo(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoID(w, r)
+	if !ok {
+		return
+	}
+	todo, err := s.db.GetTodo(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == etag(todo) {
+		w.Header().Set("ETag", etag(todo))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeTodo(w, http.StatusOK, todo)
+}
+
+func (s *apiServer) addTodo(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Text   string `json:"text"`
+		Branch string `json:"branch"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	text := strings.TrimSpace(body.Text)
+	if text == "" {
+		writeAPIError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+
+	todo := kaj.NewTodo(text)
+	todo.Branch = body.Branch
+	id, err := s.db.AddTodo(r.Context(), todo)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/todos/%d", id))
+	s.reply(w, r, http.StatusCreated, id)
+}
+
+func (s *apiServer) updateTodo(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoID(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Text   *string `json:"text"`
+		Status *string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if body.Text != nil && strings.TrimSpace(*body.Text) == "" {
+		writeAPIError(w, http.StatusBadRequest, "text must not be empty")
+		return
+	}
+	if body.Status != nil && !kaj.ValidStatus(*body.Status) {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid status %q", *body.Status))
+		return
+	}
+
+	var text, status string
+	if body.Text != nil {
+		text = strings.TrimSpace(*body.Text)
+	}
+	if body.Status != nil {
+		status = *body.Status
+	}
+	if text != "" || status != "" {
+		if err := s.db.EditTodo(changeContext(r), id, text, status); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+
+	s.reply(w, r, http.StatusOK, id)
+}
+
+func (s *apiServer) deleteTodo(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.DeleteTodo(changeContext(r), id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) toggleTodo(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.ToggleTodo(changeContext(r), id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.reply(w, r, http.StatusOK, id)
+}
+
+func (s *apiServer) moveTodo(w http.ResponseWriter, r *http.Request) {
+	id, ok := todoID(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Direction string `json:"direction"`
+		Index     *int   `json:"index"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	var err error
+	switch {
+	case body.Index != nil && body.Direction != "":
+		writeAPIError(w, http.StatusBadRequest, "give either direction or index")
+		return
+	case body.Index != nil:
+		if *body.Index < 0 {
+			writeAPIError(w, http.StatusBadRequest, "index must not be negative")
+			return
+		}
+		err = s.db.MoveTodoTo(changeContext(r), id, *body.Index)
+	case body.Direction == "up":
+		err = s.db.MoveTodo(changeContext(r), id, -1, false)
+	case body.Direction == "down":
+		err = s.db.MoveTodo(changeContext(r), id, 1, false)
+	default:
+		writeAPIError(w, http.StatusBadRequest, `direction must be "up" or "down", or index a number`)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.reply(w, r, http.StatusOK, id)
+}
+
+// undo answers with what the undo or redo did, as kaj undo prints it.
+func (s *apiServer) undo(redo bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events, err := undoChange(r.Context(), s.db, redo)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		key := "undid"
+		if redo {
+			key = "redid"
+		}
+		writeJSON(w, http.StatusOK, map[string]string{key: describeBatch(events)})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+func TestAPIRequests(t *testing.T) {
+	store := kaj.NewMemoryStore()
+	id, err := store.AddTodo(t.Context(), kaj.NewTodo("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newAPIHandler(store, "127.0.0.1:7070", "")
+
+	do := func(method, path, contentType, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://127.0.0.1:7070"+path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			if header[i] == "Host" {
+				req.Host = header[i+1]
+			} else {
+				req.Header.Set(header[i], header[i+1])
+			}
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("POST", "/todos/1/toggle", "text/plain", ""); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain POST: %d", rec.Code)
+	}
+	if rec := do("POST", "/todos/1/toggle", "application/json", "", "Origin", "http://evil.example"); rec.Code != http.StatusForbidden {
+		t.Errorf("foreign origin: %d", rec.Code)
+	}
+	if rec := do("GET", "/todos", "", "", "Host", "evil.example:7070"); rec.Code != http.StatusForbidden {
+		t.Errorf("foreign host: %d", rec.Code)
+	}
+	if rec := do("GET", "/todos", "", "", "Host", "localhost:7070", "Origin", "http://[::1]:7070"); rec.Code != http.StatusOK {
+		t.Errorf("loopback: %d", rec.Code)
+	}
+
+	tag := do("GET", "/todos/1", "", "").Header().Get("ETag")
+	rec := do("PATCH", "/todos/1", "application/json", `{"text": "stale"}`, "If-Match", `"stale"`)
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("ETag") != tag {
+		t.Errorf("stale If-Match: %d, ETag %s", rec.Code, rec.Header().Get("ETag"))
+	}
+	rec = do("PATCH", "/todos/1", "application/json", `{"text": "two", "status": "done"}`, "If-Match", tag)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH: %d %s", rec.Code, rec.Body)
+	}
+
+	todo, err := store.GetTodo(t.Context(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.Text != "two" || todo.Status != kaj.StatusDone || todo.CompletedAt == nil {
+		t.Errorf("after PATCH: %+v", todo)
+	}
+
+	if rec := do("DELETE", "/todos/1", "", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("POST", "/undo", "application/json", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"undid"`) {
+		t.Errorf("POST /undo: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("POST", "/undo", "application/json", ""); rec.Code != http.StatusConflict {
+		t.Errorf("POST /undo with nothing to undo: %d", rec.Code)
+	}
+
+	var location string
+	for _, text := range []string{"three @phone", "four"} {
+		rec := do("POST", "/todos", "application/json", `{"text": "`+text+`"}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("POST /todos: %d %s", rec.Code, rec.Body)
+		}
+		location = rec.Header().Get("Location")
+	}
+	if rec := do("GET", "/todos?context=phone", "", ""); !strings.Contains(rec.Body.String(), "three") || strings.Contains(rec.Body.String(), "four") {
+		t.Errorf("GET /todos?context=phone: %s", rec.Body)
+	}
+	moves := []struct {
+		body string
+		code int
+	}{
+		{`{"index": 0}`, http.StatusOK},
+		{`{"direction": "down"}`, http.StatusOK},
+		{`{"index": -1}`, http.StatusBadRequest},
+		{`{"direction": "up", "index": 0}`, http.StatusBadRequest},
+		{`{"direction": "sideways"}`, http.StatusBadRequest},
+	}
+	for _, move := range moves {
+		if rec := do("POST", location+"/move", "application/json", move.body); rec.Code != move.code {
+			t.Errorf("move %s: %d, want %d", move.body, rec.Code, move.code)
+		}
+	}
+	todos, err := store.GetTodos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, todo := range todos {
+		texts = append(texts, todo.Text)
+	}
+	if want := []string{"two", "four", "three @phone"}; !slices.Equal(texts, want) {
+		t.Errorf("after moves: %v, want %v", texts, want)
+	}
+}
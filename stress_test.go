@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	stressWorkers = 8
+	stressRounds  = 10
+)
+
+// runKaj runs kaj in dir, which is also its HOME, and returns its output
+// and exit code, or -1 when it could not be started.
+func runKaj(t *testing.T, dir string, args ...string) (string, int) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "KAJ_TEST_MAIN=1", "HOME="+dir)
+	out, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(out), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Errorf("kaj %s: %v", strings.Join(args, " "), err)
+		return "", -1
+	}
+	return string(out), 0
+}
+
+var listedTodo = regexp.MustCompile(`(?m)^\d+\. `)
+
+// countTodos returns the number of todos kaj list prints.
+func countTodos(t *testing.T, dir string) int {
+	out, status := runKaj(t, dir, "list")
+	if status != 0 {
+		t.Fatalf("kaj list (exit %d): %s", status, out)
+	}
+	return len(listedTodo.FindAllString(out, -1))
+}
+
+// TestStress runs kaj commands from several processes at once against one
+// list and checks that none of them fails and that no todo is lost or
+// duplicated.
+//
+// Commands address todos by list index, so one process may delete the
+// todo another one is about to change. Those commands fail with exit codes
+// 3 to 5 and are counted as races, not failures. undo undoes the last
+// change of any process, so what it undid is read from its output.
+func TestStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts hundreds of kaj processes")
+	}
+
+	for _, format := range []string{formatSQLite, formatText, formatShared} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			switch format {
+			case formatText:
+				runKaj(t, dir, "init", "--format", "text")
+			case formatShared:
+				runKaj(t, dir, "init", "--shared")
+			}
+			if out, status := runKaj(t, dir, "add", "seed"); status != 0 {
+				t.Fatalf("kaj add (exit %d): %s", status, out)
+			}
+
+			var mu sync.Mutex
+			succeeded := map[string]int{}
+			races := 0
+			run := func(args ...string) {
+				out, status := runKaj(t, dir, args...)
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case status == 0 && args[0] == "undo":
+					// "Undid delete of ..."
+					fields := strings.Fields(out)
+					if len(fields) > 1 {
+						succeeded["undo "+fields[1]]++
+					}
+				case status == 0:
+					succeeded[args[0]]++
+				case status >= exitNotFound && status <= exitNothingToUndo:
+					races++
+				default:
+					t.Errorf("kaj %s (exit %d): %s", strings.Join(args, " "), status, out)
+				}
+			}
+
+			var wg sync.WaitGroup
+			for worker := 1; worker <= stressWorkers; worker++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for round := 1; round <= stressRounds; round++ {
+						run("add", fmt.Sprintf("worker %d round %d +stress", worker, round))
+						run("list")
+						run("toggle", "1")
+						run("move", "1", "2")
+						run("top", "2")
+						run("start", "1")
+						run("delete", "1")
+						run("undo")
+					}
+				}()
+			}
+			wg.Wait()
+			if t.Failed() {
+				return
+			}
+
+			want := 1 + succeeded["add"] - succeeded["delete"] + succeeded["undo delete"] - succeeded["undo add"]
+			if got := countTodos(t, dir); got != want {
+				t.Fatalf("found %d todos, want %d", got, want)
+			}
+			t.Logf("%d workers x %d rounds, %d races, %d todos", stressWorkers, stressRounds, races, want)
+
+			switch format {
+			case formatSQLite:
+				runKaj(t, dir, "db", "rebuild")
+				if got := countTodos(t, dir); got != want {
+					t.Errorf("rebuilt %d todos from the change log, want %d", got, want)
+				}
+			case formatText:
+				data, err := os.ReadFile(filepath.Join(dir, ".todos", "todos.txt"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := strings.Count(string(data), "\n"); got != want {
+					t.Errorf("found %d todos in .todos/todos.txt, want %d", got, want)
+				}
+			case formatShared:
+				caches, _ := filepath.Glob(filepath.Join(dir, ".todos", "cache.db*"))
+				for _, cache := range caches {
+					os.Remove(cache)
+				}
+				if got := countTodos(t, dir); got != want {
+					t.Errorf("replayed %d todos from .todos/ops, want %d", got, want)
+				}
+			}
+		})
+	}
+}
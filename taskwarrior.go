@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// Taskwarrior JSON, as written by `task export` and read by `task import`.
+// The project becomes a +project tag and tags become @contexts; on export
+// those words are moved out of the description again. Annotations are
+// kept as note lines. UUIDs are stored in external_ids so that repeated
+// imports update todos instead of duplicating them.
+
+const taskwarriorSource = "taskwarrior"
+
+const taskwarriorTime = "20060102T150405Z"
+
+type taskwarriorAnnotation struct {
+	Entry       string `json:"entry"`
+	Description string `json:"description"`
+}
+
+type taskwarriorTask struct {
+	UUID        string                  `json:"uuid"`
+	Description string                  `json:"description"`
+	Status      string                  `json:"status"`
+	Entry       string                  `json:"entry,omitempty"`
+	Modified    string                  `json:"modified,omitempty"`
+	Start       string                  `json:"start,omitempty"`
+	End         string                  `json:"end,omitempty"`
+	Due         string                  `json:"due,omitempty"`
+	Project     string                  `json:"project,omitempty"`
+	Tags        []string                `json:"tags,omitempty"`
+	Priority    string                  `json:"priority,omitempty"`
+	Annotations []taskwarriorAnnotation `json:"annotations,omitempty"`
+}
+
+var taskwarriorPriorities = map[string]string{"H": "A", "M": "B", "L": "C"}
+
+func priorityToTaskwarrior(priority string) string {
+	switch priority {
+	case "":
+		return ""
+	case "A":
+		return "H"
+	case "B":
+		return "M"
+	default:
+		return "L"
+	}
+}
+
+func parseTaskwarriorTime(field, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(taskwarriorTime, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", field, value)
+	}
+	return &t, nil
+}
+
+func formatTaskwarriorTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(taskwarriorTime)
+}
+
+// decodeTaskwarrior accepts a JSON array as well as the one-object-per-line
+// output of older Taskwarrior versions.
+func decodeTaskwarrior(r io.Reader) ([]taskwarriorTask, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+
+	decoder := json.NewDecoder(br)
+	if b, _ := br.Peek(1); b[0] == '[' {
+		var tasks []taskwarriorTask
+		err := decoder.Decode(&tasks)
+		return tasks, err
+	}
+
+	var tasks []taskwarriorTask
+	for {
+		var task taskwarriorTask
+		err := decoder.Decode(&task)
+		if err == io.EOF {
+			return tasks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+}
+
+func readTaskwarrior(r io.Reader) ([]ExternalTodo, error) {
+	tasks, err := decodeTaskwarrior(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []ExternalTodo
+	for i, task := range tasks {
+		if task.UUID == "" {
+			return nil, fmt.Errorf("task %d has no uuid", i+1)
+		}
+		// Deleted tasks and recurrence templates are not real todos.
+		if task.Status == "deleted" || task.Status == "recurring" {
+			continue
+		}
+
+		todo := Todo{
+			Text:     strings.Join(strings.Fields(task.Description), " "),
+			Status:   StatusTodo,
+			Priority: taskwarriorPriorities[task.Priority],
+		}
+		switch {
+		case task.Status == "completed":
+			todo.Status = StatusDone
+		case task.Start != "":
+			todo.Status = StatusDoing
+		}
+		todo.Done = todo.Status == StatusDone
+
+		if todo.CreatedAt, err = parseTaskwarriorTime("entry", task.Entry); err != nil {
+			return nil, err
+		}
+		if todo.Due, err = parseTaskwarriorTime("due", task.Due); err != nil {
+			return nil, err
+		}
+		if todo.Done {
+			if todo.CompletedAt, err = parseTaskwarriorTime("end", task.End); err != nil {
+				return nil, err
+			}
+		}
+
+		if task.Project != "" {
+			todo.Text = addTag(todo.Text, task.Project)
+		}
+		for _, tag := range task.Tags {
+			todo.Text = addTag(todo.Text, "@"+tag)
+		}
+		todo.Projects, todo.Contexts = kaj.ParseTags(todo.Text)
+
+		var notes []string
+		for _, annotation := range task.Annotations {
+			notes = append(notes, annotation.Description)
+		}
+		todo.Notes = strings.Join(notes, "\n")
+
+		items = append(items, ExternalTodo{Todo: todo, ExternalID: task.UUID})
+	}
+
+	return items, nil
+}
+
+// stripTags removes +project and @context words from a todo text.
+func stripTags(text string) string {
+	var words []string
+	for _, word := range strings.Fields(text) {
+		if len(word) > 1 && (word[0] == '+' || word[0] == '@') {
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
+
+func writeTaskwarrior(ctx context.Context, w io.Writer, db *Database) error {
+	todos, err := db.GetTodos(ctx)
+	if err != nil {
+		return err
+	}
+
+	uuids, err := db.ExternalIDs(ctx, taskwarriorSource)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tasks := []taskwarriorTask{}
+	for _, todo := range todos {
+		uuid, ok := uuids[todo.ID]
+		if !ok {
+			uuid = newUUID()
+			if err := db.LinkExternalID(ctx, taskwarriorSource, uuid, todo.ID); err != nil {
+				return err
+			}
+		}
+
+		entry := todo.CreatedAt
+		if entry == nil {
+			entry = &now
+		}
+
+		task := taskwarriorTask{
+			UUID:        uuid,
+			Description: stripTags(todo.Text),
+			Status:      "pending",
+			Entry:       formatTaskwarriorTime(entry),
+			Modified:    formatTaskwarriorTime(&now),
+			Due:         formatTaskwarriorTime(todo.Due),
+			Priority:    priorityToTaskwarrior(todo.Priority),
+			Tags:        todo.Contexts,
+		}
+		if task.Description == "" {
+			task.Description = todo.Text
+		}
+		if len(todo.Projects) > 0 {
+			task.Project = todo.Projects[0]
+		}
+
+		switch todo.Status {
+		case StatusDone:
+			task.Status = "completed"
+			end := todo.CompletedAt
+			if end == nil {
+				end = &now
+			}
+			task.End = formatTaskwarriorTime(end)
+		case StatusDoing:
+			task.Start = formatTaskwarriorTime(&now)
+		}
+
+		if todo.Notes != "" {
+			for _, line := range strings.Split(todo.Notes, "\n") {
+				task.Annotations = append(task.Annotations, taskwarriorAnnotation{
+					Entry:       task.Modified,
+					Description: line,
+				})
+			}
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(tasks)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+func TestReadTaskwarrior(t *testing.T) {
+	export := `[
+		{"uuid": "a", "description": "write  report", "status": "pending", "project": "work", "tags": ["desk"], "priority": "H", "due": "20240105T090000Z",
+		 "annotations": [{"entry": "20240101T000000Z", "description": "see mail"}, {"entry": "20240102T000000Z", "description": "ask bob"}]},
+		{"uuid": "b", "description": "call mom", "status": "pending", "start": "20240101T100000Z"},
+		{"uuid": "c", "description": "buy milk", "status": "completed", "end": "20240103T120000Z", "priority": "L"},
+		{"uuid": "d", "description": "gone", "status": "deleted"},
+		{"uuid": "e", "description": "weekly", "status": "recurring"},
+		{"uuid": "f", "description": "waiting", "status": "waiting"}
+	]`
+	items, err := readTaskwarrior(strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, item := range items {
+		got = append(got, item.ExternalID+" "+item.Status+" "+item.Priority+" "+item.Text)
+	}
+	want := []string{
+		"a todo A write report +work @desk",
+		"b doing  call mom",
+		"c done C buy milk",
+		"f todo  waiting",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("items:\n%q\nwant:\n%q", got, want)
+	}
+	if items[0].Notes != "see mail\nask bob" || items[0].Due == nil || !items[0].Due.Equal(time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("first item: %+v", items[0].Todo)
+	}
+	if items[2].CompletedAt == nil || !items[2].CompletedAt.Equal(time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("completed at %v", items[2].CompletedAt)
+	}
+
+	// Older versions write one object per line.
+	lines := `{"uuid": "a", "description": "one", "status": "pending"}
+{"uuid": "b", "description": "two", "status": "pending"}`
+	if items, err := readTaskwarrior(strings.NewReader(lines)); err != nil || len(items) != 2 {
+		t.Errorf("one object per line: %+v, %v", items, err)
+	}
+
+	for _, bad := range []string{`[{"description": "no uuid"}]`, `[{"uuid": "a", "due": "tomorrow"}]`} {
+		if _, err := readTaskwarrior(strings.NewReader(bad)); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
+
+// TestTaskwarriorReimport exports todos, changes the export the way
+// Taskwarrior would and imports it, and expects the todos to be updated
+// by their UUIDs.
+func TestTaskwarriorReimport(t *testing.T) {
+	ctx := t.Context()
+	db := testDatabase(t)
+
+	todo := kaj.NewTodo("write report +work @desk")
+	todo.Priority = "B"
+	todo.Notes = "see mail"
+	if _, err := db.AddTodo(ctx, todo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddTodo(ctx, kaj.NewTodo("call mom")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeTaskwarrior(ctx, &buf, db); err != nil {
+		t.Fatal(err)
+	}
+	var tasks []taskwarriorTask
+	if err := json.Unmarshal(buf.Bytes(), &tasks); err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("tasks: %+v", tasks)
+	}
+	first := tasks[0]
+	if first.Description != "write report" || first.Project != "work" || !slices.Equal(first.Tags, []string{"desk"}) ||
+		first.Priority != "M" || first.Status != "pending" || len(first.Annotations) != 1 {
+		t.Errorf("exported %+v", first)
+	}
+
+	tasks[1].Status = "completed"
+	tasks[1].End = "20240103T120000Z"
+	tasks = append(tasks, taskwarriorTask{UUID: newUUID(), Description: "new in taskwarrior", Status: "pending"})
+	data, err := json.Marshal(tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := readTaskwarrior(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, updated, err := db.ImportExternal(ctx, taskwarriorSource, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 || updated != 2 {
+		t.Errorf("reimport: %d added, %d updated", added, updated)
+	}
+	if got := dbTexts(t, db); !slices.Equal(got, []string{"write report +work @desk", "[x] call mom", "new in taskwarrior"}) {
+		t.Errorf("after reimport: %v", got)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme lists the colors used by the TUI and the colored CLI output.
+// Colors are hex codes ("#7D56F4") or ANSI numbers ("12"); an empty
+// value leaves that element uncolored.
+type Theme struct {
+	Extends  string `json:"extends,omitempty"`
+	Title    string `json:"title"`
+	TitleBg  string `json:"title_bg"`
+	Selected string `json:"selected"`
+	Done     string `json:"done"`
+	Help     string `json:"help"`
+	Status   string `json:"status"`
+	Error    string `json:"error"`
+}
+
+var builtinThemes = map[string]Theme{
+	"dark": {
+		Title:    "#FAFAFA",
+		TitleBg:  "#7D56F4",
+		Selected: "#FF6B6B",
+		Done:     "#4ECDC4",
+		Help:     "#626262",
+		Status:   "#4ECDC4",
+		Error:    "#FF6B6B",
+	},
+	"light": {
+		Title:    "#FFFFFF",
+		TitleBg:  "#5A3FC0",
+		Selected: "#C0392B",
+		Done:     "#16837A",
+		Help:     "#767676",
+		Status:   "#16837A",
+		Error:    "#C0392B",
+	},
+	"high-contrast": {
+		Title:    "0",
+		TitleBg:  "11",
+		Selected: "12",
+		Done:     "10",
+		Help:     "7",
+		Status:   "14",
+		Error:    "9",
+	},
+}
+
+var (
+	titleStyle    lipgloss.Style
+	selectedStyle lipgloss.Style
+	doneStyle     lipgloss.Style
+	helpStyle     lipgloss.Style
+	statusStyle   lipgloss.Style
+	errorStyle    lipgloss.Style
+)
+
+func init() {
+	applyTheme(builtinThemes["dark"])
+}
+
+// resolveTheme picks the theme named in the config. "auto" (the default)
+// chooses dark or light from the terminal background, and NO_COLOR
+// disables colors regardless of the config.
+func resolveTheme(config *Config) (Theme, error) {
+	if os.Getenv("NO_COLOR") != "" {
+		return Theme{}, nil
+	}
+
+	return lookupTheme(config, config.Theme, 0)
+}
+
+func lookupTheme(config *Config, name string, depth int) (Theme, error) {
+	if depth > len(config.Themes) {
+		return Theme{}, fmt.Errorf("theme %q extends itself", name)
+	}
+
+	if name == "" || name == "auto" {
+		if lipgloss.HasDarkBackground() {
+			return builtinThemes["dark"], nil
+		}
+		return builtinThemes["light"], nil
+	}
+
+	if custom, ok := config.Themes[name]; ok {
+		base, err := lookupTheme(config, custom.Extends, depth+1)
+		if err != nil {
+			return Theme{}, err
+		}
+		return mergeTheme(base, custom), nil
+	}
+
+	if theme, ok := builtinThemes[name]; ok {
+		return theme, nil
+	}
+
+	return Theme{}, fmt.Errorf("unknown theme %q", name)
+}
+
+// mergeTheme overrides the colors of base with those set in custom.
+func mergeTheme(base, custom Theme) Theme {
+	pick := func(b, c string) string {
+		if c != "" {
+			return c
+		}
+		return b
+	}
+
+	return Theme{
+		Title:    pick(base.Title, custom.Title),
+		TitleBg:  pick(base.TitleBg, custom.TitleBg),
+		Selected: pick(base.Selected, custom.Selected),
+		Done:     pick(base.Done, custom.Done),
+		Help:     pick(base.Help, custom.Help),
+		Status:   pick(base.Status, custom.Status),
+		Error:    pick(base.Error, custom.Error),
+	}
+}
+
+func colored(style lipgloss.Style, color string) lipgloss.Style {
+	if color == "" {
+		return style
+	}
+	return style.Foreground(lipgloss.Color(color))
+}
+
+func applyTheme(t Theme) {
+	titleStyle = colored(lipgloss.NewStyle().Bold(true).Padding(0, 1), t.Title)
+	if t.TitleBg != "" {
+		titleStyle = titleStyle.Background(lipgloss.Color(t.TitleBg))
+	} else {
+		titleStyle = titleStyle.Reverse(true)
+	}
+
+	selectedStyle = colored(lipgloss.NewStyle().Bold(true), t.Selected)
+	doneStyle = colored(lipgloss.NewStyle().Strikethrough(true), t.Done)
+	helpStyle = colored(lipgloss.NewStyle(), t.Help)
+	statusStyle = colored(lipgloss.NewStyle(), t.Status)
+	errorStyle = colored(lipgloss.NewStyle().Bold(t.Error == ""), t.Error)
+}
+
+// loadTheme resolves and applies the configured theme.
+func loadTheme(config *Config) error {
+	theme, err := resolveTheme(config)
+	if err != nil {
+		return err
+	}
+	applyTheme(theme)
+	return nil
+}
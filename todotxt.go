@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// todo.txt files are read and written line by line with
+// kaj.ParseTodoTxtLine and kaj.FormatTodoTxtLine.
+
+func readTodoTxt(r io.Reader) ([]Todo, error) {
+	var todos []Todo
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		todo, err := kaj.ParseTodoTxtLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		todos = append(todos, todo)
+	}
+
+	return todos, scanner.Err()
+}
+
+func writeTodoTxt(w io.Writer, todos []Todo) error {
+	for _, todo := range todos {
+		if _, err := fmt.Fprintln(w, kaj.FormatTodoTxtLine(todo)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
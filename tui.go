@@ -1,113 +1,281 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mdmmn378/kaj/pkg/kaj"
 )
 
-var (
-	titleStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("#FAFAFA")).
-			Background(lipgloss.Color("#7D56F4")).
-			Padding(0, 1)
+// statusDuration is how long a toast stays visible in the footer.
+const statusDuration = 4 * time.Second
 
-	selectedStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("#FF6B6B"))
+// pollInterval is how often the TUI checks the database for changes
+// made by other kaj processes.
+const pollInterval = time.Second
 
-	doneStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#4ECDC4")).
-			Strikethrough(true)
+// dataVersionMsg carries the result of polling Database.DataVersion.
+type dataVersionMsg struct {
+	version int64
+	err     error
+}
 
-	helpStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#626262"))
-)
+// clearStatusMsg is sent by tea.Tick when a toast expires. The id lets
+// a stale tick be ignored once a newer toast has replaced it.
+type clearStatusMsg struct {
+	id int
+}
 
 type model struct {
 	todos       []Todo
 	cursor      int
-	db          *Database
+	db          kaj.Store
 	err         error
-	mode        string // "list", "add", "edit"
+	mode        string // "list", "board", "add", "edit", "confirm", "help"
+	view        string // "list" or "board", the mode that overlays return to
 	input       string
 	inputCursor int
 	editID      int
+	keys        keyMap
+	branch      string // recorded on todos added in the TUI
+
+	width         int
+	boardCol      int
+	confirmDelete bool
+	status        string
+	statusIsErr   bool
+	statusID      int
+	dataVersion   int64
 }
 
 func initialModel() model {
+	config, err := LoadConfig()
+	if err != nil {
+		return model{err: err}
+	}
+
+	if err := loadTheme(config); err != nil {
+		return model{err: err}
+	}
+
+	keys, err := newKeyMap(config.Keys)
+	if err != nil {
+		return model{err: fmt.Errorf("invalid keybindings: %v", err)}
+	}
+
 	db, err := NewDatabase()
 	if err != nil {
 		return model{err: err}
 	}
 
-	todos, err := db.GetTodos()
+	version, err := db.DataVersion(context.Background())
+	if err != nil {
+		return model{err: err, db: db}
+	}
+
+	todos, err := db.GetTodos(context.Background())
 	if err != nil {
 		return model{err: err, db: db}
 	}
 
+	branch, _ := newTodoBranch(false, config)
+
 	return model{
-		todos:  todos,
-		cursor: 0,
-		db:     db,
-		mode:   "list",
+		todos:         todos,
+		cursor:        0,
+		db:            db,
+		mode:          "list",
+		view:          "list",
+		keys:          keys,
+		branch:        branch,
+		dataVersion:   version,
+		confirmDelete: confirmDelete || config.ConfirmDelete,
 	}
 }
 
-func (m model) Init() tea.Cmd {
+// setStatus shows a transient message in the footer and returns the
+// command that clears it again after statusDuration.
+func (m *model) setStatus(text string, isErr bool) tea.Cmd {
+	m.statusID++
+	m.status = text
+	m.statusIsErr = isErr
+	id := m.statusID
+	return tea.Tick(statusDuration, func(time.Time) tea.Msg {
+		return clearStatusMsg{id: id}
+	})
+}
+
+// setError reports a non-fatal error as a toast instead of replacing
+// the whole view.
+func (m *model) setError(err error) tea.Cmd {
+	return m.setStatus(fmt.Sprintf("Error: %v", err), true)
+}
+
+// reload fetches the todos again, keeping the cursor on the same todo
+// if it still exists.
+func (m *model) reload() error {
+	selectedID := -1
+	if m.cursor < len(m.todos) {
+		selectedID = m.todos[m.cursor].ID
+	}
+
+	todos, err := m.db.GetTodos(context.Background())
+	if err != nil {
+		return err
+	}
+	m.todos = todos
+	for i, todo := range m.todos {
+		if todo.ID == selectedID {
+			m.cursor = i
+		}
+	}
+	if m.cursor >= len(m.todos) && len(m.todos) > 0 {
+		m.cursor = len(m.todos) - 1
+	}
+	if len(m.todos) == 0 {
+		m.cursor = 0
+	}
+	m.followCursor()
 	return nil
 }
 
+// returnToView leaves an overlay mode such as add or help.
+func (m *model) returnToView() {
+	m.mode = m.view
+	m.followCursor()
+}
+
+func (m model) deleteSelected() (tea.Model, tea.Cmd) {
+	todo := m.todos[m.cursor]
+	err := m.db.DeleteTodo(context.Background(), todo.ID)
+	if err != nil {
+		return m, m.setError(err)
+	}
+
+	if err := m.reload(); err != nil {
+		return m, m.setError(err)
+	}
+
+	return m, m.setStatus(fmt.Sprintf("Deleted '%s' — press %s to undo", todo.Text, m.keys.Undo.help()), false)
+}
+
+func (m model) Init() tea.Cmd {
+	if m.db == nil {
+		return nil
+	}
+	return m.pollDataVersion()
+}
+
+func (m model) pollDataVersion() tea.Cmd {
+	db := m.db
+	return tea.Tick(pollInterval, func(time.Time) tea.Msg {
+		version, err := db.DataVersion(context.Background())
+		return dataVersionMsg{version: version, err: err}
+	})
+}
+
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+
+	case dataVersionMsg:
+		if msg.err != nil {
+			return m, tea.Batch(m.setError(msg.err), m.pollDataVersion())
+		}
+		// Skip reloading while a delete is being confirmed so the
+		// prompt keeps pointing at the same todo; the next poll
+		// picks the change up.
+		if msg.version != m.dataVersion && m.mode != "confirm" {
+			if err := m.reload(); err != nil {
+				return m, tea.Batch(m.setError(err), m.pollDataVersion())
+			}
+			m.dataVersion = msg.version
+		}
+		return m, m.pollDataVersion()
+
+	case clearStatusMsg:
+		if msg.id == m.statusID {
+			m.status = ""
+			m.statusIsErr = false
+		}
+
 	case tea.KeyMsg:
+		if m.err != nil {
+			switch msg.String() {
+			case "ctrl+c", "q":
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+
 		switch m.mode {
 		case "list":
 			return m.updateList(msg)
+		case "board":
+			return m.updateBoard(msg)
 		case "add":
 			return m.updateAdd(msg)
 		case "edit":
 			return m.updateEdit(msg)
+		case "confirm":
+			return m.updateConfirm(msg)
+		case "help":
+			return m.updateHelp(msg)
 		}
 	}
 	return m, nil
 }
 
 func (m model) updateList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "ctrl+c", "q":
+	switch {
+	case msg.String() == "ctrl+c", m.keys.Quit.matches(msg):
 		return m, tea.Quit
 
-	case "up", "k":
+	case m.keys.Help.matches(msg):
+		m.mode = "help"
+
+	case m.keys.Board.matches(msg):
+		m.view = "board"
+		m.mode = "board"
+		m.followCursor()
+
+	case m.keys.Up.matches(msg):
 		if m.cursor > 0 {
 			m.cursor--
 		}
 
-	case "down", "j":
+	case m.keys.Down.matches(msg):
 		if m.cursor < len(m.todos)-1 {
 			m.cursor++
 		}
 
-	case "enter", " ":
+	case m.keys.Toggle.matches(msg):
 		if len(m.todos) > 0 {
 			todo := m.todos[m.cursor]
-			err := m.db.ToggleTodo(todo.ID)
+			err := m.db.ToggleTodo(context.Background(), todo.ID)
 			if err != nil {
-				m.err = err
-				return m, nil
+				return m, m.setError(err)
+			}
+			if todo.Status == StatusDone {
+				m.todos[m.cursor].Status = StatusTodo
+			} else {
+				m.todos[m.cursor].Status = StatusDone
 			}
-			m.todos[m.cursor].Done = !m.todos[m.cursor].Done
+			m.todos[m.cursor].Done = m.todos[m.cursor].Status == StatusDone
 		}
 
-	case "a":
+	case m.keys.Add.matches(msg):
 		m.mode = "add"
 		m.input = ""
 		m.inputCursor = 0
 
-	case "e":
+	case m.keys.Edit.matches(msg):
 		if len(m.todos) > 0 {
 			m.mode = "edit"
 			m.editID = m.todos[m.cursor].ID
@@ -115,129 +283,139 @@ func (m model) updateList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.inputCursor = len(m.input)
 		}
 
-	case "d":
+	case m.keys.Delete.matches(msg):
 		if len(m.todos) > 0 {
-			todo := m.todos[m.cursor]
-			err := m.db.DeleteTodo(todo.ID)
-			if err != nil {
-				m.err = err
+			if m.confirmDelete {
+				m.mode = "confirm"
 				return m, nil
 			}
-
-			todos, err := m.db.GetTodos()
-			if err != nil {
-				m.err = err
-				return m, nil
-			}
-			m.todos = todos
-
-			if m.cursor >= len(m.todos) && len(m.todos) > 0 {
-				m.cursor = len(m.todos) - 1
-			}
-			if len(m.todos) == 0 {
-				m.cursor = 0
-			}
+			return m.deleteSelected()
 		}
 
-	case "r":
-		todos, err := m.db.GetTodos()
-		if err != nil {
-			m.err = err
-			return m, nil
-		}
-		m.todos = todos
-		if m.cursor >= len(m.todos) && len(m.todos) > 0 {
-			m.cursor = len(m.todos) - 1
+	case m.keys.Refresh.matches(msg):
+		if err := m.reload(); err != nil {
+			return m, m.setError(err)
 		}
-		if len(m.todos) == 0 {
-			m.cursor = 0
+
+	case m.keys.Undo.matches(msg), m.keys.Redo.matches(msg):
+		redo := m.keys.Redo.matches(msg)
+		events, err := undoChange(context.Background(), m.db, redo)
+		switch {
+		case errors.Is(err, kaj.ErrNothingToUndo):
+			return m, m.setStatus("Nothing to undo", true)
+		case errors.Is(err, kaj.ErrNothingToRedo):
+			return m, m.setStatus("Nothing to redo", true)
+		case err != nil:
+			return m, m.setError(err)
 		}
 
-	case "u":
-		_, err := m.db.UndoLastDelete()
-		if err != nil {
-			if err.Error() == "sql: no rows in result set" {
-				m.err = fmt.Errorf("no recently deleted todos to restore")
-			} else {
-				m.err = err
+		if err := m.reload(); err != nil {
+			return m, m.setError(err)
+		}
+		for i, todo := range m.todos {
+			if len(events) > 0 && todo.ID == events[0].Todo.ID {
+				m.cursor = i
 			}
-			return m, nil
 		}
-
-		todos, err := m.db.GetTodos()
-		if err != nil {
-			m.err = err
-			return m, nil
+		done := "Undid "
+		if redo {
+			done = "Redid "
 		}
-		m.todos = todos
-		m.cursor = len(m.todos) - 1
+		return m, m.setStatus(done+describeBatch(events), false)
 
-	case "ctrl+up", "K":
+	case m.keys.MoveUp.matches(msg):
 		if len(m.todos) > 0 && m.cursor > 0 {
 			todo := m.todos[m.cursor]
-			err := m.db.MoveTodoUp(todo.ID)
+			err := m.db.MoveTodo(context.Background(), todo.ID, -1, false)
 			if err != nil {
-				m.err = err
-				return m, nil
+				return m, m.setError(err)
 			}
 
-			todos, err := m.db.GetTodos()
+			todos, err := m.db.GetTodos(context.Background())
 			if err != nil {
-				m.err = err
-				return m, nil
+				return m, m.setError(err)
 			}
 			m.todos = todos
 			m.cursor--
 		}
 
-	case "ctrl+down", "J":
+	case m.keys.MoveDown.matches(msg):
 		if len(m.todos) > 0 && m.cursor < len(m.todos)-1 {
 			todo := m.todos[m.cursor]
-			err := m.db.MoveTodoDown(todo.ID)
+			err := m.db.MoveTodo(context.Background(), todo.ID, 1, false)
 			if err != nil {
-				m.err = err
-				return m, nil
+				return m, m.setError(err)
 			}
 
-			todos, err := m.db.GetTodos()
+			todos, err := m.db.GetTodos(context.Background())
 			if err != nil {
-				m.err = err
-				return m, nil
+				return m, m.setError(err)
 			}
 			m.todos = todos
 			m.cursor++
 		}
+
+	case m.keys.MoveTop.matches(msg):
+		if len(m.todos) > 0 && m.cursor > 0 {
+			return m.moveSelectedTo(0)
+		}
+
+	case m.keys.MoveBottom.matches(msg):
+		if len(m.todos) > 0 && m.cursor < len(m.todos)-1 {
+			return m.moveSelectedTo(len(m.todos) - 1)
+		}
 	}
 
 	return m, nil
 }
 
+// moveSelectedTo moves the selected todo to index of the list and keeps
+// it selected.
+func (m model) moveSelectedTo(index int) (tea.Model, tea.Cmd) {
+	id := m.todos[m.cursor].ID
+	if err := m.db.MoveTodoTo(context.Background(), id, index); err != nil {
+		return m, m.setError(err)
+	}
+
+	todos, err := m.db.GetTodos(context.Background())
+	if err != nil {
+		return m, m.setError(err)
+	}
+	m.todos = todos
+	for i, todo := range m.todos {
+		if todo.ID == id {
+			m.cursor = i
+		}
+	}
+	return m, nil
+}
+
 func (m model) updateAdd(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "ctrl+c":
 		return m, tea.Quit
 
 	case "esc":
-		m.mode = "list"
+		m.returnToView()
 		m.input = ""
 
 	case "enter":
 		if m.input != "" {
-			err := m.db.AddTodo(m.input)
+			todo := kaj.NewTodo(m.input)
+			todo.Branch = m.branch
+			_, err := m.db.AddTodo(context.Background(), todo)
 			if err != nil {
-				m.err = err
-				return m, nil
+				return m, m.setError(err)
 			}
 
-			todos, err := m.db.GetTodos()
+			todos, err := m.db.GetTodos(context.Background())
 			if err != nil {
-				m.err = err
-				return m, nil
+				return m, m.setError(err)
 			}
 			m.todos = todos
 			m.cursor = len(m.todos) - 1
 		}
-		m.mode = "list"
+		m.returnToView()
 		m.input = ""
 
 	case "left":
@@ -283,25 +461,23 @@ func (m model) updateEdit(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, tea.Quit
 
 	case "esc":
-		m.mode = "list"
+		m.returnToView()
 		m.input = ""
 
 	case "enter":
 		if m.input != "" {
-			err := m.db.UpdateTodo(m.editID, m.input)
+			err := m.db.UpdateTodo(context.Background(), m.editID, m.input)
 			if err != nil {
-				m.err = err
-				return m, nil
+				return m, m.setError(err)
 			}
 
-			todos, err := m.db.GetTodos()
+			todos, err := m.db.GetTodos(context.Background())
 			if err != nil {
-				m.err = err
-				return m, nil
+				return m, m.setError(err)
 			}
 			m.todos = todos
 		}
-		m.mode = "list"
+		m.returnToView()
 		m.input = ""
 
 	case "left":
@@ -341,6 +517,95 @@ func (m model) updateEdit(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+func (m model) updateConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+
+	case "y", "Y", "enter":
+		m.returnToView()
+		if len(m.todos) > 0 {
+			return m.deleteSelected()
+		}
+
+	case "n", "N", "esc", "q":
+		m.returnToView()
+	}
+
+	return m, nil
+}
+
+func (m model) updateHelp(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.String() == "ctrl+c":
+		return m, tea.Quit
+
+	case msg.String() == "esc", m.keys.Help.matches(msg), m.keys.Quit.matches(msg):
+		m.returnToView()
+	}
+
+	return m, nil
+}
+
+// statusLine renders the delete confirmation prompt or the current
+// toast, followed by a blank line, or nothing if neither is active.
+func (m model) statusLine() string {
+	if m.mode == "confirm" {
+		return errorStyle.Render(fmt.Sprintf("Delete '%s'? (y/n)", m.todos[m.cursor].Text)) + "\n\n"
+	}
+	if m.status == "" {
+		return ""
+	}
+
+	style := statusStyle
+	if m.statusIsErr {
+		style = errorStyle
+	}
+	return style.Render(m.status) + "\n\n"
+}
+
+// footerHelp lists the bindings that are useful in the current context.
+// The full list is available in the help overlay.
+func (m model) footerHelp() string {
+	bindings := []keyBinding{m.keys.Add}
+	if len(m.todos) > 0 {
+		bindings = append(bindings, m.keys.Toggle, m.keys.Edit, m.keys.Delete)
+	}
+	bindings = append(bindings, m.keys.Help, m.keys.Quit)
+
+	parts := make([]string, len(bindings))
+	for i, b := range bindings {
+		parts[i] = fmt.Sprintf("%s: %s", b.help(), b.desc)
+	}
+	return strings.Join(parts, " • ")
+}
+
+func (m model) helpView() string {
+	var s strings.Builder
+
+	s.WriteString("Keybindings:\n\n")
+
+	width := 0
+	for _, b := range m.keys.bindings() {
+		if w := lipgloss.Width(b.help()); w > width {
+			width = w
+		}
+	}
+
+	for _, b := range m.keys.bindings() {
+		keys := b.help()
+		padding := strings.Repeat(" ", width-lipgloss.Width(keys))
+		s.WriteString(fmt.Sprintf("  %s%s  %s\n", keys, padding, b.desc))
+	}
+
+	s.WriteString("\n")
+	s.WriteString(helpStyle.Render("Rebind keys in the \"keys\" section of ~/.todos/config.json"))
+	s.WriteString("\n\n")
+	s.WriteString(helpStyle.Render(fmt.Sprintf("%s/esc: close help", m.keys.Help.help())))
+
+	return s.String()
+}
+
 func (m model) View() string {
 	if m.err != nil {
 		return fmt.Sprintf("Error: %v\n\nPress q to quit.", m.err)
@@ -352,6 +617,9 @@ func (m model) View() string {
 	s.WriteString("\n\n")
 
 	switch m.mode {
+	case "help":
+		s.WriteString(m.helpView())
+
 	case "add":
 		s.WriteString("Add new todo:\n")
 		inputWithCursor := m.input[:m.inputCursor] + "│" + m.input[m.inputCursor:]
@@ -366,7 +634,15 @@ func (m model) View() string {
 		s.WriteString("\n\n")
 		s.WriteString(helpStyle.Render("Enter to save • Esc to cancel • ←/→ to move cursor"))
 
-	default: // list mode
+	default: // list, board and confirm modes
+		if m.view == "board" {
+			s.WriteString(m.boardView())
+			s.WriteString("\n\n")
+			s.WriteString(m.statusLine())
+			s.WriteString(helpStyle.Render(m.boardFooterHelp()))
+			break
+		}
+
 		if len(m.todos) == 0 {
 			s.WriteString("No todos yet. Press 'a' to add one!\n\n")
 		} else {
@@ -379,12 +655,17 @@ func (m model) View() string {
 				checked := " "
 				if todo.Done {
 					checked = "✓"
+				} else if todo.Status == StatusDoing {
+					checked = "~"
 				}
 
 				text := todo.Text
 				if todo.Done {
 					text = doneStyle.Render(text)
 				}
+				if todo.Branch != "" {
+					text += " " + helpStyle.Render(branchBadge+todo.Branch)
+				}
 
 				line := fmt.Sprintf("%s [%s] %s", cursor, checked, text)
 				if m.cursor == i {
@@ -397,7 +678,8 @@ func (m model) View() string {
 		}
 
 		s.WriteString("\n")
-		s.WriteString(helpStyle.Render("a: add • e: edit • d: delete • u: undo • space/enter: toggle • Ctrl+↑/J: move up • Ctrl+↓/K: move down • r: refresh • q: quit"))
+		s.WriteString(m.statusLine())
+		s.WriteString(helpStyle.Render(m.footerHelp()))
 	}
 
 	return s.String()
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mdmmn378/kaj/pkg/kaj"
+)
+
+// testModel returns a model on store with the default keys, showing
+// view.
+func testModel(t *testing.T, store kaj.Store, view string) model {
+	t.Helper()
+	todos, err := store.GetTodos(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model{todos: todos, db: store, mode: view, view: view, keys: defaultKeyMap()}
+}
+
+// press sends keys to m, one at a time.
+func press(m model, keys ...string) model {
+	for _, key := range keys {
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+		if key == " " {
+			msg = tea.KeyMsg{Type: tea.KeySpace}
+		}
+		next, _ := m.Update(msg)
+		m = next.(model)
+	}
+	return m
+}
+
+// TestBoardStatusTransitions moves a todo across the board columns, and
+// expects its status and completion time to follow.
+func TestBoardStatusTransitions(t *testing.T) {
+	ctx := t.Context()
+	store := kaj.NewMemoryStore()
+	id, err := store.AddTodo(ctx, kaj.NewTodo("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddTodo(ctx, kaj.NewTodo("two")); err != nil {
+		t.Fatal(err)
+	}
+	m := testModel(t, store, "board")
+
+	steps := []struct {
+		key    string
+		status string
+		col    int
+	}{
+		{"l", StatusDoing, 1},
+		{"l", StatusDone, 2},
+		{"l", StatusDone, 2}, // there is no column after Done
+		{"h", StatusDoing, 1},
+		{" ", StatusDone, 2}, // toggling completes a todo in progress
+		{" ", StatusTodo, 0},
+		{"h", StatusTodo, 0},
+	}
+	for i, step := range steps {
+		m = press(m, step.key)
+		todo, err := store.GetTodo(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if todo.Status != step.status || todo.Done != (step.status == StatusDone) || (todo.CompletedAt != nil) != todo.Done {
+			t.Errorf("step %d (%q): status %s, done %v, completed %v, want %s", i, step.key, todo.Status, todo.Done, todo.CompletedAt, step.status)
+		}
+		if m.boardCol != step.col || m.todos[m.cursor].ID != id {
+			t.Errorf("step %d (%q): focus on column %d todo %d, want column %d todo %d", i, step.key, m.boardCol, m.todos[m.cursor].ID, step.col, id)
+		}
+	}
+
+	// Going back to the list keeps the selection.
+	if m = press(m, "b"); m.mode != "list" || m.todos[m.cursor].ID != id {
+		t.Errorf("list view: mode %s, cursor on %d", m.mode, m.todos[m.cursor].ID)
+	}
+}
+
+// TestReloadOnExternalChange changes the database through another store,
+// as another kaj process would, and expects the next poll to show the
+// change with the same todo selected.
+func TestReloadOnExternalChange(t *testing.T) {
+	ctx := t.Context()
+	path := filepath.Join(t.TempDir(), "todos.db")
+	store, err := kaj.OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	other, err := kaj.OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	ids := addTestTodos(t, store, "a", "b")
+	m := testModel(t, store, "list")
+	m.cursor = 1
+	poll := func() {
+		t.Helper()
+		version, err := store.DataVersion(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, _ := m.Update(dataVersionMsg{version: version})
+		m = next.(model)
+	}
+	if m.dataVersion, err = store.DataVersion(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := other.AddTodo(ctx, kaj.NewTodo("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.MoveTodoTo(ctx, ids[1], 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not while a delete is being confirmed.
+	m.mode = "confirm"
+	poll()
+	if len(m.todos) != 2 {
+		t.Errorf("reloaded while confirming: %d todos", len(m.todos))
+	}
+
+	m.mode = "list"
+	poll()
+	if len(m.todos) != 3 || m.todos[m.cursor].ID != ids[1] {
+		t.Errorf("after the poll: %d todos, cursor on %d, want 3 and %d", len(m.todos), m.todos[m.cursor].ID, ids[1])
+	}
+
+	// The selected todo was deleted elsewhere.
+	if err := other.DeleteTodo(ctx, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	poll()
+	if len(m.todos) != 2 || m.cursor != 1 {
+		t.Errorf("after an external delete: %d todos, cursor %d", len(m.todos), m.cursor)
+	}
+}
+
+// addTestTodos adds todos with texts to store and returns their IDs.
+func addTestTodos(t *testing.T, store kaj.Store, texts ...string) []int {
+	t.Helper()
+	var ids []int
+	for _, text := range texts {
+		id, err := store.AddTodo(t.Context(), kaj.NewTodo(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}